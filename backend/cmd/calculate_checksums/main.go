@@ -1,17 +1,13 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"ecfr-analyzer/internal/database"
-	"ecfr-analyzer/internal/models"
-
-	"github.com/google/uuid"
+	"ecfr-analyzer/internal/services"
 )
 
 func main() {
@@ -62,121 +58,20 @@ func printError(msg string) {
 	fmt.Printf("\033[0;31m[ERROR]\033[0m %s\n", msg)
 }
 
+// calculateAllAgencyChecksums caches agency checksums the same way the server
+// does, from the content version of each title in effect now.
 func calculateAllAgencyChecksums() error {
-	// Get all agencies
-	var agencies []models.Agency
-	if err := database.DB.Find(&agencies).Error; err != nil {
-		return fmt.Errorf("failed to fetch agencies: %w", err)
-	}
-
-	printStatus(fmt.Sprintf("Found %d agencies to process", len(agencies)))
-
-	successCount := 0
-	errorCount := 0
-	skippedCount := 0
-
-	// Process each agency
-	for i, agency := range agencies {
-		result, err := calculateAndStoreAgencyChecksum(agency.ID)
-		if err != nil {
-			printError(fmt.Sprintf("Failed to process %s: %v", agency.Name, err))
-			errorCount++
-			continue
-		}
-		
-		switch result {
-		case "created":
-			successCount++
-		case "updated": 
-			successCount++
-		case "skipped":
-			skippedCount++
-		}
-		
-		// Progress indicator
-		if (i+1)%10 == 0 || i+1 == len(agencies) {
-			printStatus(fmt.Sprintf("Progress: %d/%d agencies processed", i+1, len(agencies)))
-		}
+	stats, err := services.NewChecksumService().RecalculateAll()
+	if err != nil {
+		return err
 	}
 
 	printSuccess(fmt.Sprintf("Calculation completed: %d created/updated, %d skipped (no change), %d errors", 
-		successCount, skippedCount, errorCount))
+		stats.CreatedUpdated, stats.Skipped, stats.Errors))
 	
-	if errorCount > 0 {
-		return fmt.Errorf("%d agencies failed to process", errorCount)
+	if stats.Errors > 0 {
+		return fmt.Errorf("%d agencies failed to process", stats.Errors)
 	}
 	
 	return nil
 }
-
-func calculateAndStoreAgencyChecksum(agencyID uuid.UUID) (string, error) {
-	// Get all title checksums for this agency (using existing title_contents.checksum)
-	type TitleChecksum struct {
-		TitleNumber int
-		Checksum    string
-	}
-
-	var titleChecksums []TitleChecksum
-	err := database.DB.Table("title_contents tc").
-		Select("t.number as title_number, tc.checksum").
-		Joins("JOIN titles t ON tc.title_id = t.id").
-		Joins("JOIN agency_cfr_references acr ON acr.title_id = t.id").
-		Where("acr.agency_id = ? AND tc.checksum IS NOT NULL AND tc.checksum != ''", agencyID).
-		Order("t.number ASC"). // Deterministic order
-		Scan(&titleChecksums).Error
-
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch title checksums: %w", err)
-	}
-
-	if len(titleChecksums) == 0 {
-		// No content for this agency, skip
-		return "skipped", nil
-	}
-
-	// Create deterministic content hash from title checksums
-	var contentBuilder strings.Builder
-	for _, tc := range titleChecksums {
-		contentBuilder.WriteString(fmt.Sprintf("TITLE_%d:%s\n", tc.TitleNumber, tc.Checksum))
-	}
-
-	contentHash := fmt.Sprintf("%x", sha256.Sum256([]byte(contentBuilder.String())))
-	
-	// Create agency checksum from the combined content
-	agencyChecksum := fmt.Sprintf("%x", sha256.Sum256([]byte(contentBuilder.String())))
-
-	// Check if we need to update (content changed)
-	var existingChecksum models.AgencyChecksum
-	err = database.DB.Where("agency_id = ?", agencyID).First(&existingChecksum).Error
-	
-	if err == nil {
-		// Record exists, check if content hash changed
-		if existingChecksum.ContentHash == contentHash {
-			// No change needed
-			return "skipped", nil
-		}
-		
-		// Update existing record
-		existingChecksum.Checksum = agencyChecksum
-		existingChecksum.ContentHash = contentHash
-		existingChecksum.UpdatedAt = time.Now().UTC()
-		
-		if err := database.DB.Save(&existingChecksum).Error; err != nil {
-			return "", err
-		}
-		return "updated", nil
-	} else {
-		// Create new record
-		newChecksum := models.AgencyChecksum{
-			AgencyID:    agencyID,
-			Checksum:    agencyChecksum,
-			ContentHash: contentHash,
-			UpdatedAt:   time.Now().UTC(),
-		}
-		
-		if err := database.DB.Create(&newChecksum).Error; err != nil {
-			return "", err
-		}
-		return "created", nil
-	}
-}
\ No newline at end of file
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+
+	"ecfr-analyzer/internal/ecfrfake"
+)
+
+// Runs the fake eCFR/govinfo server so the backend can import offline:
+//
+//	ECFR_BASE_URL=http://localhost:8090
+//	GOVINFO_BULK_BASE_URL=http://localhost:8090/bulkdata/ECFR
+func main() {
+	addr := os.Getenv("FAKE_ECFR_ADDR")
+	if addr == "" {
+		addr = ":8090"
+	}
+
+	var fixtures fs.FS = ecfrfake.Fixtures()
+	if dir := os.Getenv("FAKE_ECFR_FIXTURES"); dir != "" {
+		fixtures = os.DirFS(dir)
+		log.Printf("Serving fixtures from %s", dir)
+	}
+
+	log.Printf("Fake eCFR server starting on %s", addr)
+	if err := http.ListenAndServe(addr, ecfrfake.NewHandler(fixtures)); err != nil {
+		log.Fatal("Fake eCFR server failed to start:", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Command migrate_blobs moves title XML from title_contents.xml_content into
+// the blob store and clears the column. With -drop-column it also drops the
+// column once every row has been moved.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"ecfr-analyzer/internal/blobstore"
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+)
+
+func main() {
+	dropColumn := flag.Bool("drop-column", false, "drop title_contents.xml_content once it is empty")
+	flag.Parse()
+
+	if err := database.Connect(); err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer database.Close()
+
+	if err := blobstore.Connect(); err != nil {
+		log.Fatal("Failed to open blob store:", err)
+	}
+
+	if !database.DB.Migrator().HasColumn(&models.TitleContent{}, "xml_content") {
+		log.Println("[MIGRATE] title_contents.xml_content does not exist, nothing to migrate")
+		return
+	}
+
+	var contentIDs []string
+	err := database.DB.Raw(`
+		SELECT id
+		FROM title_contents
+		WHERE xml_content IS NOT NULL
+		ORDER BY content_date
+	`).Scan(&contentIDs).Error
+	if err != nil {
+		log.Fatal("Failed to find title contents to migrate:", err)
+	}
+	log.Printf("[MIGRATE] Moving XML of %d title contents to the blob store", len(contentIDs))
+
+	ctx := context.Background()
+	failed := 0
+	for i, contentID := range contentIDs {
+		if err := migrateContent(ctx, contentID); err != nil {
+			log.Printf("[MIGRATE] Failed to migrate title content %s: %v", contentID, err)
+			failed++
+			continue
+		}
+		log.Printf("[MIGRATE] Migrated title content %s (%d/%d)", contentID, i+1, len(contentIDs))
+	}
+
+	if failed > 0 {
+		log.Printf("[MIGRATE] %d title contents failed to migrate; xml_content was kept", failed)
+		os.Exit(1)
+	}
+
+	if *dropColumn {
+		if err := database.DB.Exec("ALTER TABLE title_contents DROP COLUMN xml_content").Error; err != nil {
+			log.Fatal("Failed to drop xml_content:", err)
+		}
+		log.Println("[MIGRATE] Dropped title_contents.xml_content; run VACUUM FULL title_contents to return the space")
+	}
+	log.Println("[MIGRATE] Migration completed")
+}
+
+// migrateContent stores one row's XML under its checksum and clears the
+// column. Rows whose XML does not match their checksum are left alone.
+func migrateContent(ctx context.Context, contentID string) error {
+	var row struct {
+		XMLContent string
+		Checksum   *string
+	}
+	err := database.DB.Raw("SELECT xml_content, checksum FROM title_contents WHERE id = ?", contentID).
+		Scan(&row).Error
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(row.XMLContent))
+	checksum := hex.EncodeToString(hash[:])
+	if row.Checksum != nil && *row.Checksum != checksum {
+		log.Printf("[MIGRATE] Checksum of title content %s is %s but its XML hashes to %s", contentID, *row.Checksum, checksum)
+		return blobstore.ErrChecksumMismatch
+	}
+
+	if err := blobstore.Default.Put(ctx, checksum, strings.NewReader(row.XMLContent)); err != nil {
+		return err
+	}
+	return database.DB.Exec("UPDATE title_contents SET checksum = ?, xml_content = NULL WHERE id = ?", checksum, contentID).Error
+}
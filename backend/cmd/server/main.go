@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"ecfr-analyzer/internal/blobstore"
 	"ecfr-analyzer/internal/database"
 	"ecfr-analyzer/internal/handlers"
+	"ecfr-analyzer/internal/scheduler"
+	"ecfr-analyzer/internal/services"
 )
 
 func main() {
@@ -16,8 +22,18 @@ func main() {
 	}
 	defer database.Close()
 
-	// Start data loader
-	// startDataLoader()
+	// Connect to the blob store holding title XML
+	if err := blobstore.Connect(); err != nil {
+		log.Fatal("Failed to open blob store:", err)
+	}
+
+	// Runs still marked running were cut off by the previous shutdown
+	if err := services.MarkInterruptedImportRuns(); err != nil {
+		log.Printf("Failed to mark interrupted import runs: %v", err)
+	}
+
+	// Start scheduled jobs
+	startScheduler()
 
 	// Set up routes
 	mux := http.NewServeMux()
@@ -29,26 +45,46 @@ func main() {
 	mux.HandleFunc("/api/v1/import/agencies", handlers.ImportAgenciesHandler)
 	mux.HandleFunc("/api/v1/import/titles", handlers.ImportTitlesHandler)
 	mux.HandleFunc("/api/v1/import/historical-snapshots", handlers.ImportHistoricalSnapshotsHandler)
+	mux.HandleFunc("/api/v1/import/structure", handlers.ImportStructureHandler)
+	
+	// Import run history
+	mux.HandleFunc("/api/v1/imports", handlers.ImportRunsHandler)
+	mux.HandleFunc("/api/v1/imports/", handlers.ImportRunDetailHandler)
+	
+	// Quarantined content review
+	mux.HandleFunc("/api/v1/quarantine", handlers.QuarantineHandler)
+	mux.HandleFunc("/api/v1/quarantine/", handlers.QuarantineReviewHandler)
 	
 	// Status endpoint
 	mux.HandleFunc("/api/v1/status", handlers.StatusHandler)
+	mux.HandleFunc("/api/v1/content-strategies", handlers.ContentStrategiesHandler)
 	
 	// Retrieval endpoints
 	mux.HandleFunc("/api/v1/agencies", handlers.AgenciesHandler)
 	mux.HandleFunc("/api/v1/agencies/", handlers.AgencyDetailHandler)
+	mux.HandleFunc("/api/v1/agency-changes", handlers.AgencyChangesHandler)
 	mux.HandleFunc("/api/v1/titles", handlers.TitlesHandler)
+	mux.HandleFunc("/api/v1/titles/", handlers.TitleDetailHandler)
+	mux.HandleFunc("/api/v1/search", handlers.SearchHandler)
+	mux.HandleFunc("/api/v1/references/most-cited", handlers.MostCitedHandler)
+	mux.HandleFunc("/api/v1/references/agencies", handlers.AgencyDependenciesHandler)
 	
 	// Metrics endpoints
 	mux.HandleFunc("/api/v1/metrics/word-counts", handlers.WordCountMetricsHandler)
 	mux.HandleFunc("/api/v1/metrics/checksums", handlers.ChecksumsHandler)
 	mux.HandleFunc("/api/v1/metrics/agency-checksums", handlers.AgencyChecksumsHandler)
 	mux.HandleFunc("/api/v1/metrics/history", handlers.HistoryHandler)
+	mux.HandleFunc("/api/v1/metrics/readability", handlers.ReadabilityMetricsHandler)
 	
 	// Export endpoints
 	mux.HandleFunc("/api/v1/export/", handlers.ExportHandler)
 	
 	// Checksum calculation endpoint
 	mux.HandleFunc("/api/v1/calculate-checksums", handlers.CalculateChecksumsHandler)
+	
+	// Scheduled job endpoints
+	mux.HandleFunc("/api/v1/schedules", handlers.SchedulesHandler)
+	mux.HandleFunc("/api/v1/schedules/", handlers.ScheduleRunsHandler)
 
 	// Apply middleware chain: logging -> CORS
 	handler := loggingMiddleware(enableCORS(mux))
@@ -60,33 +96,75 @@ func main() {
 	}
 }
 
-func startDataLoader() {
+// startScheduler registers the background jobs. Schedules are cron
+// expressions read from SCHEDULE_* variables; "off" disables a job.
+func startScheduler() {
+	config := scheduler.ConfigFromEnv()
+	if !config.Enabled {
+		log.Println("Scheduler disabled")
+		return
+	}
+
 	importService := handlers.GetImportService()
-	
-	// Initial load on startup
-	go func() {
-		log.Println("Starting initial data load...")
-		if err := importService.LoadAllData(); err != nil {
-			log.Printf("Initial data load failed: %v", err)
-		} else {
-			log.Println("Initial data load completed successfully")
+	historicalService := handlers.GetHistoricalService()
+	checksumService := handlers.GetChecksumService()
+
+	jobs := []scheduler.Job{
+		{
+			Name:        "agency-sync",
+			Description: "Import the agency list",
+			Spec:        scheduler.SpecFromEnv("SCHEDULE_AGENCY_SYNC", "0 2 * * *"),
+			Run: func(ctx context.Context) error {
+				return skipIfImporting(importService.ImportAgencies(ctx))
+			},
+		},
+		{
+			Name:        "content-refresh",
+			Description: "Download titles amended since the last import",
+			Spec:        scheduler.SpecFromEnv("SCHEDULE_CONTENT_REFRESH", "0 */6 * * *"),
+			Run: func(ctx context.Context) error {
+				return skipIfImporting(importService.RefreshTitles(ctx))
+			},
+		},
+		{
+			Name:        "snapshot-capture",
+			Description: "Capture word count snapshots of the current content",
+			Spec:        scheduler.SpecFromEnv("SCHEDULE_SNAPSHOT", "30 3 * * *"),
+			Run: func(ctx context.Context) error {
+				return historicalService.CaptureSnapshot()
+			},
+		},
+		{
+			Name:        "checksum-recalculation",
+			Description: "Recalculate agency checksums",
+			Spec:        scheduler.SpecFromEnv("SCHEDULE_CHECKSUMS", "0 4 * * *"),
+			Run: func(ctx context.Context) error {
+				_, err := checksumService.RecalculateAll()
+				return err
+			},
+		},
+	}
+
+	s := scheduler.New(config.Jitter)
+	for _, job := range jobs {
+		if job.Spec == "" {
+			log.Printf("Scheduled job %s is off", job.Name)
+			continue
 		}
-	}()
-	
-	// Hourly refresh
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		
-		for range ticker.C {
-			log.Println("Starting scheduled data refresh...")
-			if err := importService.LoadAllData(); err != nil {
-				log.Printf("Scheduled data refresh failed: %v", err)
-			} else {
-				log.Println("Scheduled data refresh completed successfully")
-			}
+		if err := s.Add(job); err != nil {
+			log.Printf("Failed to schedule job: %v", err)
 		}
-	}()
+	}
+	s.Start()
+	handlers.SetScheduler(s)
+}
+
+// skipIfImporting turns a lost race for the import lock into a skipped run.
+func skipIfImporting(err error) error {
+	if errors.Is(err, services.ErrImportInProgress) {
+		return fmt.Errorf("%w: %v", scheduler.ErrSkipped, err)
+	}
+	return err
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
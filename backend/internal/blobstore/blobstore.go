@@ -0,0 +1,145 @@
+// Package blobstore stores large immutable content, such as full title XML,
+// outside Postgres. Blobs are content addressed: the key is the hex SHA-256 of
+// the blob, so identical content is stored once and every write is verified
+// against its key.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when no blob exists for a key.
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey is returned for keys that are not a hex SHA-256 digest.
+	ErrInvalidKey = errors.New("invalid blob key")
+	// ErrChecksumMismatch is returned by Put when the content does not hash to
+	// its key.
+	ErrChecksumMismatch = errors.New("blob content does not match its key")
+)
+
+// Store is a content-addressed blob store.
+type Store interface {
+	// Put stores the content of r under key unless a blob with that key
+	// already exists.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns a reader for the blob stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether a blob is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Create starts a blob whose key is only known once it has been written,
+	// e.g. because it is hashed while it streams in.
+	Create(ctx context.Context) (Writer, error)
+}
+
+// Writer receives the content of a new blob.
+type Writer interface {
+	io.Writer
+	// Commit stores the written content under key, which must be its hex
+	// SHA-256. If the key is already stored the written copy is discarded.
+	Commit(key string) error
+	// Abort discards the written content. It does nothing after Commit.
+	Abort() error
+}
+
+// Default is the store configured by Connect.
+var Default Store
+
+// Connect configures Default from the environment. BLOB_STORE selects the
+// backend: "fs" (the default) stores blobs under BLOB_STORE_DIR, "s3" stores
+// them in an S3-compatible bucket configured by the S3_* variables.
+func Connect() error {
+	backend := strings.ToLower(os.Getenv("BLOB_STORE"))
+	switch backend {
+	case "", "fs":
+		dir := os.Getenv("BLOB_STORE_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		store, err := NewFSStore(dir)
+		if err != nil {
+			return err
+		}
+		Default = store
+		log.Printf("[BLOBSTORE] Storing blobs in %s", dir)
+	case "s3":
+		config, err := s3ConfigFromEnv()
+		if err != nil {
+			return err
+		}
+		store, err := NewS3Store(context.Background(), config)
+		if err != nil {
+			return err
+		}
+		Default = store
+		log.Printf("[BLOBSTORE] Storing blobs in bucket %s at %s", config.Bucket, config.Endpoint)
+	default:
+		return fmt.Errorf("unknown BLOB_STORE %q, expected fs or s3", backend)
+	}
+	return nil
+}
+
+// putVerified implements Put on top of Create, checking that the content
+// hashes to its key before committing it.
+func putVerified(ctx context.Context, store Store, key string, r io.Reader) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	exists, err := store.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	w, err := store.Create(ctx)
+	if err != nil {
+		return err
+	}
+	defer w.Abort()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), contextReader{ctx: ctx, r: r}); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != key {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, key)
+	}
+	return w.Commit(key)
+}
+
+// contextReader stops a copy once its context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// validateKey rejects anything but a lowercase hex SHA-256 digest, which also
+// keeps keys safe to use in paths and URLs.
+func validateKey(key string) error {
+	if len(key) != 64 {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
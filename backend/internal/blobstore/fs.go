@@ -0,0 +1,115 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FSStore keeps blobs on the local filesystem, sharded by the first two bytes
+// of the key: <root>/ab/cd/abcd....
+type FSStore struct {
+	root string
+}
+
+func NewFSStore(root string) (*FSStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory %s: %w", root, err)
+	}
+	return &FSStore{root: root}, nil
+}
+
+func (s *FSStore) path(key string) string {
+	return filepath.Join(s.root, key[0:2], key[2:4], key)
+}
+
+func (s *FSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	return putVerified(ctx, s, key, r)
+}
+
+func (s *FSStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %w", key, err)
+	}
+	return file, nil
+}
+
+func (s *FSStore) Exists(ctx context.Context, key string) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check blob %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Create writes the blob to a temporary file in the store's root, which
+// Commit renames into place, so readers never see a partial blob.
+func (s *FSStore) Create(ctx context.Context) (Writer, error) {
+	file, err := os.CreateTemp(s.root, "incoming-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob file: %w", err)
+	}
+	return &fsWriter{store: s, file: file}, nil
+}
+
+type fsWriter struct {
+	store *FSStore
+	file  *os.File
+	done  bool
+}
+
+func (w *fsWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *fsWriter) Commit(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	defer w.Abort()
+
+	path := w.store.path(key)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	if err := os.Rename(w.file.Name(), path); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", key, err)
+	}
+	w.done = true
+	return nil
+}
+
+func (w *fsWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
@@ -57,12 +57,29 @@ func Connect() error {
 		return fmt.Errorf("failed to create uuid extension: %w", err)
 	}
 
+	// Titles imported before content_amended_on existed were refreshed
+	// against their latest_amended_on, which is seeded into it below
+	seedContentAmendedOn := DB.Migrator().HasTable(&models.Title{}) &&
+		!DB.Migrator().HasColumn(&models.Title{}, "content_amended_on")
+
 	// Auto-migrate schemas
 	err = DB.AutoMigrate(
 		&models.Agency{},
 		&models.Title{},
+		&models.TitleMetadataVersion{},
 		&models.AgencyCFRReference{},
+		&models.AgencyChange{},
 		&models.TitleContent{},
+		&models.StructureNode{},
+		&models.SectionText{},
+		&models.SectionReference{},
+		&models.StatuteCitation{},
+		&models.FederalRegisterCitation{},
+		&models.AmendmentEvent{},
+		&models.ImportRun{},
+		&models.ImportRunItem{},
+		&models.QuarantinedContent{},
+		&models.ScheduledJobRun{},
 		&models.HistoricalSnapshot{},
 		&models.AgencyChecksum{},
 	)
@@ -70,12 +87,36 @@ func Connect() error {
 		return fmt.Errorf("failed to auto-migrate: %w", err)
 	}
 
+	// Title XML now lives in the blob store
+	err = relaxLegacyXMLColumn()
+	if err != nil {
+		return fmt.Errorf("failed to migrate title_contents.xml_content: %w", err)
+	}
+
+	// Section text is searched through a generated tsvector column
+	err = createSearchVector()
+	if err != nil {
+		return fmt.Errorf("failed to create search vector: %w", err)
+	}
+
+	// Give every title a metadata history to compare later imports with
+	err = seedTitleMetadataHistory(seedContentAmendedOn)
+	if err != nil {
+		return fmt.Errorf("failed to seed title metadata history: %w", err)
+	}
+
 	// Create performance indexes
 	err = createPerformanceIndexes()
 	if err != nil {
 		return fmt.Errorf("failed to create performance indexes: %w", err)
 	}
 
+	// Create reporting views
+	err = createViews()
+	if err != nil {
+		return fmt.Errorf("failed to create views: %w", err)
+	}
+
 	log.Println("Database connected and migrated successfully")
 	return nil
 }
@@ -88,6 +129,52 @@ func Close() error {
 	return sqlDB.Close()
 }
 
+// relaxLegacyXMLColumn lets new content rows omit xml_content, which databases
+// created before the blob store still have. cmd/migrate_blobs moves the
+// remaining XML out of it and drops it.
+func relaxLegacyXMLColumn() error {
+	if !DB.Migrator().HasColumn(&models.TitleContent{}, "xml_content") {
+		return nil
+	}
+	return DB.Exec("ALTER TABLE title_contents ALTER COLUMN xml_content DROP NOT NULL").Error
+}
+
+// seedTitleMetadataHistory records the current metadata of titles that have
+// no history yet, and with seedContentAmendedOn marks the stored content of
+// existing titles as current.
+func seedTitleMetadataHistory(seedContentAmendedOn bool) error {
+	if seedContentAmendedOn {
+		err := DB.Exec(`
+			UPDATE titles SET content_amended_on = latest_amended_on
+			WHERE EXISTS (SELECT 1 FROM title_contents tc WHERE tc.title_id = titles.id)
+		`).Error
+		if err != nil {
+			return err
+		}
+	}
+	return DB.Exec(`
+		INSERT INTO title_metadata_versions
+			(id, title_id, title_number, name, reserved, latest_amended_on, latest_issue_date, up_to_date_as_of, changed_fields, recorded_at)
+		SELECT uuid_generate_v4(), t.id, t.number, t.name, COALESCE(t.reserved, false),
+			t.latest_amended_on, t.latest_issue_date, t.up_to_date_as_of, 'initial', t.updated_at
+		FROM titles t
+		WHERE NOT EXISTS (SELECT 1 FROM title_metadata_versions v WHERE v.title_id = t.id)
+	`).Error
+}
+
+// createSearchVector adds the full-text search column of section_texts, which
+// weighs headings above body text. AutoMigrate cannot declare generated
+// columns, so it is added here.
+func createSearchVector() error {
+	return DB.Exec(`
+		ALTER TABLE section_texts ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', COALESCE(heading, '')), 'A') ||
+			setweight(to_tsvector('english', COALESCE(text, '')), 'B')
+		) STORED
+	`).Error
+}
+
 func createPerformanceIndexes() error {
 	indexes := []string{
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_agency_cfr_references_agency_id ON agency_cfr_references(agency_id)",
@@ -95,6 +182,18 @@ func createPerformanceIndexes() error {
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_contents_title_id ON title_contents(title_id)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_contents_title_id_content_date ON title_contents(title_id, content_date DESC)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_contents_word_count ON title_contents(word_count) WHERE word_count IS NOT NULL",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_structure_nodes_content_type ON structure_nodes(title_content_id, node_type)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_structure_nodes_parent_id ON structure_nodes(parent_id) WHERE parent_id IS NOT NULL",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_structure_nodes_title_part ON structure_nodes(title_id, part) WHERE part IS NOT NULL",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_section_texts_search_vector ON section_texts USING GIN (search_vector)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_section_references_target ON section_references(target_type, target_title, target_identifier)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_statute_citations_statute ON statute_citations(statute_type, statute_title, statute_section)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_metadata_versions_title_recorded ON title_metadata_versions(title_id, recorded_at DESC)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_amendment_events_title_date ON amendment_events(title_id, amendment_date)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_amendment_events_amendment_date ON amendment_events(amendment_date)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_import_runs_started_at ON import_runs(started_at DESC)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_import_run_items_run_id ON import_run_items(run_id)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_scheduled_job_runs_job_started ON scheduled_job_runs(job_name, started_at DESC)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_agencies_parent_id ON agencies(parent_id) WHERE parent_id IS NOT NULL",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_historical_snapshots_agency_title ON historical_snapshots(agency_id, title_id, snapshot_date)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_historical_snapshots_snapshot_date ON historical_snapshots(snapshot_date)",
@@ -108,5 +207,268 @@ func createPerformanceIndexes() error {
 		}
 	}
 
+	return nil
+}
+
+// createViews drops and recreates the views that attribute title content to
+// agencies, and the functions that pick one content version per title.
+//
+// agency_matched_nodes resolves every agency CFR reference to the structure
+// node it covers in each content version: the part, subchapter or chapter
+// named by the reference, or the whole title when no chapter is given. A
+// whole title is attributed the checksum of its content version, as it is
+// before the version is parsed, so parsing does not change agency checksums.
+//
+// agency_content_attribution drops nodes already covered by a broader
+// reference of the same agency so nothing is counted twice. Content versions
+// that have not been parsed yet have no nodes to scope a reference to, so only
+// references to the whole title are attributed their whole content; scoped
+// references stay unattributed until the version is parsed.
+//
+// Both cover every stored content version. Metrics must only count the
+// version of each title in effect on one date, which title_contents_as_of
+// returns: the newest version dated on or before as_of, or the newest version
+// when as_of is NULL. agency_content_attribution_as_of restricts the
+// attribution to those versions.
+//
+// title_metrics_as_of and agency_word_counts_as_of answer point-in-time
+// queries for dates before the first stored content version. Both choose the
+// source per title: a title without content in effect on as_of falls back to
+// its newest historical snapshot dated on or before it, and an agency sums its
+// attributed content in the titles that have some and its share snapshots of
+// the others. Agencies are only counted from their total snapshots where no
+// share snapshots were stored.
+//
+// agency_sections lists the sections and appendices each agency's CFR
+// references cover, for filtering and faceting search results, and
+// agency_parts the parts, for the statutes cited in their authority notes.
+func createViews() error {
+	views := []string{
+		"DROP VIEW IF EXISTS agency_parts",
+		"DROP VIEW IF EXISTS agency_sections",
+		"DROP FUNCTION IF EXISTS agency_word_counts_as_of(date)",
+		"DROP FUNCTION IF EXISTS title_metrics_as_of(date)",
+		"DROP FUNCTION IF EXISTS agency_content_attribution_as_of(date)",
+		"DROP FUNCTION IF EXISTS title_contents_as_of(date)",
+		"DROP VIEW IF EXISTS agency_content_attribution",
+		"DROP VIEW IF EXISTS agency_matched_nodes",
+		`CREATE VIEW agency_matched_nodes AS
+		SELECT DISTINCT
+			acr.agency_id,
+			sn.title_id,
+			sn.title_content_id,
+			tc.content_date,
+			sn.id AS node_id,
+			sn.node_type,
+			sn.identifier,
+			sn.chapter,
+			sn.subchapter,
+			sn.word_count,
+			sn.restriction_shall + sn.restriction_must + sn.restriction_may_not +
+				sn.restriction_required + sn.restriction_prohibited AS restriction_count,
+			CASE WHEN sn.node_type = 'title' THEN tc.checksum ELSE sn.checksum END AS checksum
+		FROM agency_cfr_references acr
+		JOIN title_contents tc ON tc.title_id = acr.title_id
+		JOIN structure_nodes sn ON sn.title_content_id = tc.id
+		WHERE acr.deleted_at IS NULL AND CASE
+			WHEN COALESCE(acr.part, '') <> '' THEN
+				sn.node_type = 'part' AND sn.identifier = acr.part
+			WHEN COALESCE(acr.subchapter, '') <> '' THEN
+				sn.node_type = 'subchapter' AND sn.identifier = acr.subchapter
+				AND (COALESCE(acr.chapter, '') = '' OR sn.chapter = acr.chapter)
+			WHEN COALESCE(acr.chapter, '') <> '' THEN
+				sn.node_type = 'chapter' AND sn.identifier = acr.chapter
+			ELSE
+				sn.node_type = 'title'
+		END`,
+		`CREATE VIEW agency_content_attribution AS
+		SELECT
+			m.agency_id,
+			m.title_id,
+			m.title_content_id,
+			m.content_date,
+			m.node_id,
+			m.node_type AS scope_type,
+			m.identifier AS scope_identifier,
+			m.word_count,
+			m.restriction_count,
+			m.checksum
+		FROM agency_matched_nodes m
+		WHERE NOT EXISTS (
+			SELECT 1 FROM agency_matched_nodes broader
+			WHERE broader.agency_id = m.agency_id
+				AND broader.title_content_id = m.title_content_id
+				AND broader.node_id <> m.node_id
+				AND (
+					broader.node_type = 'title'
+					OR (broader.node_type = 'chapter' AND m.chapter = broader.identifier)
+					OR (broader.node_type = 'subchapter' AND m.node_type = 'part'
+						AND m.subchapter = broader.identifier
+						AND m.chapter IS NOT DISTINCT FROM broader.chapter)
+				)
+		)
+		UNION ALL
+		SELECT DISTINCT
+			acr.agency_id,
+			tc.title_id,
+			tc.id AS title_content_id,
+			tc.content_date,
+			NULL::uuid AS node_id,
+			'title' AS scope_type,
+			NULL AS scope_identifier,
+			COALESCE(tc.word_count, 0) AS word_count,
+			tc.restriction_count,
+			tc.checksum
+		FROM agency_cfr_references acr
+		JOIN title_contents tc ON tc.title_id = acr.title_id
+		WHERE acr.deleted_at IS NULL
+			AND COALESCE(acr.chapter, '') = ''
+			AND COALESCE(acr.subchapter, '') = ''
+			AND COALESCE(acr.part, '') = ''
+			AND NOT EXISTS (SELECT 1 FROM structure_nodes sn WHERE sn.title_content_id = tc.id)`,
+		`CREATE VIEW agency_sections AS
+		SELECT DISTINCT acr.agency_id, sn.id AS node_id, sn.title_content_id
+		FROM agency_cfr_references acr
+		JOIN structure_nodes sn ON sn.title_id = acr.title_id
+		WHERE acr.deleted_at IS NULL
+			AND sn.node_type IN ('section', 'appendix')
+			AND CASE
+				WHEN COALESCE(acr.part, '') <> '' THEN
+					sn.part = acr.part
+				WHEN COALESCE(acr.subchapter, '') <> '' THEN
+					sn.subchapter = acr.subchapter
+					AND (COALESCE(acr.chapter, '') = '' OR sn.chapter = acr.chapter)
+				WHEN COALESCE(acr.chapter, '') <> '' THEN
+					sn.chapter = acr.chapter
+				ELSE
+					true
+			END`,
+		`CREATE VIEW agency_parts AS
+		SELECT DISTINCT acr.agency_id, sn.id AS node_id, sn.title_content_id, sn.identifier AS part
+		FROM agency_cfr_references acr
+		JOIN structure_nodes sn ON sn.title_id = acr.title_id
+		WHERE acr.deleted_at IS NULL
+			AND sn.node_type = 'part'
+			AND CASE
+				WHEN COALESCE(acr.part, '') <> '' THEN
+					sn.identifier = acr.part
+				WHEN COALESCE(acr.subchapter, '') <> '' THEN
+					sn.subchapter = acr.subchapter
+					AND (COALESCE(acr.chapter, '') = '' OR sn.chapter = acr.chapter)
+				WHEN COALESCE(acr.chapter, '') <> '' THEN
+					sn.chapter = acr.chapter
+				ELSE
+					true
+			END`,
+		`CREATE FUNCTION title_contents_as_of(as_of date)
+		RETURNS SETOF title_contents
+		LANGUAGE sql STABLE AS $$
+			SELECT DISTINCT ON (tc.title_id) tc.*
+			FROM title_contents tc
+			WHERE as_of IS NULL OR tc.content_date::date <= as_of
+			ORDER BY tc.title_id, tc.content_date DESC
+		$$`,
+		`CREATE FUNCTION agency_content_attribution_as_of(as_of date)
+		RETURNS SETOF agency_content_attribution
+		LANGUAGE sql STABLE AS $$
+			SELECT aca.*
+			FROM agency_content_attribution aca
+			WHERE aca.title_content_id IN (SELECT id FROM title_contents_as_of(as_of))
+		$$`,
+		`CREATE FUNCTION title_metrics_as_of(as_of date)
+		RETURNS TABLE (
+			title_id uuid,
+			title_content_id uuid,
+			effective_date timestamptz,
+			word_count bigint,
+			restriction_count bigint,
+			checksum text,
+			recorded_at timestamptz,
+			source text
+		)
+		LANGUAGE sql STABLE AS $$
+			SELECT tc.title_id, tc.id, tc.content_date::timestamptz, tc.word_count::bigint,
+				tc.restriction_count::bigint, tc.checksum::text, tc.created_at::timestamptz, 'content'
+			FROM title_contents_as_of(as_of) tc
+			UNION ALL
+			SELECT * FROM (
+				SELECT DISTINCT ON (hs.title_id)
+					hs.title_id, NULL::uuid, hs.snapshot_date::timestamptz, hs.word_count::bigint,
+					hs.restriction_count::bigint, hs.checksum::text, hs.created_at::timestamptz, 'snapshot'
+				FROM historical_snapshots hs
+				WHERE as_of IS NOT NULL
+					AND hs.title_id IS NOT NULL AND hs.agency_id IS NULL
+					AND hs.snapshot_date::date <= as_of
+					AND NOT EXISTS (
+						SELECT 1 FROM title_contents tc
+						WHERE tc.title_id = hs.title_id AND tc.content_date::date <= as_of
+					)
+				ORDER BY hs.title_id, hs.snapshot_date DESC
+			) snapshots
+		$$`,
+		`CREATE FUNCTION agency_word_counts_as_of(as_of date)
+		RETURNS TABLE (agency_id uuid, word_count bigint, restriction_count bigint, source text)
+		LANGUAGE sql STABLE AS $$
+			WITH title_counts AS (
+				SELECT aca.agency_id, SUM(aca.word_count)::bigint AS word_count,
+					SUM(aca.restriction_count)::bigint AS restriction_count, 'content' AS source
+				FROM agency_content_attribution_as_of(as_of) aca
+				GROUP BY aca.agency_id, aca.title_id
+				UNION ALL
+				SELECT * FROM (
+					SELECT DISTINCT ON (hs.agency_id, hs.title_id)
+						hs.agency_id, hs.word_count::bigint, hs.restriction_count::bigint, 'snapshot'
+					FROM historical_snapshots hs
+					WHERE as_of IS NOT NULL
+						AND hs.agency_id IS NOT NULL AND hs.title_id IS NOT NULL
+						AND hs.snapshot_date::date <= as_of
+						AND NOT EXISTS (
+							SELECT 1 FROM title_contents tc
+							WHERE tc.title_id = hs.title_id AND tc.content_date::date <= as_of
+						)
+					ORDER BY hs.agency_id, hs.title_id, hs.snapshot_date DESC
+				) snapshots
+			)
+			SELECT
+				a.id,
+				COALESCE(titles.word_count, total.word_count, 0)::bigint,
+				COALESCE(titles.restriction_count, total.restriction_count)::bigint,
+				CASE
+					WHEN titles.agency_id IS NOT NULL THEN titles.source
+					WHEN total.word_count IS NOT NULL THEN 'snapshot'
+				END
+			FROM agencies a
+			LEFT JOIN (
+				SELECT
+					tcs.agency_id,
+					SUM(tcs.word_count) AS word_count,
+					CASE WHEN COUNT(tcs.restriction_count) = COUNT(*) THEN SUM(tcs.restriction_count) END AS restriction_count,
+					CASE WHEN bool_and(tcs.source = 'content') THEN 'content' ELSE 'snapshot' END AS source
+				FROM title_counts tcs
+				GROUP BY tcs.agency_id
+			) titles ON titles.agency_id = a.id
+			LEFT JOIN LATERAL (
+				SELECT hs.word_count, hs.restriction_count
+				FROM historical_snapshots hs
+				WHERE as_of IS NOT NULL
+					AND hs.agency_id = a.id AND hs.title_id IS NULL
+					AND hs.snapshot_date::date <= as_of
+					AND NOT EXISTS (
+						SELECT 1 FROM historical_snapshots ths
+						WHERE ths.agency_id = a.id AND ths.title_id IS NOT NULL
+					)
+				ORDER BY hs.snapshot_date DESC
+				LIMIT 1
+			) total ON titles.agency_id IS NULL
+			WHERE a.deleted_at IS NULL
+		$$`,
+	}
+
+	for _, viewSQL := range views {
+		if err := DB.Exec(viewSQL).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
\ No newline at end of file
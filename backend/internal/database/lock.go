@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// Advisory lock keys. Keep them unique across the application.
+const (
+	ImportLockKey int64 = 7301
+	// Scheduled jobs lock SchedulerLockBase<<32 plus a hash of their name
+	SchedulerLockBase int64 = 7400
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock. It is held on a
+// dedicated connection, so it is released automatically if the process dies
+// and is visible to every process sharing the database.
+type AdvisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// TryAdvisoryLock takes the lock for key without waiting. It returns nil and
+// no error when another session already holds it.
+func TryAdvisoryLock(ctx context.Context, key int64) (*AdvisoryLock, error) {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database handle: %w", err)
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to take advisory lock %d: %w", key, err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &AdvisoryLock{conn: conn, key: key}, nil
+}
+
+// Release unlocks the lock and returns its connection to the pool. If the
+// unlock fails the connection is discarded instead, which ends the session
+// and with it the lock.
+func (l *AdvisoryLock) Release() error {
+	defer l.conn.Close()
+
+	var released bool
+	if err := l.conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key).Scan(&released); err != nil {
+		l.conn.Raw(func(any) error { return driver.ErrBadConn })
+		return fmt.Errorf("failed to release advisory lock %d: %w", l.key, err)
+	}
+	if !released {
+		return fmt.Errorf("advisory lock %d was not held", l.key)
+	}
+	return nil
+}
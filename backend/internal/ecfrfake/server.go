@@ -0,0 +1,347 @@
+// Package ecfrfake is a local stand-in for the eCFR API and the govinfo bulk
+// data repository. It serves agencies, titles, full title XML and title
+// structure from a fixture directory so imports can run offline.
+//
+// Fixture layout:
+//
+//	agencies.json                      /api/admin/v1/agencies.json
+//	titles.json                        /api/versioner/v1/titles.json
+//	titles/title-{n}/{YYYY-MM-DD}.xml  full XML of title n as of that date
+//
+// Full XML and structure requests for a date are answered with the newest
+// fixture dated on or before it. Structure JSON is derived from that XML, the
+// versions list from the sections that differ between consecutive fixtures, and
+// the bulk repository always serves the newest fixture with an ETag and a
+// Last-Modified date taken from its file name.
+package ecfrfake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecfr-analyzer/internal/services"
+)
+
+//go:embed fixtures
+var embeddedFixtures embed.FS
+
+// Fixtures returns the fixture set bundled with the package.
+func Fixtures() fs.FS {
+	fixtures, err := fs.Sub(embeddedFixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return fixtures
+}
+
+// Handler serves the fake eCFR and govinfo endpoints.
+type Handler struct {
+	fixtures fs.FS
+	mux      *http.ServeMux
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+func NewHandler(fixtures fs.FS) *Handler {
+	h := &Handler{
+		fixtures: fixtures,
+		mux:      http.NewServeMux(),
+		requests: make(map[string]int),
+	}
+
+	h.mux.HandleFunc("GET /api/admin/v1/agencies.json", h.serveFixture("agencies.json"))
+	h.mux.HandleFunc("GET /api/versioner/v1/titles.json", h.serveFixture("titles.json"))
+	h.mux.HandleFunc("GET /api/versioner/v1/full/{date}/{file}", h.serveFullXML)
+	h.mux.HandleFunc("GET /api/versioner/v1/structure/{date}/{file}", h.serveStructure)
+	h.mux.HandleFunc("GET /api/versioner/v1/versions/{file}", h.serveVersions)
+	h.mux.HandleFunc("GET /bulkdata/ECFR/{dir}/{file}", h.serveBulkXML)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	h.requests[r.URL.Path]++
+	h.mutex.Unlock()
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// RequestCount returns how many requests were made for paths starting with
+// prefix.
+func (h *Handler) RequestCount(prefix string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	count := 0
+	for requestPath, n := range h.requests {
+		if strings.HasPrefix(requestPath, prefix) {
+			count += n
+		}
+	}
+	return count
+}
+
+func (h *Handler) serveFixture(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := fs.ReadFile(h.fixtures, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+func (h *Handler) serveFullXML(w http.ResponseWriter, r *http.Request) {
+	titleNumber, ok := parseTitleFile(r.PathValue("file"), "title-", ".xml")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := h.titleXML(titleNumber, r.PathValue("date"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+func (h *Handler) serveBulkXML(w http.ResponseWriter, r *http.Request) {
+	titleNumber, ok := parseTitleFile(r.PathValue("file"), "ECFR-title", ".xml")
+	if !ok || r.PathValue("dir") != fmt.Sprintf("title-%d", titleNumber) {
+		http.NotFound(w, r)
+		return
+	}
+
+	dates, err := h.titleVersionDates(titleNumber)
+	if err != nil || len(dates) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	newest := dates[len(dates)-1]
+	data, err := h.titleXML(titleNumber, newest)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Like the real repository, answer conditional requests with 304
+	modified, _ := time.Parse("2006-01-02", newest)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+	http.ServeContent(w, r, "", modified, bytes.NewReader(data))
+}
+
+func (h *Handler) serveStructure(w http.ResponseWriter, r *http.Request) {
+	titleNumber, ok := parseTitleFile(r.PathValue("file"), "title-", ".json")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := h.titleXML(titleNumber, r.PathValue("date"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	structure, err := buildStructure(data)
+	if err != nil {
+		log.Printf("[ECFR_FAKE] Failed to build structure for title %d: %v", titleNumber, err)
+		http.Error(w, "invalid fixture", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(structure)
+}
+
+func (h *Handler) serveVersions(w http.ResponseWriter, r *http.Request) {
+	titleNumber, ok := parseTitleFile(r.PathValue("file"), "title-", ".json")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	versions, err := h.titleVersions(titleNumber)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("[ECFR_FAKE] Failed to build versions for title %d: %v", titleNumber, err)
+		http.Error(w, "invalid fixture", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.TitleVersionsResponse{ContentVersions: versions})
+}
+
+// titleVersions lists the sections and appendices of the first fixture of a
+// title, then those added, changed or removed by each later fixture.
+func (h *Handler) titleVersions(titleNumber int) ([]services.TitleVersion, error) {
+	dates, err := h.titleVersionDates(titleNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []services.TitleVersion{}
+	previous := map[string]services.ParsedNode{}
+	for _, date := range dates {
+		data, err := fs.ReadFile(h.fixtures, path.Join("titles", fmt.Sprintf("title-%d", titleNumber), date+".xml"))
+		if err != nil {
+			return nil, err
+		}
+		current, err := fixtureSections(data)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, section := range current {
+			if old, exists := previous[key]; exists && old.Checksum == section.Checksum {
+				continue
+			}
+			versions = append(versions, sectionVersion(section, date, false))
+		}
+		for key, section := range previous {
+			if _, exists := current[key]; !exists {
+				versions = append(versions, sectionVersion(section, date, true))
+			}
+		}
+		previous = current
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Date != versions[j].Date {
+			return versions[i].Date < versions[j].Date
+		}
+		return versions[i].Identifier < versions[j].Identifier
+	})
+	return versions, nil
+}
+
+func sectionVersion(node services.ParsedNode, date string, removed bool) services.TitleVersion {
+	return services.TitleVersion{
+		Date:          date,
+		AmendmentDate: date,
+		IssueDate:     date,
+		Identifier:    node.Identifier,
+		Name:          node.Heading,
+		Part:          node.Part,
+		Substantive:   true,
+		Removed:       removed,
+		Type:          node.Type,
+	}
+}
+
+// fixtureSections collects the sections and appendices of a fixture keyed by
+// type, part and identifier.
+func fixtureSections(data []byte) (map[string]services.ParsedNode, error) {
+	sections := make(map[string]services.ParsedNode)
+	err := services.ParseTitleStructure(bytes.NewReader(data), func(node *services.ParsedNode) error {
+		if node.Type == services.NodeTypeSection || node.Type == services.NodeTypeAppendix {
+			sections[node.Type+":"+node.Part+":"+node.Identifier] = *node
+		}
+		return nil
+	})
+	return sections, err
+}
+
+// titleXML returns the newest fixture for a title dated on or before date, or
+// the newest fixture overall when date is empty.
+func (h *Handler) titleXML(titleNumber int, date string) ([]byte, error) {
+	dates, err := h.titleVersionDates(titleNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := ""
+	for _, versionDate := range dates {
+		if date == "" || versionDate <= date {
+			selected = versionDate
+		}
+	}
+	if selected == "" {
+		return nil, fs.ErrNotExist
+	}
+
+	return fs.ReadFile(h.fixtures, path.Join("titles", fmt.Sprintf("title-%d", titleNumber), selected+".xml"))
+}
+
+// titleVersionDates lists the fixture dates of a title in ascending order.
+func (h *Handler) titleVersionDates(titleNumber int) ([]string, error) {
+	entries, err := fs.ReadDir(h.fixtures, path.Join("titles", fmt.Sprintf("title-%d", titleNumber)))
+	if err != nil {
+		return nil, err
+	}
+
+	var dates []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasSuffix(name, ".xml") {
+			dates = append(dates, strings.TrimSuffix(name, ".xml"))
+		}
+	}
+	sort.Strings(dates)
+	return dates, nil
+}
+
+// buildStructure derives versioner structure JSON from title XML. Sizes are
+// the character counts of the text under each node.
+func buildStructure(data []byte) (*services.TitleStructure, error) {
+	return services.BuildTitleStructure(bytes.NewReader(data), func(node *services.ParsedNode) int {
+		return len(node.Text)
+	})
+}
+
+func parseTitleFile(file, prefix, suffix string) (int, bool) {
+	if !strings.HasPrefix(file, prefix) || !strings.HasSuffix(file, suffix) {
+		return 0, false
+	}
+	number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file, prefix), suffix))
+	return number, err == nil
+}
+
+// Server runs a Handler on a local httptest listener.
+type Server struct {
+	*httptest.Server
+	Handler *Handler
+}
+
+// NewServer starts a fake eCFR server backed by the bundled fixtures.
+func NewServer() *Server {
+	return NewServerWithFixtures(Fixtures())
+}
+
+func NewServerWithFixtures(fixtures fs.FS) *Server {
+	handler := NewHandler(fixtures)
+	return &Server{
+		Server:  httptest.NewServer(handler),
+		Handler: handler,
+	}
+}
+
+// UpstreamConfig points the import services at this server.
+func (s *Server) UpstreamConfig() services.UpstreamConfig {
+	return services.UpstreamConfig{
+		ECFRBaseURL: s.URL,
+		BulkBaseURL: s.URL + "/bulkdata/ECFR",
+		Transport:   s.Client().Transport,
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// AgencyChangesHandler returns the agency sync audit log, newest first.
+// slug=..., action=created|updated|moved|deleted|restored|reference_added|
+// reference_removed and run=<import run id> filter it; limit=N (default 100)
+// caps the result.
+func AgencyChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			http.Error(w, "Invalid limit, expected 1-1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	query := database.DB.Model(&models.AgencyChange{})
+	if slug := r.URL.Query().Get("slug"); slug != "" {
+		query = query.Where("slug = ?", slug)
+	}
+	if action := r.URL.Query().Get("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if runStr := r.URL.Query().Get("run"); runStr != "" {
+		runID, err := uuid.Parse(runStr)
+		if err != nil {
+			http.Error(w, "Invalid import run id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("run_id = ?", runID)
+	}
+
+	var changes []models.AgencyChange
+	if err := query.Order("created_at DESC").Limit(limit).Find(&changes).Error; err != nil {
+		log.Printf("[HANDLER] AgencyChangesHandler: Failed to fetch agency changes: %v", err)
+		http.Error(w, "Failed to fetch agency changes", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: changes,
+		Meta: Meta{
+			Total:       len(changes),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 
 	"ecfr-analyzer/internal/database"
 	"ecfr-analyzer/internal/models"
+	"ecfr-analyzer/internal/services"
 
 	"github.com/google/uuid"
 )
@@ -24,6 +26,30 @@ type APIResponse struct {
 type Meta struct {
 	Total       int       `json:"total"`
 	LastUpdated time.Time `json:"lastUpdated"`
+	// AsOf echoes the asOf parameter of point-in-time queries
+	AsOf *string `json:"asOf,omitempty"`
+}
+
+// parseAsOf reads the optional asOf=YYYY-MM-DD parameter. Read endpoints
+// resolve their data as it was on that date instead of the latest.
+func parseAsOf(r *http.Request) (*time.Time, error) {
+	value := r.URL.Query().Get("asOf")
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, errors.New("Invalid asOf, expected YYYY-MM-DD")
+	}
+	return &date, nil
+}
+
+func formatAsOf(asOf *time.Time) *string {
+	if asOf == nil {
+		return nil
+	}
+	value := asOf.Format("2006-01-02")
+	return &value
 }
 
 type AgencyWithMetrics struct {
@@ -35,18 +61,30 @@ type AgencyWithMetrics struct {
 	TitleCount     int       `json:"titleCount"`
 	Checksum       *string   `json:"checksum,omitempty"`
 	ParentID       *uuid.UUID `json:"parentId,omitempty"`
+	// Source is "content" or, for asOf dates without stored content,
+	// "snapshot"
+	Source *string `json:"source,omitempty"`
+	// Restrictiveness: obligation and prohibition terms ("shall", "must",
+	// "may not", "required", "prohibited"), omitted where not counted
+	RestrictionCount         *int     `json:"restrictionCount,omitempty"`
+	RestrictionsPer1000Words *float64 `json:"restrictionsPer1000Words,omitempty"`
+	// Readability of the chapters, subchapters and parts the agency owns
+	Readability *services.ReadabilityScores `json:"readability,omitempty"`
 }
 
 type AgencyDetail struct {
 	AgencyWithMetrics
 	SubAgencies []AgencyWithMetrics `json:"subAgencies"`
 	TitleBreakdown []TitleBreakdown `json:"titleBreakdown"`
+	// Statutes named in the authority notes of the agency's parts
+	Statutes []services.AgencyStatute `json:"statutes"`
 }
 
 type TitleBreakdown struct {
-	TitleNumber int    `json:"titleNumber"`
-	TitleName   string `json:"titleName"`
-	WordCount   int    `json:"wordCount"`
+	TitleNumber      int    `json:"titleNumber"`
+	TitleName        string `json:"titleName"`
+	WordCount        int    `json:"wordCount"`
+	RestrictionCount *int   `json:"restrictionCount,omitempty"`
 }
 
 type TitleWithMetrics struct {
@@ -56,7 +94,20 @@ type TitleWithMetrics struct {
 	WordCount        int        `json:"wordCount"`
 	Checksum         *string    `json:"checksum,omitempty"`
 	LatestAmendedOn  *time.Time `json:"latestAmendedOn,omitempty"`
+	LatestIssueDate  *time.Time `json:"latestIssueDate,omitempty"`
 	UpToDateAsOf     *time.Time `json:"upToDateAsOf,omitempty"`
+	// ContentDate is the effective date of the content version counted, and
+	// Source is "content" or, for asOf dates without stored content, "snapshot"
+	ContentDate *time.Time `json:"contentDate,omitempty"`
+	Source      *string    `json:"source,omitempty"`
+	// Restrictiveness and readability, as for agencies
+	RestrictionCount         *int                        `json:"restrictionCount,omitempty"`
+	RestrictionsPer1000Words *float64                    `json:"restrictionsPer1000Words,omitempty"`
+	Readability              *services.ReadabilityScores `json:"readability,omitempty"`
+	// Amendment frequency, from the latest amendment dates seen by imports
+	AmendmentCount               int      `json:"amendmentCount"`
+	AmendmentsLastYear           int      `json:"amendmentsLastYear"`
+	AverageDaysBetweenAmendments *float64 `json:"averageDaysBetweenAmendments,omitempty"`
 }
 
 type WordCountMetrics struct {
@@ -72,9 +123,20 @@ type ChecksumInfo struct {
 }
 
 type HistoricalPoint struct {
-	Date          string  `json:"date"`
-	WordCount     int     `json:"wordCount"`
-	ChangePercent float64 `json:"changePercent"`
+	Date              string  `json:"date"`
+	WordCount         int     `json:"wordCount"`
+	ChangePercent     float64 `json:"changePercent"`
+	MeasurementMethod *string `json:"measurementMethod,omitempty"`
+	RestrictionCount  *int    `json:"restrictionCount,omitempty"`
+}
+
+// agencyChecksumsAsOf returns the cached checksums of the latest content, or
+// calculates them from the content in effect on asOf.
+func agencyChecksumsAsOf(asOf *time.Time, agencyIDs []uuid.UUID) map[uuid.UUID]string {
+	if asOf == nil {
+		return getCachedAgencyChecksums(agencyIDs)
+	}
+	return calculateBatchAgencyChecksumsOptimized(asOf, agencyIDs)
 }
 
 // getCachedAgencyChecksums retrieves checksums from cache, with fallback to real-time calculation
@@ -88,7 +150,7 @@ func getCachedAgencyChecksums(agencyIDs []uuid.UUID) map[uuid.UUID]string {
 	err := database.DB.Where("agency_id IN ?", agencyIDs).Find(&cachedChecksums).Error
 	if err != nil {
 		log.Printf("Warning: Failed to fetch cached checksums: %v", err)
-		return calculateBatchAgencyChecksumsLegacy(agencyIDs)
+		return calculateBatchAgencyChecksumsOptimized(nil, agencyIDs)
 	}
 	
 	// Map cached results
@@ -110,7 +172,7 @@ func getCachedAgencyChecksums(agencyIDs []uuid.UUID) map[uuid.UUID]string {
 	
 	if len(missingIDs) > 0 {
 		log.Printf("Warning: %d agency checksums not found in cache, calculating real-time", len(missingIDs))
-		missingChecksums := calculateBatchAgencyChecksumsOptimized(missingIDs)
+		missingChecksums := calculateBatchAgencyChecksumsOptimized(nil, missingIDs)
 		for agencyID, checksum := range missingChecksums {
 			result[agencyID] = checksum
 		}
@@ -120,33 +182,19 @@ func getCachedAgencyChecksums(agencyIDs []uuid.UUID) map[uuid.UUID]string {
 }
 
 // calculateBatchAgencyChecksumsOptimized uses title checksums instead of full XML content
-func calculateBatchAgencyChecksumsOptimized(agencyIDs []uuid.UUID) map[uuid.UUID]string {
+func calculateBatchAgencyChecksumsOptimized(asOf *time.Time, agencyIDs []uuid.UUID) map[uuid.UUID]string {
 	if len(agencyIDs) == 0 {
 		return make(map[uuid.UUID]string)
 	}
 	
-	type AgencyTitleChecksum struct {
-		AgencyID    uuid.UUID `gorm:"column:agency_id"`
-		TitleNumber int       `gorm:"column:title_number"`
-		Checksum    string    `gorm:"column:checksum"`
-	}
-	
-	var agencyTitleChecksums []AgencyTitleChecksum
-	err := database.DB.Table("title_contents tc").
-		Select("acr.agency_id, t.number as title_number, tc.checksum").
-		Joins("JOIN titles t ON tc.title_id = t.id").
-		Joins("JOIN agency_cfr_references acr ON t.id = acr.title_id").
-		Where("acr.agency_id IN ? AND tc.checksum IS NOT NULL AND tc.checksum != ''", agencyIDs).
-		Order("acr.agency_id ASC, t.number ASC"). // Deterministic order
-		Scan(&agencyTitleChecksums).Error
-	
+	agencyTitleChecksums, err := services.AttributedChecksumsAsOf(asOf, agencyIDs)
 	if err != nil {
 		log.Printf("Error fetching title checksums: %v", err)
 		return make(map[uuid.UUID]string)
 	}
 	
 	// Group checksums by agency
-	agencyChecksumsMap := make(map[uuid.UUID][]AgencyTitleChecksum)
+	agencyChecksumsMap := make(map[uuid.UUID][]services.AttributedChecksum)
 	for _, content := range agencyTitleChecksums {
 		agencyChecksumsMap[content.AgencyID] = append(agencyChecksumsMap[content.AgencyID], content)
 	}
@@ -160,7 +208,7 @@ func calculateBatchAgencyChecksumsOptimized(agencyIDs []uuid.UUID) map[uuid.UUID
 		// Combine title checksums in deterministic order (much faster than XML content)
 		var combinedChecksums strings.Builder
 		for _, tc := range titleChecksums {
-			combinedChecksums.WriteString(fmt.Sprintf("TITLE_%d:%s\n", tc.TitleNumber, tc.Checksum))
+			combinedChecksums.WriteString(services.AttributedChecksumLine(tc.TitleNumber, tc.ScopeType, tc.ScopeIdentifier, tc.Checksum))
 		}
 		
 		// Calculate SHA-256 checksum
@@ -171,168 +219,112 @@ func calculateBatchAgencyChecksumsOptimized(agencyIDs []uuid.UUID) map[uuid.UUID
 	return checksums
 }
 
-// calculateBatchAgencyChecksumsLegacy - fallback method using full XML content (kept for compatibility)
-func calculateBatchAgencyChecksumsLegacy(agencyIDs []uuid.UUID) map[uuid.UUID]string {
-	if len(agencyIDs) == 0 {
-		return make(map[uuid.UUID]string)
-	}
-	
-	// Limit to prevent memory issues
-	if len(agencyIDs) > 10 {
-		log.Printf("Warning: Legacy checksum calculation limited to first 10 agencies to prevent memory issues")
-		agencyIDs = agencyIDs[:10]
-	}
-	
-	type AgencyTitleContent struct {
-		AgencyID    uuid.UUID `gorm:"column:agency_id"`
-		TitleNumber int       `gorm:"column:title_number"`
-		Content     string    `gorm:"column:xml_content"`
-	}
-	
-	var agencyTitleContents []AgencyTitleContent
-	err := database.DB.Table("title_contents tc").
-		Select("acr.agency_id, t.number as title_number, tc.xml_content").
-		Joins("JOIN titles t ON tc.title_id = t.id").
-		Joins("JOIN agency_cfr_references acr ON t.id = acr.title_id").
-		Where("acr.agency_id IN ? AND tc.xml_content IS NOT NULL AND tc.xml_content != ''", agencyIDs).
-		Order("acr.agency_id ASC, t.number ASC"). // Deterministic order
-		Scan(&agencyTitleContents).Error
-	
-	if err != nil {
-		return make(map[uuid.UUID]string)
-	}
-	
-	// Group content by agency and calculate checksums
-	agencyContentMap := make(map[uuid.UUID][]AgencyTitleContent)
-	for _, content := range agencyTitleContents {
-		agencyContentMap[content.AgencyID] = append(agencyContentMap[content.AgencyID], content)
-	}
-	
-	checksums := make(map[uuid.UUID]string)
-	for agencyID, contents := range agencyContentMap {
-		if len(contents) == 0 {
-			continue
-		}
-		
-		// Concatenate all content in deterministic order
-		var combinedContent strings.Builder
-		for _, tc := range contents {
-			combinedContent.WriteString(fmt.Sprintf("TITLE_%d:", tc.TitleNumber))
-			combinedContent.WriteString(tc.Content)
-			combinedContent.WriteString("\n")
-		}
-		
-		// Calculate SHA-256 checksum
-		hash := sha256.Sum256([]byte(combinedContent.String()))
-		checksums[agencyID] = fmt.Sprintf("%x", hash)
-	}
-	
-	return checksums
-}
-
 // calculateAgencyChecksum calculates checksum for a single agency (fallback for individual calls)
-func calculateAgencyChecksum(agencyID uuid.UUID) string {
-	checksums := getCachedAgencyChecksums([]uuid.UUID{agencyID})
+func calculateAgencyChecksum(asOf *time.Time, agencyID uuid.UUID) string {
+	checksums := agencyChecksumsAsOf(asOf, []uuid.UUID{agencyID})
 	if checksum, exists := checksums[agencyID]; exists {
 		return checksum
 	}
 	return ""
 }
 
-func AgenciesHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[HANDLER] AgenciesHandler called")
-	if r.Method != http.MethodGet {
-		log.Printf("[HANDLER] AgenciesHandler: Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// restrictionMetrics converts a restriction count to the API fields: the count
+// and its density per 1,000 words.
+func restrictionMetrics(restrictionCount *int64, wordCount int64) (*int, *float64) {
+	if restrictionCount == nil {
+		return nil, nil
 	}
-
-	// Calculate total words for percentage calculation
-	var totalWords int64
-	database.DB.Table("title_contents").
-		Select("COALESCE(SUM(word_count), 0)").
-		Where("word_count IS NOT NULL").
-		Scan(&totalWords)
-
-	// Get all agencies with their metrics in a single optimized query
-	type AgencyMetrics struct {
-		ID         string
-		Name       string
-		Slug       string
-		ParentID   *string
-		WordCount  int64
-		TitleCount int64
-	}
-	
-	var agencyMetrics []AgencyMetrics
-	err := database.DB.Raw(`
-		SELECT 
-			a.id,
-			a.name,
-			a.slug,
-			a.parent_id,
-			COALESCE(SUM(tc.word_count), 0) as word_count,
-			COUNT(DISTINCT acr.title_id) as title_count
-		FROM agencies a
-		LEFT JOIN agency_cfr_references acr ON a.id = acr.agency_id
-		LEFT JOIN title_contents tc ON acr.title_id = tc.title_id AND tc.word_count IS NOT NULL
-		GROUP BY a.id, a.name, a.slug, a.parent_id
-		ORDER BY word_count DESC
-	`).Scan(&agencyMetrics).Error
-	
-	if err != nil {
-		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
-		return
+	count := int(*restrictionCount)
+	if wordCount == 0 {
+		return &count, nil
 	}
+	density := float64(*restrictionCount) / float64(wordCount) * 1000
+	return &count, &density
+}
 
-	// Collect agency IDs for batch checksum calculation
+// buildAgenciesWithMetrics adds checksums and, when totalWords is not 0, the
+// share of all words to agency metrics.
+func buildAgenciesWithMetrics(asOf *time.Time, agencyMetrics []services.AgencyMetrics, totalWords int64) []AgencyWithMetrics {
 	agencyIDs := make([]uuid.UUID, len(agencyMetrics))
 	for i, metrics := range agencyMetrics {
-		agencyIDs[i] = uuid.MustParse(metrics.ID)
+		agencyIDs[i] = metrics.ID
 	}
-	
+
 	// Calculate all checksums in a single batch operation
-	checksums := getCachedAgencyChecksums(agencyIDs)
+	checksums := agencyChecksumsAsOf(asOf, agencyIDs)
+	readability, err := services.AgencyReadabilityAsOf(asOf)
+	if err != nil {
+		log.Printf("[HANDLER] buildAgenciesWithMetrics: %v", err)
+	}
 
-	// Build response with calculated metrics
 	var agenciesWithMetrics []AgencyWithMetrics
 	for _, metrics := range agencyMetrics {
-		var parentID *uuid.UUID
-		if metrics.ParentID != nil {
-			if parsed, err := uuid.Parse(*metrics.ParentID); err == nil {
-				parentID = &parsed
-			}
-		}
-
 		percentOfTotal := float64(0)
 		if totalWords > 0 {
 			percentOfTotal = float64(metrics.WordCount) / float64(totalWords) * 100
 		}
 
-		// Get checksum from batch calculation
 		var checksum *string
-		agencyID := uuid.MustParse(metrics.ID)
-		if checksumValue, exists := checksums[agencyID]; exists && checksumValue != "" {
+		if checksumValue, exists := checksums[metrics.ID]; exists && checksumValue != "" {
 			checksum = &checksumValue
 		}
 
+		restrictionCount, restrictionDensity := restrictionMetrics(metrics.RestrictionCount, metrics.WordCount)
+
 		agenciesWithMetrics = append(agenciesWithMetrics, AgencyWithMetrics{
-			ID:             agencyID,
-			Name:           metrics.Name,
-			Slug:           metrics.Slug,
-			WordCount:      int(metrics.WordCount),
-			PercentOfTotal: percentOfTotal,
-			TitleCount:     int(metrics.TitleCount),
-			Checksum:       checksum,
-			ParentID:       parentID,
+			ID:                       metrics.ID,
+			Name:                     metrics.Name,
+			Slug:                     metrics.Slug,
+			WordCount:                int(metrics.WordCount),
+			PercentOfTotal:           percentOfTotal,
+			TitleCount:               int(metrics.TitleCount),
+			Checksum:                 checksum,
+			ParentID:                 metrics.ParentID,
+			Source:                   metrics.Source,
+			RestrictionCount:         restrictionCount,
+			RestrictionsPer1000Words: restrictionDensity,
+			Readability:              readability[metrics.ID],
 		})
 	}
+	return agenciesWithMetrics
+}
+
+func AgenciesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[HANDLER] AgenciesHandler called")
+	if r.Method != http.MethodGet {
+		log.Printf("[HANDLER] AgenciesHandler: Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Metrics count the content version of each title in effect on asOf
+	totalWords, err := services.TotalWordCountAsOf(asOf)
+	if err != nil {
+		log.Printf("[HANDLER] AgenciesHandler: %v", err)
+		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
+		return
+	}
+	agencyMetrics, err := services.AgencyMetricsAsOf(asOf, nil)
+	if err != nil {
+		log.Printf("[HANDLER] AgenciesHandler: %v", err)
+		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
+		return
+	}
+
+	agenciesWithMetrics := buildAgenciesWithMetrics(asOf, agencyMetrics, totalWords)
 
 	response := APIResponse{
 		Data: agenciesWithMetrics,
 		Meta: Meta{
 			Total:       len(agenciesWithMetrics),
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -352,129 +344,91 @@ func AgencyDetailHandler(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/agencies/")
 	slug := strings.Split(path, "/")[0]
 
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var agency models.Agency
 	if err := database.DB.Where("slug = ?", slug).First(&agency).Error; err != nil {
 		http.Error(w, "Agency not found", http.StatusNotFound)
 		return
 	}
 
-	// Get agency metrics in a single query
-	type MainAgencyMetrics struct {
-		WordCount  int64
-		TitleCount int64
+	// Metrics count the content version of each title in effect on asOf
+	totals, err := services.AgencyTotalsAsOf(asOf, agency.ID)
+	if err != nil {
+		log.Printf("[HANDLER] AgencyDetailHandler: %v", err)
+		http.Error(w, "Failed to fetch agency", http.StatusInternalServerError)
+		return
 	}
-	
-	var metrics MainAgencyMetrics
+	var titleCount int64
 	database.DB.Raw(`
-		SELECT 
-			COALESCE(SUM(tc.word_count), 0) as word_count,
-			COUNT(DISTINCT acr.title_id) as title_count
-		FROM agency_cfr_references acr
-		LEFT JOIN title_contents tc ON acr.title_id = tc.title_id AND tc.word_count IS NOT NULL
-		WHERE acr.agency_id = ?
-	`, agency.ID).Scan(&metrics)
+		SELECT COUNT(DISTINCT acr.title_id) FROM agency_cfr_references acr WHERE acr.agency_id = ? AND acr.deleted_at IS NULL
+	`, agency.ID).Scan(&titleCount)
 
 	// Get sub-agencies with their metrics in one query
-	type SubAgencyMetrics struct {
-		ID         string
-		Name       string
-		Slug       string
-		ParentID   *string
-		WordCount  int64
-		TitleCount int64
-	}
-	
-	var subAgenciesMetrics []SubAgencyMetrics
-	database.DB.Raw(`
-		SELECT 
-			a.id,
-			a.name,
-			a.slug,
-			a.parent_id,
-			COALESCE(SUM(tc.word_count), 0) as word_count,
-			COUNT(DISTINCT acr.title_id) as title_count
-		FROM agencies a
-		LEFT JOIN agency_cfr_references acr ON a.id = acr.agency_id
-		LEFT JOIN title_contents tc ON acr.title_id = tc.title_id AND tc.word_count IS NOT NULL
-		WHERE a.parent_id = ?
-		GROUP BY a.id, a.name, a.slug, a.parent_id
-	`, agency.ID).Scan(&subAgenciesMetrics)
-
-	// Collect sub-agency IDs for batch checksum calculation
-	var subAgencyIDs []uuid.UUID
-	for _, subMetrics := range subAgenciesMetrics {
-		if subAgencyID, err := uuid.Parse(subMetrics.ID); err == nil {
-			subAgencyIDs = append(subAgencyIDs, subAgencyID)
-		}
-	}
-	
-	// Calculate checksums for all sub-agencies in batch
-	subChecksums := getCachedAgencyChecksums(subAgencyIDs)
-
-	var subAgenciesWithMetrics []AgencyWithMetrics
-	for _, subMetrics := range subAgenciesMetrics {
-		var parentID *uuid.UUID
-		if subMetrics.ParentID != nil {
-			if parsed, err := uuid.Parse(*subMetrics.ParentID); err == nil {
-				parentID = &parsed
-			}
-		}
-		
-		// Get checksum from batch calculation
-		var subChecksum *string
-		subAgencyID := uuid.MustParse(subMetrics.ID)
-		if checksumValue, exists := subChecksums[subAgencyID]; exists && checksumValue != "" {
-			subChecksum = &checksumValue
-		}
-
-		subAgenciesWithMetrics = append(subAgenciesWithMetrics, AgencyWithMetrics{
-			ID:         subAgencyID,
-			Name:       subMetrics.Name,
-			Slug:       subMetrics.Slug,
-			WordCount:  int(subMetrics.WordCount),
-			TitleCount: int(subMetrics.TitleCount),
-			Checksum:   subChecksum,
-			ParentID:   parentID,
-		})
+	subAgenciesMetrics, err := services.AgencyMetricsAsOf(asOf, &agency.ID)
+	if err != nil {
+		log.Printf("[HANDLER] AgencyDetailHandler: %v", err)
+		http.Error(w, "Failed to fetch agency", http.StatusInternalServerError)
+		return
 	}
+	subAgenciesWithMetrics := buildAgenciesWithMetrics(asOf, subAgenciesMetrics, 0)
 
 	// Get title breakdown
 	var titleBreakdowns []TitleBreakdown
-	rows, err := database.DB.Table("title_contents").
-		Select("titles.number, titles.name, COALESCE(SUM(title_contents.word_count), 0) as word_count").
-		Joins("JOIN titles ON titles.id = title_contents.title_id").
-		Joins("JOIN agency_cfr_references ON agency_cfr_references.title_id = titles.id").
-		Where("agency_cfr_references.agency_id = ? AND title_contents.word_count IS NOT NULL", agency.ID).
-		Group("titles.number, titles.name").
-		Rows()
-
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var breakdown TitleBreakdown
-			rows.Scan(&breakdown.TitleNumber, &breakdown.TitleName, &breakdown.WordCount)
-			titleBreakdowns = append(titleBreakdowns, breakdown)
-		}
+	breakdown, err := services.AgencyTitleBreakdownAsOf(asOf, agency.ID)
+	if err != nil {
+		log.Printf("[HANDLER] AgencyDetailHandler: %v", err)
+	}
+	for _, title := range breakdown {
+		restrictionCount, _ := restrictionMetrics(title.RestrictionCount, title.WordCount)
+		titleBreakdowns = append(titleBreakdowns, TitleBreakdown{
+			TitleNumber:      title.TitleNumber,
+			TitleName:        title.TitleName,
+			WordCount:        int(title.WordCount),
+			RestrictionCount: restrictionCount,
+		})
 	}
 
 	// Calculate checksum for this agency
 	var checksum *string
-	if checksumValue := calculateAgencyChecksum(agency.ID); checksumValue != "" {
+	if checksumValue := calculateAgencyChecksum(asOf, agency.ID); checksumValue != "" {
 		checksum = &checksumValue
 	}
 
+	readability, err := services.ReadabilityOfAgencyAsOf(asOf, agency.ID)
+	if err != nil {
+		log.Printf("[HANDLER] AgencyDetailHandler: %v", err)
+	}
+
+	statutes, err := services.AgencyStatutesAsOf(asOf, agency.ID)
+	if err != nil {
+		log.Printf("[HANDLER] AgencyDetailHandler: %v", err)
+		statutes = []services.AgencyStatute{}
+	}
+
+	restrictionCount, restrictionDensity := restrictionMetrics(totals.RestrictionCount, totals.WordCount)
+
 	agencyDetail := AgencyDetail{
 		AgencyWithMetrics: AgencyWithMetrics{
-			ID:         agency.ID,
-			Name:       agency.Name,
-			Slug:       agency.Slug,
-			WordCount:  int(metrics.WordCount),
-			TitleCount: int(metrics.TitleCount),
-			Checksum:   checksum,
-			ParentID:   agency.ParentID,
+			ID:                       agency.ID,
+			Name:                     agency.Name,
+			Slug:                     agency.Slug,
+			WordCount:                int(totals.WordCount),
+			TitleCount:               int(titleCount),
+			Checksum:                 checksum,
+			ParentID:                 agency.ParentID,
+			Source:                   totals.Source,
+			RestrictionCount:         restrictionCount,
+			RestrictionsPer1000Words: restrictionDensity,
+			Readability:              readability,
 		},
 		SubAgencies:    subAgenciesWithMetrics,
 		TitleBreakdown: titleBreakdowns,
+		Statutes:       statutes,
 	}
 
 	response := APIResponse{
@@ -482,6 +436,7 @@ func AgencyDetailHandler(w http.ResponseWriter, r *http.Request) {
 		Meta: Meta{
 			Total:       1,
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -497,6 +452,12 @@ func TitlesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var titles []models.Title
 	var titlesWithMetrics []TitleWithMetrics
 
@@ -505,48 +466,64 @@ func TitlesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get latest content for all titles in one query
-	type TitleMetrics struct {
-		TitleID   string
-		WordCount *int
-		Checksum  *string
+	// Get the content version of all titles in effect on asOf in one query
+	versions, err := services.TitleVersionsAsOf(asOf)
+	if err != nil {
+		log.Printf("[HANDLER] TitlesHandler: %v", err)
+		http.Error(w, "Failed to fetch titles", http.StatusInternalServerError)
+		return
 	}
-	
-	var titleMetrics []TitleMetrics
-	database.DB.Raw(`
-		SELECT DISTINCT ON (tc.title_id) 
-			tc.title_id, 
-			tc.word_count, 
-			tc.checksum 
-		FROM title_contents tc 
-		ORDER BY tc.title_id, tc.content_date DESC
-	`).Scan(&titleMetrics)
-	
-	// Create a map for quick lookup
-	metricsMap := make(map[string]TitleMetrics)
-	for _, metric := range titleMetrics {
-		metricsMap[metric.TitleID] = metric
+
+	amendmentStats, err := services.TitleAmendmentStatsByTitle()
+	if err != nil {
+		log.Printf("[HANDLER] TitlesHandler: %v", err)
+		http.Error(w, "Failed to fetch titles", http.StatusInternalServerError)
+		return
+	}
+
+	readability, err := services.TitleReadabilityAsOf(asOf)
+	if err != nil {
+		log.Printf("[HANDLER] TitlesHandler: %v", err)
+		http.Error(w, "Failed to fetch titles", http.StatusInternalServerError)
+		return
 	}
 
 	for _, title := range titles {
 		var wordCount int64
 		var checksum *string
+		var contentDate *time.Time
+		var source *string
+		var restrictionCount *int
+		var restrictionDensity *float64
 		
-		if metrics, exists := metricsMap[title.ID.String()]; exists {
-			if metrics.WordCount != nil {
-				wordCount = int64(*metrics.WordCount)
+		if version, exists := versions[title.ID]; exists {
+			if version.WordCount != nil {
+				wordCount = *version.WordCount
 			}
-			checksum = metrics.Checksum
+			checksum = version.Checksum
+			contentDate = &version.EffectiveDate
+			source = &version.Source
+			restrictionCount, restrictionDensity = restrictionMetrics(version.RestrictionCount, wordCount)
 		}
 
+		amendments := amendmentStats[title.ID]
 		titlesWithMetrics = append(titlesWithMetrics, TitleWithMetrics{
-			ID:              title.ID,
-			Number:          title.Number,
-			Name:            title.Name,
-			WordCount:       int(wordCount),
-			Checksum:        checksum,
-			LatestAmendedOn: title.LatestAmendedOn,
-			UpToDateAsOf:    title.UpToDateAsOf,
+			ID:                           title.ID,
+			Number:                       title.Number,
+			Name:                         title.Name,
+			WordCount:                    int(wordCount),
+			Checksum:                     checksum,
+			LatestAmendedOn:              title.LatestAmendedOn,
+			LatestIssueDate:              title.LatestIssueDate,
+			UpToDateAsOf:                 title.UpToDateAsOf,
+			ContentDate:                  contentDate,
+			Source:                       source,
+			RestrictionCount:             restrictionCount,
+			RestrictionsPer1000Words:     restrictionDensity,
+			Readability:                  readability[title.ID],
+			AmendmentCount:               amendments.AmendmentCount,
+			AmendmentsLastYear:           amendments.AmendmentsLastYear,
+			AverageDaysBetweenAmendments: amendments.AverageDaysBetween,
 		})
 	}
 
@@ -555,6 +532,7 @@ func TitlesHandler(w http.ResponseWriter, r *http.Request) {
 		Meta: Meta{
 			Total:       len(titlesWithMetrics),
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -570,86 +548,27 @@ func WordCountMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Calculate total CFR words
-	var totalWords int64
-	database.DB.Table("title_contents").
-		Select("COALESCE(SUM(word_count), 0)").
-		Where("word_count IS NOT NULL").
-		Scan(&totalWords)
-
-	// Reuse optimized agencies query from AgenciesHandler
-	type AgencyMetrics struct {
-		ID         string
-		Name       string
-		Slug       string
-		ParentID   *string
-		WordCount  int64
-		TitleCount int64
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	
-	var agencyMetrics []AgencyMetrics
-	err := database.DB.Raw(`
-		SELECT 
-			a.id,
-			a.name,
-			a.slug,
-			a.parent_id,
-			COALESCE(SUM(tc.word_count), 0) as word_count,
-			COUNT(DISTINCT acr.title_id) as title_count
-		FROM agencies a
-		LEFT JOIN agency_cfr_references acr ON a.id = acr.agency_id
-		LEFT JOIN title_contents tc ON acr.title_id = tc.title_id AND tc.word_count IS NOT NULL
-		GROUP BY a.id, a.name, a.slug, a.parent_id
-		ORDER BY word_count DESC
-	`).Scan(&agencyMetrics).Error
-	
+
+	// Metrics count the content version of each title in effect on asOf
+	totalWords, err := services.TotalWordCountAsOf(asOf)
 	if err != nil {
+		log.Printf("[HANDLER] WordCountMetricsHandler: %v", err)
 		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
 		return
 	}
-
-	// Collect agency IDs for batch checksum calculation
-	agencyIDs := make([]uuid.UUID, len(agencyMetrics))
-	for i, metrics := range agencyMetrics {
-		agencyIDs[i] = uuid.MustParse(metrics.ID)
+	agencyMetrics, err := services.AgencyMetricsAsOf(asOf, nil)
+	if err != nil {
+		log.Printf("[HANDLER] WordCountMetricsHandler: %v", err)
+		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
+		return
 	}
-	
-	// Calculate all checksums in a single batch operation
-	checksums := getCachedAgencyChecksums(agencyIDs)
 
-	// Build response with calculated metrics
-	var agenciesWithMetrics []AgencyWithMetrics
-	for _, metrics := range agencyMetrics {
-		var parentID *uuid.UUID
-		if metrics.ParentID != nil {
-			if parsed, err := uuid.Parse(*metrics.ParentID); err == nil {
-				parentID = &parsed
-			}
-		}
-
-		percentOfTotal := float64(0)
-		if totalWords > 0 {
-			percentOfTotal = float64(metrics.WordCount) / float64(totalWords) * 100
-		}
-
-		// Get checksum from batch calculation
-		var checksum *string
-		agencyID := uuid.MustParse(metrics.ID)
-		if checksumValue, exists := checksums[agencyID]; exists && checksumValue != "" {
-			checksum = &checksumValue
-		}
-
-		agenciesWithMetrics = append(agenciesWithMetrics, AgencyWithMetrics{
-			ID:             agencyID,
-			Name:           metrics.Name,
-			Slug:           metrics.Slug,
-			WordCount:      int(metrics.WordCount),
-			PercentOfTotal: percentOfTotal,
-			TitleCount:     int(metrics.TitleCount),
-			Checksum:       checksum,
-			ParentID:       parentID,
-		})
-	}
+	agenciesWithMetrics := buildAgenciesWithMetrics(asOf, agencyMetrics, totalWords)
 
 	wordCountMetrics := WordCountMetrics{
 		TotalCFRWords: int(totalWords),
@@ -661,6 +580,7 @@ func WordCountMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		Meta: Meta{
 			Total:       len(agenciesWithMetrics),
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -674,25 +594,36 @@ func ChecksumsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var checksumInfos []ChecksumInfo
-
-	rows, err := database.DB.Table("title_contents").
-		Select("titles.number, titles.name, title_contents.checksum, title_contents.created_at").
-		Joins("JOIN titles ON titles.id = title_contents.title_id").
-		Where("title_contents.checksum IS NOT NULL").
-		Order("titles.number").
-		Rows()
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	var titles []models.Title
+	if err := database.DB.Order("number").Find(&titles).Error; err != nil {
+		http.Error(w, "Failed to fetch checksums", http.StatusInternalServerError)
+		return
+	}
+	versions, err := services.TitleVersionsAsOf(asOf)
 	if err != nil {
+		log.Printf("[HANDLER] ChecksumsHandler: %v", err)
 		http.Error(w, "Failed to fetch checksums", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var info ChecksumInfo
-		rows.Scan(&info.TitleNumber, &info.TitleName, &info.Checksum, &info.LastChanged)
-		checksumInfos = append(checksumInfos, info)
+	var checksumInfos []ChecksumInfo
+	for _, title := range titles {
+		version, exists := versions[title.ID]
+		if !exists || version.Checksum == nil {
+			continue
+		}
+		checksumInfos = append(checksumInfos, ChecksumInfo{
+			TitleNumber: title.Number,
+			TitleName:   title.Name,
+			Checksum:    version.Checksum,
+			LastChanged: version.RecordedAt,
+		})
 	}
 
 	response := APIResponse{
@@ -700,6 +631,7 @@ func ChecksumsHandler(w http.ResponseWriter, r *http.Request) {
 		Meta: Meta{
 			Total:       len(checksumInfos),
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -723,56 +655,45 @@ func AgencyChecksumsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Reuse optimized agencies query to get agencies with metrics
-	type AgencyMetrics struct {
-		ID         string
-		Name       string
-		Slug       string
-		WordCount  int64
-		TitleCount int64
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	
-	var agencyMetrics []AgencyMetrics
-	err := database.DB.Raw(`
-		SELECT 
-			a.id,
-			a.name,
-			a.slug,
-			COALESCE(SUM(tc.word_count), 0) as word_count,
-			COUNT(DISTINCT acr.title_id) as title_count
-		FROM agencies a
-		LEFT JOIN agency_cfr_references acr ON a.id = acr.agency_id
-		LEFT JOIN title_contents tc ON acr.title_id = tc.title_id AND tc.word_count IS NOT NULL
-		GROUP BY a.id, a.name, a.slug
-		HAVING COALESCE(SUM(tc.word_count), 0) > 0
-		ORDER BY word_count DESC
-	`).Scan(&agencyMetrics).Error
-	
+
+	// Agencies with content in the version of each title in effect on asOf
+	allMetrics, err := services.AgencyMetricsAsOf(asOf, nil)
 	if err != nil {
+		log.Printf("[HANDLER] AgencyChecksumsHandler: %v", err)
 		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
 		return
 	}
+	var agencyMetrics []services.AgencyMetrics
+	for _, metrics := range allMetrics {
+		if metrics.WordCount > 0 {
+			agencyMetrics = append(agencyMetrics, metrics)
+		}
+	}
 
 	// Collect agency IDs for batch checksum calculation
 	agencyIDs := make([]uuid.UUID, len(agencyMetrics))
 	for i, metrics := range agencyMetrics {
-		agencyIDs[i] = uuid.MustParse(metrics.ID)
+		agencyIDs[i] = metrics.ID
 	}
 	
 	// Calculate all checksums in a single batch operation
-	checksums := getCachedAgencyChecksums(agencyIDs)
+	checksums := agencyChecksumsAsOf(asOf, agencyIDs)
 
 	var agencyChecksumInfos []AgencyChecksumInfo
 	for _, metrics := range agencyMetrics {
 		// Get checksum from batch calculation
 		var checksum *string
-		agencyID := uuid.MustParse(metrics.ID)
-		if checksumValue, exists := checksums[agencyID]; exists && checksumValue != "" {
+		if checksumValue, exists := checksums[metrics.ID]; exists && checksumValue != "" {
 			checksum = &checksumValue
 		}
 
 		agencyChecksumInfos = append(agencyChecksumInfos, AgencyChecksumInfo{
-			AgencyID:    metrics.ID,
+			AgencyID:    metrics.ID.String(),
 			AgencyName:  metrics.Name,
 			AgencySlug:  metrics.Slug,
 			Checksum:    checksum,
@@ -787,6 +708,7 @@ func AgencyChecksumsHandler(w http.ResponseWriter, r *http.Request) {
 		Meta: Meta{
 			Total:       len(agencyChecksumInfos),
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -803,6 +725,14 @@ func HistoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	agencySlug := r.URL.Query().Get("agency")
 	monthsStr := r.URL.Query().Get("months")
+	// method=full_text restricts the trend to real word counts
+	method := r.URL.Query().Get("method")
+	// asOf ends the trend on that date instead of today
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	
 	months := 12 // default to 12 months
 	if monthsStr != "" {
@@ -813,17 +743,19 @@ func HistoryHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Calculate date range
 	endDate := time.Now().UTC()
+	if asOf != nil {
+		endDate = *asOf
+	}
 	startDate := endDate.AddDate(0, -months, 0)
 
 	var history []HistoricalPoint
-	var err error
 
 	if agencySlug != "" {
 		// Get history for specific agency
-		history, err = getAgencyHistory(agencySlug, startDate, endDate)
+		history, err = getAgencyHistory(agencySlug, method, startDate, endDate)
 	} else {
 		// Get overall CFR history
-		history, err = getOverallHistory(startDate, endDate)
+		history, err = getOverallHistory(method, startDate, endDate)
 	}
 
 	if err != nil {
@@ -836,6 +768,7 @@ func HistoryHandler(w http.ResponseWriter, r *http.Request) {
 		Meta: Meta{
 			Total:       len(history),
 			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
 		},
 	}
 
@@ -844,20 +777,25 @@ func HistoryHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // getOverallHistory retrieves overall CFR word count history
-func getOverallHistory(startDate, endDate time.Time) ([]HistoricalPoint, error) {
+func getOverallHistory(method string, startDate, endDate time.Time) ([]HistoricalPoint, error) {
 	type SnapshotData struct {
-		SnapshotDate time.Time
-		WordCount    int
+		SnapshotDate      time.Time
+		WordCount         int
+		RestrictionCount  *int
+		MeasurementMethod *string
 	}
 
 	var snapshots []SnapshotData
 	
 	// Query historical snapshots for overall data (no agency_id or title_id)
-	err := database.DB.Table("historical_snapshots").
-		Select("snapshot_date, word_count").
+	query := database.DB.Table("historical_snapshots").
+		Select("snapshot_date, word_count, restriction_count, measurement_method").
 		Where("snapshot_date >= ? AND snapshot_date <= ?", startDate.Format("2006-01-02"), endDate.Format("2006-01-02")).
-		Where("agency_id IS NULL AND title_id IS NULL").
-		Order("snapshot_date ASC").
+		Where("agency_id IS NULL AND title_id IS NULL")
+	if method != "" {
+		query = query.Where("measurement_method = ?", method)
+	}
+	err := query.Order("snapshot_date ASC").
 		Scan(&snapshots).Error
 	
 	if err != nil {
@@ -873,9 +811,11 @@ func getOverallHistory(startDate, endDate time.Time) ([]HistoricalPoint, error)
 		}
 
 		history = append(history, HistoricalPoint{
-			Date:          snapshot.SnapshotDate.Format("2006-01-02"),
-			WordCount:     snapshot.WordCount,
-			ChangePercent: changePercent,
+			Date:              snapshot.SnapshotDate.Format("2006-01-02"),
+			WordCount:         snapshot.WordCount,
+			ChangePercent:     changePercent,
+			MeasurementMethod: snapshot.MeasurementMethod,
+			RestrictionCount:  snapshot.RestrictionCount,
 		})
 	}
 
@@ -883,22 +823,27 @@ func getOverallHistory(startDate, endDate time.Time) ([]HistoricalPoint, error)
 }
 
 // getAgencyHistory retrieves word count history for a specific agency
-func getAgencyHistory(agencySlug string, startDate, endDate time.Time) ([]HistoricalPoint, error) {
+func getAgencyHistory(agencySlug, method string, startDate, endDate time.Time) ([]HistoricalPoint, error) {
 	type SnapshotData struct {
-		SnapshotDate time.Time
-		WordCount    int
+		SnapshotDate      time.Time
+		WordCount         int
+		RestrictionCount  *int
+		MeasurementMethod *string
 	}
 
 	var snapshots []SnapshotData
 	
 	// Query historical snapshots for specific agency
-	err := database.DB.Table("historical_snapshots hs").
-		Select("hs.snapshot_date, hs.word_count").
+	query := database.DB.Table("historical_snapshots hs").
+		Select("hs.snapshot_date, hs.word_count, hs.restriction_count, hs.measurement_method").
 		Joins("JOIN agencies a ON a.id = hs.agency_id").
 		Where("a.slug = ?", agencySlug).
 		Where("hs.snapshot_date >= ? AND hs.snapshot_date <= ?", startDate.Format("2006-01-02"), endDate.Format("2006-01-02")).
-		Where("hs.title_id IS NULL"). // Agency-level snapshots only
-		Order("hs.snapshot_date ASC").
+		Where("hs.title_id IS NULL") // Agency-level snapshots only
+	if method != "" {
+		query = query.Where("hs.measurement_method = ?", method)
+	}
+	err := query.Order("hs.snapshot_date ASC").
 		Scan(&snapshots).Error
 	
 	if err != nil {
@@ -914,9 +859,11 @@ func getAgencyHistory(agencySlug string, startDate, endDate time.Time) ([]Histor
 		}
 
 		history = append(history, HistoricalPoint{
-			Date:          snapshot.SnapshotDate.Format("2006-01-02"),
-			WordCount:     snapshot.WordCount,
-			ChangePercent: changePercent,
+			Date:              snapshot.SnapshotDate.Format("2006-01-02"),
+			WordCount:         snapshot.WordCount,
+			ChangePercent:     changePercent,
+			MeasurementMethod: snapshot.MeasurementMethod,
+			RestrictionCount:  snapshot.RestrictionCount,
 		})
 	}
 
@@ -953,130 +900,29 @@ func CalculateChecksumsHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[HANDLER] CalculateChecksumsHandler called")
 
-	// Get all agencies
-	var agencies []models.Agency
-	if err := database.DB.Find(&agencies).Error; err != nil {
-		log.Printf("[HANDLER] Failed to fetch agencies: %v", err)
+	stats, err := checksumService.RecalculateAll()
+	if err != nil {
+		log.Printf("[HANDLER] Failed to calculate checksums: %v", err)
 		http.Error(w, "Failed to fetch agencies", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("[HANDLER] Found %d agencies to process", len(agencies))
-
-	successCount := 0
-	errorCount := 0
-	skippedCount := 0
-
-	// Process each agency
-	for _, agency := range agencies {
-		result, err := calculateAndStoreAgencyChecksum(agency.ID)
-		if err != nil {
-			log.Printf("[HANDLER] Failed to process agency %s: %v", agency.Name, err)
-			errorCount++
-			continue
-		}
-		
-		switch result {
-		case "created":
-			successCount++
-		case "updated": 
-			successCount++
-		case "skipped":
-			skippedCount++
-		}
-	}
-
-	log.Printf("[HANDLER] Calculation completed: %d created/updated, %d skipped, %d errors", 
-		successCount, skippedCount, errorCount)
-
 	response := map[string]interface{}{
-		"success": errorCount == 0,
-		"message": fmt.Sprintf("Processed %d agencies", len(agencies)),
+		"success": stats.Errors == 0,
+		"message": fmt.Sprintf("Processed %d agencies", stats.Total),
 		"stats": map[string]int{
-			"total": len(agencies),
-			"created_updated": successCount,
-			"skipped": skippedCount,
-			"errors": errorCount,
+			"total": stats.Total,
+			"created_updated": stats.CreatedUpdated,
+			"skipped": stats.Skipped,
+			"errors": stats.Errors,
 		},
 	}
 
-	if errorCount > 0 {
-		response["message"] = fmt.Sprintf("Processed %d agencies with %d errors", len(agencies), errorCount)
+	if stats.Errors > 0 {
+		response["message"] = fmt.Sprintf("Processed %d agencies with %d errors", stats.Total, stats.Errors)
 		w.WriteHeader(http.StatusPartialContent)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
-
-func calculateAndStoreAgencyChecksum(agencyID uuid.UUID) (string, error) {
-	// Get all title checksums for this agency (using existing title_contents.checksum)
-	type TitleChecksum struct {
-		TitleNumber int
-		Checksum    string
-	}
-
-	var titleChecksums []TitleChecksum
-	err := database.DB.Table("title_contents tc").
-		Select("t.number as title_number, tc.checksum").
-		Joins("JOIN titles t ON tc.title_id = t.id").
-		Joins("JOIN agency_cfr_references acr ON acr.title_id = t.id").
-		Where("acr.agency_id = ? AND tc.checksum IS NOT NULL AND tc.checksum != ''", agencyID).
-		Order("t.number ASC"). // Deterministic order
-		Scan(&titleChecksums).Error
-
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch title checksums: %w", err)
-	}
-
-	if len(titleChecksums) == 0 {
-		// No content for this agency, skip
-		return "skipped", nil
-	}
-
-	// Create deterministic content hash from title checksums
-	var contentBuilder strings.Builder
-	for _, tc := range titleChecksums {
-		contentBuilder.WriteString(fmt.Sprintf("TITLE_%d:%s\n", tc.TitleNumber, tc.Checksum))
-	}
-
-	contentHash := fmt.Sprintf("%x", sha256.Sum256([]byte(contentBuilder.String())))
-	
-	// Create agency checksum from the combined content
-	agencyChecksum := fmt.Sprintf("%x", sha256.Sum256([]byte(contentBuilder.String())))
-
-	// Check if we need to update (content changed)
-	var existingChecksum models.AgencyChecksum
-	err = database.DB.Where("agency_id = ?", agencyID).First(&existingChecksum).Error
-	
-	if err == nil {
-		// Record exists, check if content hash changed
-		if existingChecksum.ContentHash == contentHash {
-			// No change needed
-			return "skipped", nil
-		}
-		
-		// Update existing record
-		existingChecksum.Checksum = agencyChecksum
-		existingChecksum.ContentHash = contentHash
-		existingChecksum.UpdatedAt = time.Now().UTC()
-		
-		if err := database.DB.Save(&existingChecksum).Error; err != nil {
-			return "", err
-		}
-		return "updated", nil
-	} else {
-		// Create new record
-		newChecksum := models.AgencyChecksum{
-			AgencyID:    agencyID,
-			Checksum:    agencyChecksum,
-			ContentHash: contentHash,
-			UpdatedAt:   time.Now().UTC(),
-		}
-		
-		if err := database.DB.Create(&newChecksum).Error; err != nil {
-			return "", err
-		}
-		return "created", nil
-	}
-}
\ No newline at end of file
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ContentStrategiesHandler reports the configured content download strategies
+// in order, with their health and download statistics. probe=true runs every
+// strategy's health probe first.
+func ContentStrategiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	downloader := GetImportService().ContentDownloader()
+	if r.URL.Query().Get("probe") == "true" {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		downloader.ProbeAll(ctx)
+		cancel()
+	}
+
+	stats := downloader.Stats()
+	response := APIResponse{
+		Data: stats,
+		Meta: Meta{
+			Total:       len(stats),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
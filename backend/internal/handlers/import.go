@@ -2,14 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
 	"ecfr-analyzer/internal/services"
 )
 
 var importService = services.NewImportService()
 var historicalService = services.NewHistoricalService()
+var structureService = services.NewStructureService()
+var checksumService = services.NewChecksumService()
 
 func ImportAgenciesHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[HANDLER] ImportAgenciesHandler called")
@@ -20,16 +24,22 @@ func ImportAgenciesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[HANDLER] ImportAgenciesHandler: Starting agency import in background")
-	go func() {
-		if err := importService.ImportAgencies(); err != nil {
-			log.Printf("[HANDLER] ImportAgenciesHandler: Agency import failed: %v", err)
-		}
-	}()
+	run, err := importService.StartImport(services.ImportKindAgencies, services.ImportOptions{})
+	if errors.Is(err, services.ErrImportInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] ImportAgenciesHandler: Failed to start agency import: %v", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]string{
 		"message": "Agency import started",
 		"status":  "started",
+		"runId":   run.ID.String(),
 	}
 	json.NewEncoder(w).Encode(response)
 	log.Printf("[HANDLER] ImportAgenciesHandler: Response sent")
@@ -41,16 +51,47 @@ func ImportTitlesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func() {
-		if err := importService.ImportTitles(); err != nil {
-			// Error is already logged in the service
+	// mode=refresh only downloads titles amended since the last import
+	kind := services.ImportKindTitles
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "full":
+	case "refresh":
+		kind = services.ImportKindRefresh
+	default:
+		http.Error(w, "Unknown mode, expected full or refresh", http.StatusBadRequest)
+		return
+	}
+
+	// replace=true downloads titles again even if their current version is
+	// stored, replacing it when the content differs
+	var options services.ImportOptions
+	if replace := r.URL.Query().Get("replace"); replace != "" {
+		value, err := strconv.ParseBool(replace)
+		if err != nil {
+			http.Error(w, "Invalid replace, expected true or false", http.StatusBadRequest)
+			return
 		}
-	}()
+		options.Replace = value
+	}
+
+	run, err := importService.StartImport(kind, options)
+	if errors.Is(err, services.ErrImportInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] ImportTitlesHandler: Failed to start title import: %v", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]string{
 		"message": "Title import started",
 		"status":  "started",
+		"runId":   run.ID.String(),
+		"kind":    kind,
+		"replace": strconv.FormatBool(options.Replace),
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -63,21 +104,55 @@ func ImportHistoricalSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func() {
-		// First capture current snapshot
-		if err := historicalService.CaptureSnapshot(); err != nil {
-			// Error is already logged in the service
-		}
-		// Then import historical data from eCFR API
-		if err := historicalService.ImportHistoricalData(); err != nil {
-			// Error is already logged in the service
-		}
-	}()
+	// mode=full-text counts words in the full XML of every historical date
+	// instead of estimating them from the structure size
+	mode, err := services.ParseHistoricalMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = historicalService.StartHistoricalImport(mode)
+	if errors.Is(err, services.ErrImportInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] ImportHistoricalSnapshotsHandler: Failed to start historical import: %v", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]string{
 		"message": "Historical snapshots import started",
 		"status":  "started",
+		"mode":    string(mode),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func ImportStructureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := structureService.StartMissingStructures()
+	if errors.Is(err, services.ErrImportInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] ImportStructureHandler: Failed to start structure import: %v", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]string{
+		"message": "Structure import started",
+		"status":  "started",
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -96,4 +171,15 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 // GetImportService returns the import service instance for use in main.go
 func GetImportService() *services.ImportService {
 	return importService
-}
\ No newline at end of file
+}
+
+// GetHistoricalService returns the historical service instance for use in
+// main.go
+func GetHistoricalService() *services.HistoricalService {
+	return historicalService
+}
+
+// GetChecksumService returns the checksum service instance for use in main.go
+func GetChecksumService() *services.ChecksumService {
+	return checksumService
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+	"ecfr-analyzer/internal/services"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ImportRunsHandler lists import runs, newest first. limit=N (default 50) caps
+// the result, status=running|succeeded|partial|failed|interrupted and kind=...
+// filter it.
+func ImportRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 500 {
+			http.Error(w, "Invalid limit, expected 1-500", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	query := database.DB.Model(&models.ImportRun{})
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var runs []models.ImportRun
+	if err := query.Order("started_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		log.Printf("[HANDLER] ImportRunsHandler: Failed to fetch import runs: %v", err)
+		http.Error(w, "Failed to fetch import runs", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: runs,
+		Meta: Meta{
+			Total:       len(runs),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ImportRunDetailHandler returns one import run with its per-title results on
+// GET /api/v1/imports/{id} and cancels it on POST /api/v1/imports/{id}/cancel.
+func ImportRunDetailHandler(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/imports/"), "/"), "/")
+	runID, err := uuid.Parse(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid import run id", http.StatusBadRequest)
+		return
+	}
+
+	if len(segments) == 2 && segments[1] == "cancel" {
+		cancelImportRun(w, r, runID)
+		return
+	}
+	if len(segments) > 1 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var run models.ImportRun
+	err = database.DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("title_number")
+	}).First(&run, "id = ?", runID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Import run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] ImportRunDetailHandler: Failed to fetch import run %s: %v", runID, err)
+		http.Error(w, "Failed to fetch import run", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: run,
+		Meta: Meta{
+			Total:       len(run.Items),
+			LastUpdated: run.UpdatedAt,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func cancelImportRun(w http.ResponseWriter, r *http.Request, runID uuid.UUID) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := importService.CancelImport(runID)
+	switch {
+	case errors.Is(err, services.ErrImportRunNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrImportRunFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[HANDLER] cancelImportRun: Failed to cancel import run %s: %v", runID, err)
+		http.Error(w, "Failed to cancel import run", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	response := map[string]string{
+		"message": "Import cancellation requested",
+		"status":  "cancelling",
+		"runId":   runID.String(),
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"ecfr-analyzer/internal/models"
+	"ecfr-analyzer/internal/services"
+)
+
+// titleParts lists the parts of a title with the statutes named in their
+// authority notes and the Federal Register documents that published and
+// amended them, in the content in effect on asOf.
+func titleParts(w http.ResponseWriter, r *http.Request, title models.Title) {
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parts, err := services.TitlePartNotesAsOf(asOf, title.ID)
+	if err != nil {
+		log.Printf("[HANDLER] titleParts: %v", err)
+		http.Error(w, "Failed to fetch parts", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: parts,
+		Meta: Meta{
+			Total:       len(parts),
+			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
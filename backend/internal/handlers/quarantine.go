@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/services"
+
+	"github.com/google/uuid"
+)
+
+// QuarantineHandler lists downloaded content held back by validation, newest
+// first. status=pending|released|discarded filters it and limit=N (default
+// 50) caps the result.
+func QuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 500 {
+			http.Error(w, "Invalid limit, expected 1-500", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := services.ListQuarantinedContent(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		log.Printf("[HANDLER] QuarantineHandler: %v", err)
+		http.Error(w, "Failed to fetch quarantined content", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: entries,
+		Meta: Meta{
+			Total:       len(entries),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// QuarantineReviewHandler settles quarantined content: POST
+// /api/v1/quarantine/{id}/release stores it as the title's content and POST
+// /api/v1/quarantine/{id}/discard rejects it.
+func QuarantineReviewHandler(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/quarantine/"), "/"), "/")
+	if len(segments) != 2 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	id, err := uuid.Parse(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid quarantine id", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result interface{}
+	switch segments[1] {
+	case "release":
+		result, err = importService.ReleaseQuarantinedContent(r.Context(), id)
+	case "discard":
+		result, err = services.DiscardQuarantinedContent(id)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrQuarantineNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrQuarantineReviewed), errors.Is(err, services.ErrQuarantineMalformed),
+		errors.Is(err, services.ErrImportInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[HANDLER] QuarantineReviewHandler: Failed to %s %s: %v", segments[1], id, err)
+		http.Error(w, "Failed to review quarantined content", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: result,
+		Meta: Meta{
+			Total:       1,
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecfr-analyzer/internal/services"
+)
+
+// ReadabilityMetricsHandler ranks the hardest-to-read regulations.
+// level=section|title|agency (default title) picks what is ranked and
+// sort=fleschKincaidGrade|gunningFog|averageSentenceLength|longWordRatio
+// (default fleschKincaidGrade) the score ranked by. title=N narrows sections
+// and titles, agency=<slug> sections and agencies; minWords=N (default 100)
+// leaves out short texts, limit=N (default 50) caps the result and asOf scores
+// the content in effect on that date.
+func ReadabilityMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := services.ReadabilityParams{
+		Level:      r.URL.Query().Get("level"),
+		SortBy:     r.URL.Query().Get("sort"),
+		AgencySlug: r.URL.Query().Get("agency"),
+		MinWords:   100,
+		Limit:      50,
+	}
+	switch params.Level {
+	case "":
+		params.Level = services.ReadabilityLevelTitle
+	case services.ReadabilityLevelSection, services.ReadabilityLevelTitle, services.ReadabilityLevelAgency:
+	default:
+		http.Error(w, "Invalid level, expected section, title or agency", http.StatusBadRequest)
+		return
+	}
+	if params.SortBy == "" {
+		params.SortBy = services.ReadabilitySortFleschKincaid
+	} else if !services.ValidReadabilitySort(params.SortBy) {
+		http.Error(w, "Invalid sort, expected fleschKincaidGrade, gunningFog, averageSentenceLength or longWordRatio", http.StatusBadRequest)
+		return
+	}
+	if titleStr := r.URL.Query().Get("title"); titleStr != "" {
+		number, err := strconv.Atoi(titleStr)
+		if err != nil {
+			http.Error(w, "Invalid title number", http.StatusBadRequest)
+			return
+		}
+		params.TitleNumber = &number
+	}
+	if minWordsStr := r.URL.Query().Get("minWords"); minWordsStr != "" {
+		parsed, err := strconv.Atoi(minWordsStr)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid minWords", http.StatusBadRequest)
+			return
+		}
+		params.MinWords = parsed
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 500 {
+			http.Error(w, "Invalid limit, expected 1-500", http.StatusBadRequest)
+			return
+		}
+		params.Limit = parsed
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.AsOf = asOf
+
+	items, err := services.ReadabilityRanking(params)
+	if err != nil {
+		log.Printf("[HANDLER] ReadabilityMetricsHandler: %v", err)
+		http.Error(w, "Failed to fetch readability metrics", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: items,
+		Meta: Meta{
+			Total:       len(items),
+			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecfr-analyzer/internal/models"
+	"ecfr-analyzer/internal/services"
+)
+
+// titleReferences lists what the section=<identifier> of a title cites and
+// the sections citing it, in the content in effect on asOf.
+func titleReferences(w http.ResponseWriter, r *http.Request, title models.Title) {
+	identifier := r.URL.Query().Get("section")
+	if identifier == "" {
+		http.Error(w, "Missing section", http.StatusBadRequest)
+		return
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	graph, err := services.SectionReferencesOf(title.Number, identifier, asOf)
+	if errors.Is(err, services.ErrSectionNotFound) {
+		http.Error(w, "Section not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] titleReferences: %v", err)
+		http.Error(w, "Failed to fetch references", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: graph,
+		Meta: Meta{
+			Total:       len(graph.Outbound) + len(graph.Inbound),
+			LastUpdated: graph.ContentDate,
+			AsOf:        formatAsOf(asOf),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// MostCitedHandler ranks the targets cited by the most sections.
+// type=section|part|usc (default section) picks what is ranked, title=N
+// narrows it to one CFR or U.S.C. title, limit=N (default 50) caps the result
+// and asOf counts the citations in the content in effect on that date.
+func MostCitedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetType := r.URL.Query().Get("type")
+	switch targetType {
+	case "":
+		targetType = services.ReferenceTargetSection
+	case services.ReferenceTargetSection, services.ReferenceTargetPart, services.ReferenceTargetUSC:
+	default:
+		http.Error(w, "Invalid type, expected section, part or usc", http.StatusBadRequest)
+		return
+	}
+	var titleNumber *int
+	if titleStr := r.URL.Query().Get("title"); titleStr != "" {
+		number, err := strconv.Atoi(titleStr)
+		if err != nil {
+			http.Error(w, "Invalid title number", http.StatusBadRequest)
+			return
+		}
+		titleNumber = &number
+	}
+	limit, ok := parseReferenceLimit(w, r)
+	if !ok {
+		return
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targets, err := services.MostCited(targetType, titleNumber, asOf, limit)
+	if err != nil {
+		log.Printf("[HANDLER] MostCitedHandler: %v", err)
+		http.Error(w, "Failed to fetch most cited", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: targets,
+		Meta: Meta{
+			Total:       len(targets),
+			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AgencyDependenciesHandler ranks pairs of agencies by how often the sections
+// of one cite the sections and parts of the other. agency=<slug> keeps the
+// pairs with that agency on either side, limit=N (default 50) caps the result
+// and asOf counts the citations in the content in effect on that date.
+func AgencyDependenciesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, ok := parseReferenceLimit(w, r)
+	if !ok {
+		return
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dependencies, err := services.AgencyDependencies(r.URL.Query().Get("agency"), asOf, limit)
+	if err != nil {
+		log.Printf("[HANDLER] AgencyDependenciesHandler: %v", err)
+		http.Error(w, "Failed to fetch agency dependencies", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: dependencies,
+		Meta: Meta{
+			Total:       len(dependencies),
+			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseReferenceLimit reads limit=N (default 50), writing the error response
+// when it is invalid.
+func parseReferenceLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return 50, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > 500 {
+		http.Error(w, "Invalid limit, expected 1-500", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/scheduler"
+)
+
+// jobScheduler is nil when the scheduler is disabled.
+var jobScheduler *scheduler.Scheduler
+
+// SetScheduler makes the running scheduler available to the schedule endpoints.
+func SetScheduler(s *scheduler.Scheduler) {
+	jobScheduler = s
+}
+
+// SchedulesHandler lists the scheduled jobs with their next and last runs.
+func SchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []scheduler.EntryStatus{}
+	if jobScheduler != nil {
+		var err error
+		statuses, err = jobScheduler.Status()
+		if err != nil {
+			log.Printf("[HANDLER] SchedulesHandler: Failed to fetch schedules: %v", err)
+			http.Error(w, "Failed to fetch schedules", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := APIResponse{
+		Data: statuses,
+		Meta: Meta{
+			Total:       len(statuses),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ScheduleRunsHandler returns the run history of one job on
+// GET /api/v1/schedules/{name}/runs. limit=N (default 50) caps the result.
+func ScheduleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/schedules/"), "/"), "/")
+	if len(segments) != 2 || segments[0] == "" || segments[1] != "runs" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	jobName := segments[0]
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 500 {
+			http.Error(w, "Invalid limit, expected 1-500", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := scheduler.History(jobName, limit)
+	if err != nil {
+		log.Printf("[HANDLER] ScheduleRunsHandler: %v", err)
+		http.Error(w, "Failed to fetch schedule runs", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: runs,
+		Meta: Meta{
+			Total:       len(runs),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
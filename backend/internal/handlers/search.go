@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/services"
+)
+
+// SearchHandler searches the text of every section and appendix. q is the
+// search in web search syntax; title=N and agency=<slug> narrow it, asOf
+// searches the content in effect on that date, and limit=N (default 20) and
+// offset=N page through the hits. Meta.Total counts every match.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := services.SearchParams{
+		Query:      strings.TrimSpace(r.URL.Query().Get("q")),
+		AgencySlug: r.URL.Query().Get("agency"),
+		Limit:      20,
+	}
+	if params.Query == "" {
+		http.Error(w, "Missing q", http.StatusBadRequest)
+		return
+	}
+	if titleStr := r.URL.Query().Get("title"); titleStr != "" {
+		number, err := strconv.Atoi(titleStr)
+		if err != nil {
+			http.Error(w, "Invalid title number", http.StatusBadRequest)
+			return
+		}
+		params.TitleNumber = &number
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 100 {
+			http.Error(w, "Invalid limit, expected 1-100", http.StatusBadRequest)
+			return
+		}
+		params.Limit = parsed
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		parsed, err := strconv.Atoi(offsetStr)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		params.Offset = parsed
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.AsOf = asOf
+
+	results, err := services.Search(params)
+	if err != nil {
+		log.Printf("[HANDLER] SearchHandler: Failed to search %q: %v", params.Query, err)
+		http.Error(w, "Failed to search", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: results,
+		Meta: Meta{
+			Total:       results.Total,
+			LastUpdated: time.Now(),
+			AsOf:        formatAsOf(asOf),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+	"ecfr-analyzer/internal/services"
+
+	"github.com/google/uuid"
+)
+
+var diffService = services.NewDiffService()
+
+type StructureNodeWithMetrics struct {
+	ID         uuid.UUID  `json:"id"`
+	ParentID   *uuid.UUID `json:"parentId,omitempty"`
+	Type       string     `json:"type"`
+	Identifier string     `json:"identifier"`
+	Heading    string     `json:"heading"`
+	Chapter    *string    `json:"chapter,omitempty"`
+	Subchapter *string    `json:"subchapter,omitempty"`
+	Part       *string    `json:"part,omitempty"`
+	WordCount  int        `json:"wordCount"`
+	Checksum   string     `json:"checksum"`
+	// Restrictions and Readability cover the node and all of its descendants
+	Restrictions RestrictionBreakdown        `json:"restrictions"`
+	Readability  *services.ReadabilityScores `json:"readability,omitempty"`
+}
+
+// RestrictionBreakdown counts each obligation and prohibition term.
+type RestrictionBreakdown struct {
+	Shall      int `json:"shall"`
+	Must       int `json:"must"`
+	MayNot     int `json:"mayNot"`
+	Required   int `json:"required"`
+	Prohibited int `json:"prohibited"`
+	Total      int `json:"total"`
+}
+
+// TitleDetailHandler routes /api/v1/titles/{number}/{resource} requests.
+func TitleDetailHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[HANDLER] TitleDetailHandler called")
+	if r.Method != http.MethodGet {
+		log.Printf("[HANDLER] TitleDetailHandler: Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/titles/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	titleNumber, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid title number", http.StatusBadRequest)
+		return
+	}
+
+	var title models.Title
+	if err := database.DB.Where("number = ?", titleNumber).First(&title).Error; err != nil {
+		http.Error(w, "Title not found", http.StatusNotFound)
+		return
+	}
+
+	switch segments[1] {
+	case "structure":
+		titleStructure(w, r, title)
+	case "diff":
+		titleDiff(w, r, title)
+	case "amendments":
+		titleAmendments(w, r, title)
+	case "metadata":
+		titleMetadataHistory(w, r, title)
+	case "references":
+		titleReferences(w, r, title)
+	case "parts":
+		titleParts(w, r, title)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// titleStructure lists the structure nodes of a title's content version. The
+// latest version is used unless a date=YYYY-MM-DD parameter is given; nodes can
+// be filtered by type=part|section|... and parent=<node id>.
+func titleStructure(w http.ResponseWriter, r *http.Request, title models.Title) {
+	query := database.DB.Where("title_id = ?", title.ID).Order("content_date DESC")
+	if dateStr := r.URL.Query().Get("date"); dateStr != "" {
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("content_date = ?", date)
+	}
+
+	var content models.TitleContent
+	if err := query.Select("id", "title_id", "content_date").First(&content).Error; err != nil {
+		http.Error(w, "No content found for title", http.StatusNotFound)
+		return
+	}
+
+	nodeQuery := database.DB.Where("title_content_id = ?", content.ID)
+	if nodeType := r.URL.Query().Get("type"); nodeType != "" {
+		nodeQuery = nodeQuery.Where("node_type = ?", nodeType)
+	}
+	if parent := r.URL.Query().Get("parent"); parent != "" {
+		parentID, err := uuid.Parse(parent)
+		if err != nil {
+			http.Error(w, "Invalid parent id", http.StatusBadRequest)
+			return
+		}
+		nodeQuery = nodeQuery.Where("parent_id = ?", parentID)
+	}
+
+	var nodes []models.StructureNode
+	if err := nodeQuery.Order("position").Find(&nodes).Error; err != nil {
+		http.Error(w, "Failed to fetch structure", http.StatusInternalServerError)
+		return
+	}
+
+	nodesWithMetrics := make([]StructureNodeWithMetrics, 0, len(nodes))
+	for _, node := range nodes {
+		nodesWithMetrics = append(nodesWithMetrics, StructureNodeWithMetrics{
+			ID:         node.ID,
+			ParentID:   node.ParentID,
+			Type:       node.NodeType,
+			Identifier: node.Identifier,
+			Heading:    node.Heading,
+			Chapter:    node.Chapter,
+			Subchapter: node.Subchapter,
+			Part:       node.Part,
+			WordCount:  node.WordCount,
+			Checksum:   node.Checksum,
+			Restrictions: RestrictionBreakdown{
+				Shall:      node.Restrictions.Shall,
+				Must:       node.Restrictions.Must,
+				MayNot:     node.Restrictions.MayNot,
+				Required:   node.Restrictions.Required,
+				Prohibited: node.Restrictions.Prohibited,
+				Total:      services.RestrictionTotal(node.Restrictions),
+			},
+			Readability: services.ReadabilityOf(node.Readability),
+		})
+	}
+
+	response := APIResponse{
+		Data: nodesWithMetrics,
+		Meta: Meta{
+			Total:       len(nodesWithMetrics),
+			LastUpdated: content.ContentDate,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// titleDiff compares the content versions in effect on from=YYYY-MM-DD and
+// to=YYYY-MM-DD (default today). Word-level diffs are omitted with text=false.
+func titleDiff(w http.ResponseWriter, r *http.Request, title models.Title) {
+	fromStr := r.URL.Query().Get("from")
+	if fromStr == "" {
+		http.Error(w, "from parameter is required", http.StatusBadRequest)
+		return
+	}
+	from, err := time.Parse("2006-01-02", fromStr)
+	if err != nil {
+		http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		to, err = time.Parse("2006-01-02", toStr)
+		if err != nil {
+			http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	includeText := r.URL.Query().Get("text") != "false"
+
+	diff, err := diffService.DiffTitle(r.Context(), title.Number, from, to, includeText)
+	if errors.Is(err, services.ErrContentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[HANDLER] titleDiff: Failed to diff title %d: %v", title.Number, err)
+		http.Error(w, "Failed to compare title versions", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: diff,
+		Meta: Meta{
+			Total:       len(diff.Sections),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// titleAmendments lists the stored amendment events of a title, newest first.
+// since=YYYY-MM-DD and until=YYYY-MM-DD bound the dates and substantive=true
+// drops editorial changes.
+func titleAmendments(w http.ResponseWriter, r *http.Request, title models.Title) {
+	query := database.DB.Where("title_id = ?", title.ID)
+	for param, condition := range map[string]string{"since": "amendment_date >= ?", "until": "amendment_date <= ?"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			http.Error(w, "Invalid "+param+" date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		query = query.Where(condition, date)
+	}
+	if r.URL.Query().Get("substantive") == "true" {
+		query = query.Where("substantive = ?", true)
+	}
+
+	var events []models.AmendmentEvent
+	if err := query.Order("amendment_date DESC, part, identifier").Find(&events).Error; err != nil {
+		log.Printf("[HANDLER] titleAmendments: Failed to fetch amendments for title %d: %v", title.Number, err)
+		http.Error(w, "Failed to fetch amendments", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIResponse{
+		Data: events,
+		Meta: Meta{
+			Total:       len(events),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// TitleMetadataHistory is the recorded metadata history of a title with how
+// often its latest amendment date has moved.
+type TitleMetadataHistory struct {
+	LatestAmendedOn              *time.Time                    `json:"latestAmendedOn,omitempty"`
+	AmendmentCount               int                           `json:"amendmentCount"`
+	AmendmentsLastYear           int                           `json:"amendmentsLastYear"`
+	AverageDaysBetweenAmendments *float64                      `json:"averageDaysBetweenAmendments,omitempty"`
+	Versions                     []models.TitleMetadataVersion `json:"versions"`
+}
+
+// titleMetadataHistory lists the recorded metadata versions of a title, newest
+// first. limit=N (default 100) caps the versions returned.
+func titleMetadataHistory(w http.ResponseWriter, r *http.Request, title models.Title) {
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			http.Error(w, "Invalid limit, expected 1-1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	versions, err := services.TitleMetadataHistory(title.ID, limit)
+	if err != nil {
+		log.Printf("[HANDLER] titleMetadataHistory: title %d: %v", title.Number, err)
+		http.Error(w, "Failed to fetch title metadata history", http.StatusInternalServerError)
+		return
+	}
+	stats, err := services.TitleAmendmentStatsByTitle()
+	if err != nil {
+		log.Printf("[HANDLER] titleMetadataHistory: title %d: %v", title.Number, err)
+		http.Error(w, "Failed to fetch title metadata history", http.StatusInternalServerError)
+		return
+	}
+	amendments := stats[title.ID]
+
+	response := APIResponse{
+		Data: TitleMetadataHistory{
+			LatestAmendedOn:              title.LatestAmendedOn,
+			AmendmentCount:               amendments.AmendmentCount,
+			AmendmentsLastYear:           amendments.AmendmentsLastYear,
+			AverageDaysBetweenAmendments: amendments.AverageDaysBetween,
+			Versions:                     versions,
+		},
+		Meta: Meta{
+			Total:       len(versions),
+			LastUpdated: time.Now(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -17,6 +17,8 @@ type Agency struct {
 	Children  []Agency   `gorm:"foreignKey:ParentID" json:"children,omitempty"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
+	// DeletedAt is set when the agency is no longer published upstream
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 }
 
 type Title struct {
@@ -27,30 +29,283 @@ type Title struct {
 	LatestIssueDate  *time.Time `json:"latest_issue_date,omitempty"`
 	UpToDateAsOf     *time.Time `json:"up_to_date_as_of,omitempty"`
 	Reserved         bool       `gorm:"default:false" json:"reserved"`
+	// ContentAmendedOn is the LatestAmendedOn the stored content was
+	// downloaded against; a refresh downloads titles where it lags behind.
+	ContentAmendedOn *time.Time `json:"content_amended_on,omitempty"`
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
+// TitleMetadataVersion is the metadata of a title as published, recorded by
+// a title import whenever any of it changed. ChangedFields lists the columns
+// that changed, or "created" for a new title.
+type TitleMetadataVersion struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleID         uuid.UUID  `gorm:"type:uuid;not null" json:"title_id"`
+	TitleNumber     int        `gorm:"not null" json:"title_number"`
+	RunID           *uuid.UUID `gorm:"type:uuid" json:"run_id,omitempty"`
+	Name            string     `gorm:"size:500;not null" json:"name"`
+	Reserved        bool       `gorm:"not null" json:"reserved"`
+	LatestAmendedOn *time.Time `json:"latest_amended_on,omitempty"`
+	LatestIssueDate *time.Time `json:"latest_issue_date,omitempty"`
+	UpToDateAsOf    *time.Time `json:"up_to_date_as_of,omitempty"`
+	ChangedFields   string     `gorm:"size:255;not null" json:"changed_fields"`
+	RecordedAt      time.Time  `gorm:"autoCreateTime;not null" json:"recorded_at"`
+}
+
 type AgencyCFRReference struct {
 	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
 	AgencyID uuid.UUID `gorm:"type:uuid;not null" json:"agency_id"`
 	TitleID  uuid.UUID `gorm:"type:uuid;not null" json:"title_id"`
 	Chapter  *string   `gorm:"size:50" json:"chapter,omitempty"`
-	Agency   Agency    `gorm:"foreignKey:AgencyID" json:"agency"`
-	Title    Title     `gorm:"foreignKey:TitleID" json:"title"`
+	// Subchapter and Part narrow the reference below chapter level when the
+	// agency only owns part of a chapter.
+	Subchapter *string `gorm:"size:50" json:"subchapter,omitempty"`
+	Part       *string `gorm:"size:50" json:"part,omitempty"`
+	// DeletedAt is set when the agency no longer lists the reference upstream
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Agency    Agency         `gorm:"foreignKey:AgencyID" json:"agency"`
+	Title     Title          `gorm:"foreignKey:TitleID" json:"title"`
 }
 
 type TitleContent struct {
 	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
 	TitleID     uuid.UUID `gorm:"type:uuid;not null" json:"title_id"`
 	ContentDate time.Time `gorm:"not null" json:"content_date"`
-	XMLContent  string    `gorm:"type:text;not null" json:"xml_content"`
 	WordCount   *int      `json:"word_count,omitempty"`
-	Checksum    *string   `gorm:"size:64" json:"checksum,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	Title       Title     `gorm:"foreignKey:TitleID" json:"title"`
+	// RestrictionCount is the number of obligation and prohibition terms in
+	// the content, counted with its words. It is nil until counted.
+	RestrictionCount *int `json:"restriction_count,omitempty"`
+	// Checksum is the SHA-256 of the XML, which is kept in the blob store
+	// under this key rather than in the database.
+	Checksum  *string `gorm:"size:64" json:"checksum,omitempty"`
+	SizeBytes *int64  `json:"size_bytes,omitempty"`
+	// ETag and LastModified are the bulk repository validators of the
+	// downloaded file, used to skip unchanged files on the next refresh.
+	ETag         *string `gorm:"size:255" json:"etag,omitempty"`
+	LastModified *string `gorm:"size:64" json:"last_modified,omitempty"`
+	// ReferencesExtractedAt is when the citations in the content were last
+	// extracted into section references; nil if they never were.
+	ReferencesExtractedAt *time.Time `json:"references_extracted_at,omitempty"`
+	// NotesExtractedAt is when the authority and source notes of the content
+	// were last extracted into statute and Federal Register citations.
+	NotesExtractedAt *time.Time `json:"notes_extracted_at,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+	Title                 Title      `gorm:"foreignKey:TitleID" json:"title"`
+}
+
+// StructureNode is one DIV element (title, chapter, subchapter, part, subpart,
+// section, ...) of a stored title content version.
+type StructureNode struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleContentID uuid.UUID  `gorm:"type:uuid;not null" json:"title_content_id"`
+	TitleID        uuid.UUID  `gorm:"type:uuid;not null" json:"title_id"`
+	ParentID       *uuid.UUID `gorm:"type:uuid" json:"parent_id,omitempty"`
+	NodeType       string     `gorm:"size:50;not null" json:"node_type"`
+	Level          int        `gorm:"not null" json:"level"`
+	Identifier     string     `gorm:"size:255" json:"identifier"`
+	Heading        string     `gorm:"type:text" json:"heading"`
+	Position       int        `gorm:"not null" json:"position"`
+	Chapter        *string    `gorm:"size:50" json:"chapter,omitempty"`
+	Subchapter     *string    `gorm:"size:50" json:"subchapter,omitempty"`
+	Part           *string    `gorm:"size:50" json:"part,omitempty"`
+	WordCount      int        `gorm:"not null;default:0" json:"word_count"`
+	Checksum       string     `gorm:"size:64" json:"checksum"`
+	// Restrictions and Readability cover the node and all of its
+	// descendants, like WordCount
+	Restrictions RestrictionCounts `gorm:"embedded;embeddedPrefix:restriction_" json:"restrictions"`
+	Readability  ReadabilityCounts `gorm:"embedded;embeddedPrefix:readability_" json:"readability"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// RestrictionCounts counts the obligation and prohibition terms in a piece of
+// regulatory text, as a measure of how restrictive it is.
+type RestrictionCounts struct {
+	Shall      int `gorm:"not null;default:0" json:"shall"`
+	Must       int `gorm:"not null;default:0" json:"must"`
+	MayNot     int `gorm:"not null;default:0" json:"may_not"`
+	Required   int `gorm:"not null;default:0" json:"required"`
+	Prohibited int `gorm:"not null;default:0" json:"prohibited"`
+}
+
+// ReadabilityCounts are the sentence, word and syllable counts readability
+// scores are computed from. Words here are runs of letters, so numbers and
+// citations are not counted. Counts add up, so scores of a title or agency
+// are computed from the sums over its sections.
+type ReadabilityCounts struct {
+	Words        int `gorm:"not null;default:0" json:"words"`
+	Sentences    int `gorm:"not null;default:0" json:"sentences"`
+	Syllables    int `gorm:"not null;default:0" json:"syllables"`
+	ComplexWords int `gorm:"not null;default:0" json:"complex_words"`
+	LongWords    int `gorm:"not null;default:0" json:"long_words"`
+}
+
+// SectionText is the text of one section or appendix of a stored title
+// content version, kept for full-text search. Its search_vector column is
+// generated from the heading and text by the database.
+type SectionText struct {
+	StructureNodeID uuid.UUID `gorm:"type:uuid;primary_key" json:"structure_node_id"`
+	TitleContentID  uuid.UUID `gorm:"type:uuid;not null;index" json:"title_content_id"`
+	TitleID         uuid.UUID `gorm:"type:uuid;not null" json:"title_id"`
+	Heading         string    `gorm:"type:text" json:"heading"`
+	Text            string    `gorm:"type:text" json:"text"`
+}
+
+// SectionReference is a citation in the text of a section or appendix to a
+// CFR section or part or to a section of the United States Code. TargetType
+// is "section", "part" or "usc"; TargetTitle is the CFR or U.S.C. title.
+// Occurrences counts how often the source cites the target.
+type SectionReference struct {
+	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleContentID   uuid.UUID `gorm:"type:uuid;not null;index" json:"title_content_id"`
+	SourceNodeID     uuid.UUID `gorm:"type:uuid;not null;index" json:"source_node_id"`
+	TargetType       string    `gorm:"size:20;not null" json:"target_type"`
+	TargetTitle      int       `gorm:"not null" json:"target_title"`
+	TargetIdentifier string    `gorm:"size:50;not null" json:"target_identifier"`
+	Occurrences      int       `gorm:"not null;default:1" json:"occurrences"`
+}
+
+// StatuteCitation is a statute named in the authority note (AUTH) of a part,
+// subpart or section: a section of the United States Code or a public law.
+// StatuteTitle is the U.S.C. title or the Congress, StatuteSection the U.S.C.
+// section or the law number. Part is the CFR part the note belongs to.
+type StatuteCitation struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleContentID  uuid.UUID `gorm:"type:uuid;not null;index" json:"title_content_id"`
+	StructureNodeID uuid.UUID `gorm:"type:uuid;not null;index" json:"structure_node_id"`
+	TitleID         uuid.UUID `gorm:"type:uuid;not null" json:"title_id"`
+	Part            *string   `gorm:"size:50" json:"part,omitempty"`
+	StatuteType     string    `gorm:"size:20;not null" json:"statute_type"`
+	StatuteTitle    int       `gorm:"not null" json:"statute_title"`
+	StatuteSection  string    `gorm:"size:50;not null" json:"statute_section"`
+}
+
+// FederalRegisterCitation is a Federal Register document named in the source
+// note (SOURCE) of a part or subpart or the source citation (CITA) of a
+// section. Amendment is set for documents cited as amending or redesignating
+// the text rather than as its original source.
+type FederalRegisterCitation struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleContentID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"title_content_id"`
+	StructureNodeID uuid.UUID  `gorm:"type:uuid;not null;index" json:"structure_node_id"`
+	TitleID         uuid.UUID  `gorm:"type:uuid;not null" json:"title_id"`
+	Part            *string    `gorm:"size:50" json:"part,omitempty"`
+	Volume          int        `gorm:"not null" json:"volume"`
+	Page            int        `gorm:"not null" json:"page"`
+	PublishedOn     *time.Time `gorm:"type:date" json:"published_on,omitempty"`
+	Amendment       bool       `gorm:"not null;default:false" json:"amendment"`
+}
+
+// AmendmentEvent is one entry of the versioner versions list of a title: a
+// section or appendix that changed on AmendmentDate.
+type AmendmentEvent struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleID       uuid.UUID  `gorm:"type:uuid;not null" json:"title_id"`
+	AmendmentDate time.Time  `gorm:"type:date;not null" json:"amendment_date"`
+	IssueDate     *time.Time `gorm:"type:date" json:"issue_date,omitempty"`
+	NodeType      string     `gorm:"size:50" json:"node_type"`
+	Identifier    string     `gorm:"size:255" json:"identifier"`
+	Name          string     `gorm:"type:text" json:"name"`
+	Part          *string    `gorm:"size:50" json:"part,omitempty"`
+	Subpart       *string    `gorm:"size:50" json:"subpart,omitempty"`
+	Substantive   bool       `gorm:"not null;default:false" json:"substantive"`
+	Removed       bool       `gorm:"not null;default:false" json:"removed"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Title         Title      `gorm:"foreignKey:TitleID" json:"title"`
+}
+
+// ImportRun is one execution of an import job. Runs left "running" by a
+// server restart are marked "interrupted" on startup. CancelRequested is
+// polled by the process running the import, so a run can be cancelled from
+// any server instance. Replace runs download titles again even if their
+// content version is already stored.
+type ImportRun struct {
+	ID                uuid.UUID       `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	Kind              string          `gorm:"size:50;not null" json:"kind"`
+	Replace           bool            `gorm:"not null;default:false" json:"replace"`
+	Status            string          `gorm:"size:20;not null" json:"status"`
+	StartedAt         time.Time       `gorm:"not null" json:"started_at"`
+	FinishedAt        *time.Time      `json:"finished_at,omitempty"`
+	Error             *string         `gorm:"type:text" json:"error,omitempty"`
+	TitlesTotal       int             `gorm:"not null;default:0" json:"titles_total"`
+	TitlesSucceeded   int             `gorm:"not null;default:0" json:"titles_succeeded"`
+	TitlesFailed      int             `gorm:"not null;default:0" json:"titles_failed"`
+	TitlesSkipped     int             `gorm:"not null;default:0" json:"titles_skipped"`
+	TitlesQuarantined int             `gorm:"not null;default:0" json:"titles_quarantined"`
+	CancelRequested   bool            `gorm:"not null;default:false" json:"cancel_requested"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+	Items             []ImportRunItem `gorm:"foreignKey:RunID" json:"items,omitempty"`
+}
+
+// ImportRunItem is the result of downloading and storing one title during an
+// import run.
+type ImportRunItem struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	RunID       uuid.UUID  `gorm:"type:uuid;not null" json:"run_id"`
+	TitleID     uuid.UUID  `gorm:"type:uuid;not null" json:"title_id"`
+	TitleNumber int        `gorm:"not null" json:"title_number"`
+	Strategy    *string    `gorm:"size:50" json:"strategy,omitempty"`
+	Bytes       int64      `gorm:"not null;default:0" json:"bytes"`
+	Outcome     string     `gorm:"size:20;not null" json:"outcome"`
+	Error       *string    `gorm:"type:text" json:"error,omitempty"`
+	SkipReason  *string    `gorm:"type:text" json:"skip_reason,omitempty"`
+	StartedAt   time.Time  `gorm:"not null" json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// AgencyChange records one change an agency sync made to the agency
+// hierarchy: an agency created, updated, moved, deleted or restored, or a CFR
+// reference added or removed. Field, OldValue and NewValue describe the change
+// where it has one.
+type AgencyChange struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	RunID     *uuid.UUID `gorm:"type:uuid;index" json:"run_id,omitempty"`
+	AgencyID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"agency_id"`
+	Slug      string     `gorm:"size:255;not null" json:"slug"`
+	Action    string     `gorm:"size:30;not null" json:"action"`
+	Field     *string    `gorm:"size:50" json:"field,omitempty"`
+	OldValue  *string    `gorm:"type:text" json:"old_value,omitempty"`
+	NewValue  *string    `gorm:"type:text" json:"new_value,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+}
+
+// QuarantinedContent is downloaded title XML that failed validation. It is
+// held for review instead of replacing the stored content; the XML stays in
+// the blob store under Checksum.
+type QuarantinedContent struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	TitleID           uuid.UUID  `gorm:"type:uuid;not null;index" json:"title_id"`
+	TitleNumber       int        `gorm:"not null" json:"title_number"`
+	ContentDate       time.Time  `gorm:"not null" json:"content_date"`
+	Checksum          string     `gorm:"size:64;not null" json:"checksum"`
+	SizeBytes         int64      `gorm:"not null" json:"size_bytes"`
+	WordCount         int        `gorm:"not null" json:"word_count"`
+	WellFormed        bool       `gorm:"not null" json:"well_formed"`
+	Strategy          *string    `gorm:"size:50" json:"strategy,omitempty"`
+	ETag              *string    `gorm:"size:255" json:"etag,omitempty"`
+	LastModified      *string    `gorm:"size:64" json:"last_modified,omitempty"`
+	Reason            string     `gorm:"type:text;not null" json:"reason"`
+	PreviousContentID *uuid.UUID `gorm:"type:uuid" json:"previous_content_id,omitempty"`
+	Status            string     `gorm:"size:20;not null;index" json:"status"`
+	ReviewedAt        *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
 }
 
+// ScheduledJobRun is one execution of a scheduled job.
+type ScheduledJobRun struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	JobName      string     `gorm:"size:100;not null" json:"job_name"`
+	Status       string     `gorm:"size:20;not null" json:"status"`
+	ScheduledFor time.Time  `gorm:"not null" json:"scheduled_for"`
+	StartedAt    time.Time  `gorm:"not null" json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Error        *string    `gorm:"type:text" json:"error,omitempty"`
+}
+
+// HistoricalSnapshot is a word count on a date: of one title when only
+// TitleID is set, of one agency when only AgencyID is, of an agency's share of
+// one title when both are and of the whole CFR when neither is.
 type HistoricalSnapshot struct {
 	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
 	SnapshotDate time.Time  `gorm:"not null" json:"snapshot_date"`
@@ -58,9 +313,16 @@ type HistoricalSnapshot struct {
 	TitleID      *uuid.UUID `gorm:"type:uuid" json:"title_id,omitempty"`
 	WordCount    *int       `json:"word_count,omitempty"`
 	Checksum     *string    `gorm:"size:64" json:"checksum,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
-	Agency       *Agency    `gorm:"foreignKey:AgencyID" json:"agency,omitempty"`
-	Title        *Title     `gorm:"foreignKey:TitleID" json:"title,omitempty"`
+	// RestrictionCount is the number of obligation and prohibition terms, or
+	// nil where the measurement did not count them.
+	RestrictionCount *int `json:"restriction_count,omitempty"`
+	// MeasurementMethod records how WordCount was obtained: "full_text" for
+	// words counted in the title XML, "structure_estimate" for size/5
+	// estimates from the structure API.
+	MeasurementMethod *string   `gorm:"size:30" json:"measurement_method,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	Agency            *Agency   `gorm:"foreignKey:AgencyID" json:"agency,omitempty"`
+	Title             *Title    `gorm:"foreignKey:TitleID" json:"title,omitempty"`
 }
 
 type AgencyChecksum struct {
@@ -85,6 +347,13 @@ func (title *Title) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (version *TitleMetadataVersion) BeforeCreate(tx *gorm.DB) error {
+	if version.ID == uuid.Nil {
+		version.ID = uuid.New()
+	}
+	return nil
+}
+
 func (ref *AgencyCFRReference) BeforeCreate(tx *gorm.DB) error {
 	if ref.ID == uuid.Nil {
 		ref.ID = uuid.New()
@@ -99,9 +368,79 @@ func (content *TitleContent) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (node *StructureNode) BeforeCreate(tx *gorm.DB) error {
+	if node.ID == uuid.Nil {
+		node.ID = uuid.New()
+	}
+	return nil
+}
+
+func (reference *SectionReference) BeforeCreate(tx *gorm.DB) error {
+	if reference.ID == uuid.Nil {
+		reference.ID = uuid.New()
+	}
+	return nil
+}
+
+func (citation *StatuteCitation) BeforeCreate(tx *gorm.DB) error {
+	if citation.ID == uuid.Nil {
+		citation.ID = uuid.New()
+	}
+	return nil
+}
+
+func (citation *FederalRegisterCitation) BeforeCreate(tx *gorm.DB) error {
+	if citation.ID == uuid.Nil {
+		citation.ID = uuid.New()
+	}
+	return nil
+}
+
+func (event *AmendmentEvent) BeforeCreate(tx *gorm.DB) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	return nil
+}
+
+func (run *ImportRun) BeforeCreate(tx *gorm.DB) error {
+	if run.ID == uuid.Nil {
+		run.ID = uuid.New()
+	}
+	return nil
+}
+
+func (item *ImportRunItem) BeforeCreate(tx *gorm.DB) error {
+	if item.ID == uuid.Nil {
+		item.ID = uuid.New()
+	}
+	return nil
+}
+
+func (change *AgencyChange) BeforeCreate(tx *gorm.DB) error {
+	if change.ID == uuid.Nil {
+		change.ID = uuid.New()
+	}
+	return nil
+}
+
+func (entry *QuarantinedContent) BeforeCreate(tx *gorm.DB) error {
+	if entry.ID == uuid.Nil {
+		entry.ID = uuid.New()
+	}
+	return nil
+}
+
+func (jobRun *ScheduledJobRun) BeforeCreate(tx *gorm.DB) error {
+	if jobRun.ID == uuid.Nil {
+		jobRun.ID = uuid.New()
+	}
+	return nil
+}
+
 func (snapshot *HistoricalSnapshot) BeforeCreate(tx *gorm.DB) error {
 	if snapshot.ID == uuid.Nil {
 		snapshot.ID = uuid.New()
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultJitter = 2 * time.Minute
+
+// Config controls the scheduler as a whole. Schedules of individual jobs are
+// read with SpecFromEnv.
+type Config struct {
+	Enabled bool
+	Jitter  time.Duration
+}
+
+// ConfigFromEnv reads SCHEDULER_ENABLED (default true) and SCHEDULER_JITTER
+// (a Go duration, default 2m).
+func ConfigFromEnv() Config {
+	config := Config{Enabled: true, Jitter: defaultJitter}
+
+	if value := os.Getenv("SCHEDULER_ENABLED"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("[SCHEDULER] Invalid SCHEDULER_ENABLED %q, keeping the scheduler enabled", value)
+		} else {
+			config.Enabled = enabled
+		}
+	}
+	if value := os.Getenv("SCHEDULER_JITTER"); value != "" {
+		jitter, err := time.ParseDuration(value)
+		if err != nil || jitter < 0 {
+			log.Printf("[SCHEDULER] Invalid SCHEDULER_JITTER %q, using %s", value, defaultJitter)
+		} else {
+			config.Jitter = jitter
+		}
+	}
+	return config
+}
+
+// SpecFromEnv returns the cron expression in envVar, or fallback when it is
+// unset. "off" disables the job and yields an empty string.
+func SpecFromEnv(envVar, fallback string) string {
+	value := strings.TrimSpace(os.Getenv(envVar))
+	if value == "" {
+		return fallback
+	}
+	if strings.EqualFold(value, "off") {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,256 @@
+// Package scheduler runs background jobs on cron schedules. Each job runs in
+// its own loop, so a job never overlaps itself within a process, and takes a
+// Postgres advisory lock so it does not overlap runs in other processes. Every
+// run is recorded in scheduled_job_runs.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+)
+
+// Job run statuses
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+// ErrSkipped is returned (possibly wrapped) by jobs that did not need to or
+// could not start their work, e.g. because an import is already running.
+var ErrSkipped = errors.New("job skipped")
+
+// Job is a named unit of work run on a cron schedule.
+type Job struct {
+	Name        string
+	Description string
+	Spec        string
+	Run         func(ctx context.Context) error
+}
+
+type entry struct {
+	job      Job
+	schedule *Schedule
+	nextRun  time.Time
+	running  bool
+}
+
+// EntryStatus describes a scheduled job for the API.
+type EntryStatus struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Schedule    string                  `json:"schedule"`
+	NextRun     *time.Time              `json:"nextRun,omitempty"`
+	Running     bool                    `json:"running"`
+	LastRun     *models.ScheduledJobRun `json:"lastRun,omitempty"`
+}
+
+type Scheduler struct {
+	jitter  time.Duration
+	entries []*entry
+	mutex   sync.Mutex
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New creates a scheduler that delays every run by a random duration of up to
+// jitter, so several instances do not hit the upstream APIs at the same moment.
+func New(jitter time.Duration) *Scheduler {
+	return &Scheduler{jitter: jitter}
+}
+
+// Add registers a job. It must be called before Start.
+func (s *Scheduler) Add(job Job) error {
+	schedule, err := ParseSchedule(job.Spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, existing := range s.entries {
+		if existing.job.Name == job.Name {
+			return fmt.Errorf("job %s is already registered", job.Name)
+		}
+	}
+	s.entries = append(s.entries, &entry{job: job, schedule: schedule})
+	return nil
+}
+
+// Start runs every registered job on its schedule until Stop is called.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, e := range s.entries {
+		log.Printf("[SCHEDULER] Scheduling %s: %s", e.job.Name, e.job.Spec)
+		s.wg.Add(1)
+		go func(e *entry) {
+			defer s.wg.Done()
+			s.loop(ctx, e)
+		}(e)
+	}
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	for {
+		scheduled := e.schedule.Next(time.Now().UTC())
+		if scheduled.IsZero() {
+			log.Printf("[SCHEDULER] %s has no future run time, stopping", e.job.Name)
+			return
+		}
+
+		runAt := scheduled.Add(s.randomJitter())
+		s.mutex.Lock()
+		e.nextRun = runAt
+		s.mutex.Unlock()
+
+		timer := time.NewTimer(time.Until(runAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(ctx, e, scheduled)
+	}
+}
+
+func (s *Scheduler) randomJitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return rand.N(s.jitter)
+}
+
+// run executes one scheduled run of a job and records it.
+func (s *Scheduler) run(ctx context.Context, e *entry, scheduled time.Time) {
+	record := &models.ScheduledJobRun{
+		JobName:      e.job.Name,
+		Status:       RunRunning,
+		ScheduledFor: scheduled,
+		StartedAt:    time.Now().UTC(),
+	}
+
+	lock, err := database.TryAdvisoryLock(ctx, lockKey(e.job.Name))
+	if err != nil {
+		log.Printf("[SCHEDULER] %s: failed to take job lock: %v", e.job.Name, err)
+		s.finish(record, err)
+		return
+	}
+	if lock == nil {
+		log.Printf("[SCHEDULER] %s is already running in another process, skipping", e.job.Name)
+		s.finish(record, fmt.Errorf("%w: already running in another process", ErrSkipped))
+		return
+	}
+	defer lock.Release()
+
+	if err := database.DB.Create(record).Error; err != nil {
+		log.Printf("[SCHEDULER] %s: failed to record run: %v", e.job.Name, err)
+	}
+
+	s.setRunning(e, true)
+	defer s.setRunning(e, false)
+
+	log.Printf("[SCHEDULER] Running %s (scheduled for %s)", e.job.Name, scheduled.Format(time.RFC3339))
+	err = e.job.Run(ctx)
+	s.finish(record, err)
+}
+
+// finish stores the outcome of a run, creating the record if it was never
+// stored because the run did not start.
+func (s *Scheduler) finish(record *models.ScheduledJobRun, runErr error) {
+	finishedAt := time.Now().UTC()
+	record.FinishedAt = &finishedAt
+	record.Status = RunSucceeded
+	if runErr != nil {
+		message := runErr.Error()
+		record.Error = &message
+		record.Status = RunFailed
+		if errors.Is(runErr, ErrSkipped) {
+			record.Status = RunSkipped
+		}
+	}
+
+	log.Printf("[SCHEDULER] %s finished: %s", record.JobName, record.Status)
+	if err := database.DB.Save(record).Error; err != nil {
+		log.Printf("[SCHEDULER] %s: failed to record run result: %v", record.JobName, err)
+	}
+}
+
+func (s *Scheduler) setRunning(e *entry, running bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e.running = running
+}
+
+// Status lists the registered jobs with their next run time in this process
+// and their most recent recorded run in any process.
+func (s *Scheduler) Status() ([]EntryStatus, error) {
+	s.mutex.Lock()
+	statuses := make([]EntryStatus, 0, len(s.entries))
+	for _, e := range s.entries {
+		status := EntryStatus{
+			Name:        e.job.Name,
+			Description: e.job.Description,
+			Schedule:    e.job.Spec,
+			Running:     e.running,
+		}
+		if !e.nextRun.IsZero() {
+			nextRun := e.nextRun
+			status.NextRun = &nextRun
+		}
+		statuses = append(statuses, status)
+	}
+	s.mutex.Unlock()
+
+	for i := range statuses {
+		runs, err := History(statuses[i].Name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			statuses[i].LastRun = &runs[0]
+		}
+	}
+	return statuses, nil
+}
+
+// History returns the most recent runs of a job, newest first.
+func History(jobName string, limit int) ([]models.ScheduledJobRun, error) {
+	var runs []models.ScheduledJobRun
+	err := database.DB.Where("job_name = ?", jobName).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&runs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch runs of %s: %w", jobName, err)
+	}
+	return runs, nil
+}
+
+// lockKey derives the advisory lock key of a job from its name.
+func lockKey(jobName string) int64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(jobName))
+	return database.SchedulerLockBase<<32 | int64(hash.Sum32())
+}
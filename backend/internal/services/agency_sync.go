@@ -0,0 +1,398 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Agency change actions recorded in the agency_changes audit log
+const (
+	AgencyChangeCreated          = "created"
+	AgencyChangeUpdated          = "updated"
+	AgencyChangeMoved            = "moved"
+	AgencyChangeDeleted          = "deleted"
+	AgencyChangeRestored         = "restored"
+	AgencyChangeReferenceAdded   = "reference_added"
+	AgencyChangeReferenceRemoved = "reference_removed"
+)
+
+// AgencySyncStats counts what an agency sync changed.
+type AgencySyncStats struct {
+	Agencies          int
+	Created           int
+	Updated           int
+	Moved             int
+	Deleted           int
+	Restored          int
+	References        int
+	ReferencesAdded   int
+	ReferencesRemoved int
+}
+
+// publishedAgency is an agency of the upstream hierarchy with the slug of its
+// parent, which is empty for top-level agencies.
+type publishedAgency struct {
+	data       AgencyData
+	parentSlug string
+}
+
+// referenceKey identifies a CFR reference of an agency.
+type referenceKey struct {
+	titleNumber int
+	chapter     string
+	subchapter  string
+	part        string
+}
+
+func (k referenceKey) String() string {
+	description := fmt.Sprintf("title %d", k.titleNumber)
+	if k.chapter != "" {
+		description += " chapter " + k.chapter
+	}
+	if k.subchapter != "" {
+		description += " subchapter " + k.subchapter
+	}
+	if k.part != "" {
+		description += " part " + k.part
+	}
+	return description
+}
+
+// flattenAgencies lists the agencies of the upstream hierarchy, at any depth,
+// with every parent before its children. A slug listed twice keeps its first
+// position.
+func flattenAgencies(agencies []AgencyData) []publishedAgency {
+	var flat []publishedAgency
+	seen := make(map[string]bool)
+
+	var walk func(agencies []AgencyData, parentSlug string)
+	walk = func(agencies []AgencyData, parentSlug string) {
+		for _, agency := range agencies {
+			if agency.Slug == "" {
+				log.Printf("[AGENCY_SYNC] Ignoring agency %q without a slug", agency.Name)
+				continue
+			}
+			if seen[agency.Slug] {
+				log.Printf("[AGENCY_SYNC] Agency %s is listed more than once, keeping its first position", agency.Slug)
+			} else {
+				seen[agency.Slug] = true
+				flat = append(flat, publishedAgency{data: agency, parentSlug: parentSlug})
+			}
+			walk(agency.Children, agency.Slug)
+		}
+	}
+	walk(agencies, "")
+	return flat
+}
+
+// agencySync reconciles the stored agency hierarchy with the published one in
+// a single transaction, logging every change it makes.
+type agencySync struct {
+	tx      *gorm.DB
+	runID   *uuid.UUID
+	stats   AgencySyncStats
+	changes []models.AgencyChange
+}
+
+// syncAgencies makes the stored agencies and CFR references match the
+// published hierarchy: new agencies are created, changed names and parents
+// updated, agencies and references no longer published soft-deleted, and
+// republished ones restored. References to titles not imported yet are left
+// for a later sync.
+func syncAgencies(db *gorm.DB, runID *uuid.UUID, published []AgencyData) (*AgencySyncStats, error) {
+	flat := flattenAgencies(published)
+	if len(flat) == 0 {
+		// An empty list is far more likely a bad response than every agency
+		// being abolished
+		return nil, errors.New("upstream returned no agencies, refusing to delete them all")
+	}
+
+	sync := &agencySync{runID: runID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		sync.tx = tx
+		agencies, err := sync.syncHierarchy(flat)
+		if err != nil {
+			return err
+		}
+		if err := sync.syncReferences(flat, agencies); err != nil {
+			return err
+		}
+		if len(sync.changes) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(sync.changes, 500).Error; err != nil {
+			return fmt.Errorf("failed to write agency audit log: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sync.stats, nil
+}
+
+// syncHierarchy reconciles the agencies themselves and returns the stored
+// agency of every published slug.
+func (s *agencySync) syncHierarchy(flat []publishedAgency) (map[string]*models.Agency, error) {
+	var stored []models.Agency
+	if err := s.tx.Unscoped().Find(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to load agencies: %w", err)
+	}
+	storedBySlug := make(map[string]*models.Agency, len(stored))
+	slugByID := make(map[uuid.UUID]string, len(stored))
+	for i := range stored {
+		storedBySlug[stored[i].Slug] = &stored[i]
+		slugByID[stored[i].ID] = stored[i].Slug
+	}
+
+	agencies := make(map[string]*models.Agency, len(flat))
+	for _, published := range flat {
+		var parentID *uuid.UUID
+		if published.parentSlug != "" {
+			parentID = &agencies[published.parentSlug].ID
+		}
+
+		agency, exists := storedBySlug[published.data.Slug]
+		if !exists {
+			shortName := published.data.ShortName
+			agency = &models.Agency{
+				Name:      published.data.Name,
+				ShortName: &shortName,
+				Slug:      published.data.Slug,
+				ParentID:  parentID,
+			}
+			if err := s.tx.Create(agency).Error; err != nil {
+				return nil, fmt.Errorf("failed to create agency %s: %w", agency.Slug, err)
+			}
+			slugByID[agency.ID] = agency.Slug
+			s.stats.Created++
+			s.record(agency, AgencyChangeCreated, "", "", agency.Name)
+		} else if err := s.updateAgency(agency, published.data, parentID, slugByID); err != nil {
+			return nil, err
+		}
+		agencies[agency.Slug] = agency
+	}
+	s.stats.Agencies = len(agencies)
+
+	for i := range stored {
+		agency := &stored[i]
+		if _, published := agencies[agency.Slug]; published || agency.DeletedAt.Valid {
+			continue
+		}
+		if err := s.tx.Delete(agency).Error; err != nil {
+			return nil, fmt.Errorf("failed to delete agency %s: %w", agency.Slug, err)
+		}
+		s.stats.Deleted++
+		s.record(agency, AgencyChangeDeleted, "", agency.Name, "")
+	}
+	return agencies, nil
+}
+
+// updateAgency brings a stored agency in line with its published version.
+func (s *agencySync) updateAgency(agency *models.Agency, published AgencyData, parentID *uuid.UUID, slugByID map[uuid.UUID]string) error {
+	updates := make(map[string]interface{})
+
+	if agency.DeletedAt.Valid {
+		updates["deleted_at"] = nil
+		agency.DeletedAt = gorm.DeletedAt{}
+		s.stats.Restored++
+		s.record(agency, AgencyChangeRestored, "", "", published.Name)
+	}
+
+	changed := false
+	if agency.Name != published.Name {
+		s.record(agency, AgencyChangeUpdated, "name", agency.Name, published.Name)
+		updates["name"] = published.Name
+		agency.Name = published.Name
+		changed = true
+	}
+	storedShortName := ""
+	if agency.ShortName != nil {
+		storedShortName = *agency.ShortName
+	}
+	if storedShortName != published.ShortName {
+		s.record(agency, AgencyChangeUpdated, "short_name", storedShortName, published.ShortName)
+		shortName := published.ShortName
+		updates["short_name"] = shortName
+		agency.ShortName = &shortName
+		changed = true
+	}
+	if changed {
+		s.stats.Updated++
+	}
+
+	if !sameParent(agency.ParentID, parentID) {
+		s.record(agency, AgencyChangeMoved, "parent", parentSlug(agency.ParentID, slugByID), parentSlug(parentID, slugByID))
+		updates["parent_id"] = parentID
+		agency.ParentID = parentID
+		s.stats.Moved++
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := s.tx.Unscoped().Model(agency).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update agency %s: %w", agency.Slug, err)
+	}
+	return nil
+}
+
+// syncReferences reconciles the CFR references of every published agency.
+// References of deleted agencies are deleted with them.
+func (s *agencySync) syncReferences(flat []publishedAgency, agencies map[string]*models.Agency) error {
+	var titles []models.Title
+	if err := s.tx.Select("id", "number").Find(&titles).Error; err != nil {
+		return fmt.Errorf("failed to load titles: %w", err)
+	}
+	titleIDs := make(map[int]uuid.UUID, len(titles))
+	titleNumbers := make(map[uuid.UUID]int, len(titles))
+	for _, title := range titles {
+		titleIDs[title.Number] = title.ID
+		titleNumbers[title.ID] = title.Number
+	}
+
+	published := make(map[uuid.UUID]map[referenceKey]bool, len(flat))
+	for _, agency := range flat {
+		published[agencies[agency.data.Slug].ID] = publishedReferences(agency.data, titleIDs)
+	}
+
+	var stored []models.AgencyCFRReference
+	if err := s.tx.Unscoped().Find(&stored).Error; err != nil {
+		return fmt.Errorf("failed to load CFR references: %w", err)
+	}
+	agencyByID := make(map[uuid.UUID]*models.Agency)
+	for _, agency := range agencies {
+		agencyByID[agency.ID] = agency
+	}
+	var deletedAgencies []models.Agency
+	if err := s.tx.Unscoped().Where("deleted_at IS NOT NULL").Find(&deletedAgencies).Error; err != nil {
+		return fmt.Errorf("failed to load deleted agencies: %w", err)
+	}
+	for i := range deletedAgencies {
+		agencyByID[deletedAgencies[i].ID] = &deletedAgencies[i]
+	}
+
+	present := make(map[uuid.UUID]map[referenceKey]bool)
+	for i := range stored {
+		ref := &stored[i]
+		key := referenceKey{
+			titleNumber: titleNumbers[ref.TitleID],
+			chapter:     valueOrEmpty(ref.Chapter),
+			subchapter:  valueOrEmpty(ref.Subchapter),
+			part:        valueOrEmpty(ref.Part),
+		}
+		agency := agencyByID[ref.AgencyID]
+		if agency == nil {
+			continue
+		}
+
+		wanted := published[ref.AgencyID][key]
+		if wanted && present[ref.AgencyID][key] {
+			// A duplicate of a reference already kept
+			wanted = false
+		}
+		switch {
+		case wanted && ref.DeletedAt.Valid:
+			if err := s.tx.Unscoped().Model(ref).Update("deleted_at", nil).Error; err != nil {
+				return fmt.Errorf("failed to restore CFR reference of %s: %w", agency.Slug, err)
+			}
+			s.stats.ReferencesAdded++
+			s.record(agency, AgencyChangeReferenceAdded, "cfr_reference", "", key.String())
+		case !wanted && !ref.DeletedAt.Valid:
+			if err := s.tx.Delete(ref).Error; err != nil {
+				return fmt.Errorf("failed to delete CFR reference of %s: %w", agency.Slug, err)
+			}
+			s.stats.ReferencesRemoved++
+			s.record(agency, AgencyChangeReferenceRemoved, "cfr_reference", key.String(), "")
+		}
+		if wanted {
+			if present[ref.AgencyID] == nil {
+				present[ref.AgencyID] = make(map[referenceKey]bool)
+			}
+			present[ref.AgencyID][key] = true
+		}
+	}
+
+	for _, published := range flat {
+		agency := agencies[published.data.Slug]
+		for key := range publishedReferences(published.data, titleIDs) {
+			if present[agency.ID][key] {
+				continue
+			}
+			ref := &models.AgencyCFRReference{
+				AgencyID:   agency.ID,
+				TitleID:    titleIDs[key.titleNumber],
+				Chapter:    optionalString(key.chapter),
+				Subchapter: optionalString(key.subchapter),
+				Part:       optionalString(key.part),
+			}
+			if err := s.tx.Create(ref).Error; err != nil {
+				return fmt.Errorf("failed to create CFR reference of %s: %w", agency.Slug, err)
+			}
+			if present[agency.ID] == nil {
+				present[agency.ID] = make(map[referenceKey]bool)
+			}
+			present[agency.ID][key] = true
+			s.stats.ReferencesAdded++
+			s.record(agency, AgencyChangeReferenceAdded, "cfr_reference", "", key.String())
+		}
+		s.stats.References += len(present[agency.ID])
+	}
+	return nil
+}
+
+// publishedReferences returns an agency's published references, leaving out
+// titles that are not imported.
+func publishedReferences(agency AgencyData, titleIDs map[int]uuid.UUID) map[referenceKey]bool {
+	keys := make(map[referenceKey]bool)
+	for _, ref := range agency.CFRReferences {
+		if _, known := titleIDs[ref.Title]; known {
+			keys[referenceKey{titleNumber: ref.Title, chapter: ref.Chapter, subchapter: ref.Subchapter, part: ref.Part}] = true
+		}
+	}
+	return keys
+}
+
+// record adds an entry to the audit log, which is written at the end of the
+// sync's transaction so it only persists if the whole sync does.
+func (s *agencySync) record(agency *models.Agency, action, field, oldValue, newValue string) {
+	s.changes = append(s.changes, models.AgencyChange{
+		RunID:    s.runID,
+		AgencyID: agency.ID,
+		Slug:     agency.Slug,
+		Action:   action,
+		Field:    optionalString(field),
+		OldValue: optionalString(oldValue),
+		NewValue: optionalString(newValue),
+	})
+}
+
+func sameParent(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func parentSlug(parentID *uuid.UUID, slugByID map[uuid.UUID]string) string {
+	if parentID == nil {
+		return ""
+	}
+	if slug, ok := slugByID[*parentID]; ok {
+		return slug
+	}
+	return parentID.String()
+}
+
+func valueOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
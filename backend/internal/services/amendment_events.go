@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const amendmentBatchSize = 500
+
+// SyncAmendmentEvents replaces the stored amendment events of each title with
+// the current versioner versions list. Titles whose list cannot be fetched
+// keep their previous events.
+func (h *HistoricalService) SyncAmendmentEvents(ctx context.Context, titles []models.Title) error {
+	synced := 0
+	for _, title := range titles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		versions, err := h.client.FetchTitleVersions(ctx, title.Number)
+		if err != nil {
+			log.Printf("[HISTORICAL] Failed to fetch versions for title %d: %v", title.Number, err)
+			continue
+		}
+
+		count, err := storeAmendmentEvents(title.ID, versions.ContentVersions)
+		if err != nil {
+			log.Printf("[HISTORICAL] Failed to store amendment events for title %d: %v", title.Number, err)
+			continue
+		}
+		log.Printf("[HISTORICAL] Stored %d amendment events for title %d", count, title.Number)
+		synced++
+	}
+
+	if synced == 0 && len(titles) > 0 {
+		return fmt.Errorf("failed to sync amendment events for any of %d titles", len(titles))
+	}
+	return nil
+}
+
+func storeAmendmentEvents(titleID uuid.UUID, versions []TitleVersion) (int, error) {
+	events := make([]models.AmendmentEvent, 0, len(versions))
+	for _, version := range versions {
+		amendmentDate, err := time.Parse("2006-01-02", firstNonEmpty(version.AmendmentDate, version.Date))
+		if err != nil {
+			continue
+		}
+		event := models.AmendmentEvent{
+			TitleID:       titleID,
+			AmendmentDate: amendmentDate,
+			NodeType:      version.Type,
+			Identifier:    normalizeIdentifier(version.Identifier),
+			Name:          version.Name,
+			Part:          optionalString(version.Part),
+			Subpart:       version.Subpart,
+			Substantive:   version.Substantive,
+			Removed:       version.Removed,
+		}
+		if issueDate, err := time.Parse("2006-01-02", version.IssueDate); err == nil {
+			event.IssueDate = &issueDate
+		}
+		events = append(events, event)
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("title_id = ?", titleID).Delete(&models.AmendmentEvent{}).Error; err != nil {
+			return fmt.Errorf("failed to clear amendment events: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(events, amendmentBatchSize).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// amendmentDate is a date on which one or more titles changed.
+type amendmentDate struct {
+	Date     time.Time
+	TitleIDs []uuid.UUID
+}
+
+// amendmentTimeline lists the dates between since and until on which any
+// stored title was amended, oldest first, with the titles amended on each.
+func amendmentTimeline(since, until time.Time) ([]amendmentDate, error) {
+	var rows []struct {
+		AmendmentDate time.Time
+		TitleID       uuid.UUID
+	}
+	err := database.DB.Model(&models.AmendmentEvent{}).
+		Distinct("amendment_date", "title_id").
+		Where("amendment_date >= ? AND amendment_date <= ?", since, until).
+		Order("amendment_date ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var timeline []amendmentDate
+	for _, row := range rows {
+		if len(timeline) == 0 || !timeline[len(timeline)-1].Date.Equal(row.AmendmentDate) {
+			timeline = append(timeline, amendmentDate{Date: row.AmendmentDate})
+		}
+		last := &timeline[len(timeline)-1]
+		last.TitleIDs = append(last.TitleIDs, row.TitleID)
+	}
+	return timeline, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// The functions below compute metrics from exactly one content version per
+// title: the version in effect on asOf, or the latest version when asOf is
+// nil. They are built on the *_as_of database functions. Where no content
+// version was in effect on asOf, word counts and title checksums come from the
+// newest historical snapshot dated on or before it, and Source says which.
+
+// Sources of point-in-time metrics
+const (
+	MetricsSourceContent  = "content"
+	MetricsSourceSnapshot = "snapshot"
+)
+
+// AgencyMetrics is the word and restriction count of the content attributed
+// to an agency and the number of titles it references. Source is nil for
+// agencies without any content or snapshot, and "snapshot" if any title was
+// counted from one; RestrictionCount is nil where restriction terms were not
+// counted.
+type AgencyMetrics struct {
+	ID               uuid.UUID
+	Name             string
+	Slug             string
+	ParentID         *uuid.UUID
+	WordCount        int64
+	RestrictionCount *int64
+	TitleCount       int64
+	Source           *string
+}
+
+// AgencyTotals is the word and restriction count attributed to one agency and
+// where they came from.
+type AgencyTotals struct {
+	WordCount        int64
+	RestrictionCount *int64
+	Source           *string
+}
+
+// TitleVersionMetrics is the content version of a title in effect on a date,
+// or the historical snapshot standing in for it. TitleContentID is nil for
+// snapshots.
+type TitleVersionMetrics struct {
+	TitleID          uuid.UUID
+	TitleContentID   *uuid.UUID
+	EffectiveDate    time.Time
+	WordCount        *int64
+	RestrictionCount *int64
+	Checksum         *string
+	RecordedAt       time.Time
+	Source           string
+}
+
+// TitleWordCount is the part of an agency's word and restriction count found
+// in one title.
+type TitleWordCount struct {
+	TitleNumber      int
+	TitleName        string
+	WordCount        int64
+	RestrictionCount *int64
+}
+
+// AttributedChecksum is the checksum of one title, chapter, subchapter or part
+// attributed to an agency.
+type AttributedChecksum struct {
+	AgencyID        uuid.UUID
+	TitleNumber     int
+	ScopeType       string
+	ScopeIdentifier *string
+	Checksum        string
+}
+
+func asOfArg(asOf *time.Time) sql.NamedArg {
+	if asOf == nil {
+		return sql.Named("as_of", nil)
+	}
+	return sql.Named("as_of", asOf.Format("2006-01-02"))
+}
+
+// TotalWordCountAsOf sums the word counts of every title, each from its content
+// or snapshot like AgencyMetricsAsOf, falling back to the overall snapshot
+// when no title has content or a snapshot on asOf.
+func TotalWordCountAsOf(asOf *time.Time) (int64, error) {
+	var total int64
+	err := database.DB.Raw(`
+		SELECT COALESCE(
+			(SELECT SUM(tm.word_count) FROM title_metrics_as_of(CAST(@as_of AS date)) tm),
+			(SELECT hs.word_count FROM historical_snapshots hs
+				WHERE CAST(@as_of AS date) IS NOT NULL
+					AND hs.agency_id IS NULL AND hs.title_id IS NULL
+					AND hs.snapshot_date::date <= CAST(@as_of AS date)
+				ORDER BY hs.snapshot_date DESC
+				LIMIT 1),
+			0
+		)
+	`, asOfArg(asOf)).Scan(&total).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum word counts: %w", err)
+	}
+	return total, nil
+}
+
+// TitleVersionsAsOf returns the content version or snapshot of every title
+// that has one, keyed by title.
+func TitleVersionsAsOf(asOf *time.Time) (map[uuid.UUID]TitleVersionMetrics, error) {
+	var versions []TitleVersionMetrics
+	err := database.DB.Raw(`
+		SELECT title_id, title_content_id, effective_date, word_count, restriction_count, checksum, recorded_at, source
+		FROM title_metrics_as_of(CAST(@as_of AS date))
+	`, asOfArg(asOf)).Scan(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch title content versions: %w", err)
+	}
+
+	byTitle := make(map[uuid.UUID]TitleVersionMetrics, len(versions))
+	for _, version := range versions {
+		byTitle[version.TitleID] = version
+	}
+	return byTitle, nil
+}
+
+// AgencyMetricsAsOf returns the metrics of every agency, largest first, or
+// only of the sub-agencies of parentID when it is not nil.
+func AgencyMetricsAsOf(asOf *time.Time, parentID *uuid.UUID) ([]AgencyMetrics, error) {
+	filter := "a.deleted_at IS NULL"
+	if parentID != nil {
+		filter += " AND a.parent_id = @parent"
+	}
+	var metrics []AgencyMetrics
+	err := database.DB.Raw(`
+		SELECT
+			a.id,
+			a.name,
+			a.slug,
+			a.parent_id,
+			awc.word_count,
+			awc.restriction_count,
+			awc.source,
+			(SELECT COUNT(DISTINCT acr.title_id) FROM agency_cfr_references acr WHERE acr.agency_id = a.id AND acr.deleted_at IS NULL) AS title_count
+		FROM agencies a
+		JOIN agency_word_counts_as_of(CAST(@as_of AS date)) awc ON awc.agency_id = a.id
+		WHERE `+filter+`
+		ORDER BY awc.word_count DESC
+	`, asOfArg(asOf), sql.Named("parent", parentID)).Scan(&metrics).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agency metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// AgencyTotalsAsOf returns the word and restriction count attributed to one
+// agency.
+func AgencyTotalsAsOf(asOf *time.Time, agencyID uuid.UUID) (AgencyTotals, error) {
+	var totals []AgencyTotals
+	err := database.DB.Raw(`
+		SELECT awc.word_count, awc.restriction_count, awc.source
+		FROM agency_word_counts_as_of(CAST(@as_of AS date)) awc
+		WHERE awc.agency_id = @agency
+	`, asOfArg(asOf), sql.Named("agency", agencyID)).Scan(&totals).Error
+	if err != nil {
+		return AgencyTotals{}, fmt.Errorf("failed to sum agency word count: %w", err)
+	}
+	if len(totals) == 0 {
+		return AgencyTotals{}, nil
+	}
+	return totals[0], nil
+}
+
+// AgencyTitleBreakdownAsOf splits an agency's word count by title. Only
+// content versions are broken down; snapshots are not.
+func AgencyTitleBreakdownAsOf(asOf *time.Time, agencyID uuid.UUID) ([]TitleWordCount, error) {
+	var breakdown []TitleWordCount
+	err := database.DB.Raw(`
+		SELECT
+			t.number AS title_number,
+			t.name AS title_name,
+			COALESCE(SUM(aca.word_count), 0) AS word_count,
+			SUM(aca.restriction_count) AS restriction_count
+		FROM agency_content_attribution_as_of(CAST(@as_of AS date)) aca
+		JOIN titles t ON t.id = aca.title_id
+		WHERE aca.agency_id = @agency
+		GROUP BY t.number, t.name
+		ORDER BY t.number
+	`, asOfArg(asOf), sql.Named("agency", agencyID)).Scan(&breakdown).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agency title breakdown: %w", err)
+	}
+	return breakdown, nil
+}
+
+// AttributedChecksumsAsOf returns the checksums attributed to each agency in
+// the deterministic order AttributedChecksumLine expects.
+func AttributedChecksumsAsOf(asOf *time.Time, agencyIDs []uuid.UUID) ([]AttributedChecksum, error) {
+	var checksums []AttributedChecksum
+	err := database.DB.Raw(`
+		SELECT aca.agency_id, t.number AS title_number, aca.scope_type, aca.scope_identifier, aca.checksum
+		FROM agency_content_attribution_as_of(CAST(@as_of AS date)) aca
+		JOIN titles t ON aca.title_id = t.id
+		WHERE aca.agency_id IN @agencies AND aca.checksum IS NOT NULL AND aca.checksum != ''
+		ORDER BY aca.agency_id ASC, t.number ASC, aca.scope_type ASC, aca.scope_identifier ASC
+	`, asOfArg(asOf), sql.Named("agencies", agencyIDs)).Scan(&checksums).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attributed checksums: %w", err)
+	}
+	return checksums, nil
+}
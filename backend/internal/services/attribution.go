@@ -0,0 +1,105 @@
+package services
+
+import (
+	"ecfr-analyzer/internal/models"
+)
+
+// scopedStructure is a node of the versioner structure tree together with the
+// chapter and subchapter that enclose it.
+type scopedStructure struct {
+	node       *TitleStructure
+	chapter    string
+	subchapter string
+}
+
+// attributedStructureSize returns the size of the parts of a title structure
+// covered by an agency's CFR references. It applies the same rules as the
+// agency_content_attribution view: the most specific scope named by each
+// reference is matched, and scopes nested inside a broader match are only
+// counted once.
+func attributedStructureSize(root *TitleStructure, refs []models.AgencyCFRReference) int {
+	if root == nil || len(refs) == 0 {
+		return 0
+	}
+
+	var scopes []scopedStructure
+	var walk func(node *TitleStructure, chapter, subchapter string)
+	walk = func(node *TitleStructure, chapter, subchapter string) {
+		switch node.Type {
+		case NodeTypeChapter:
+			chapter = node.Identifier
+		case NodeTypeSubchapter:
+			subchapter = node.Identifier
+		}
+		scopes = append(scopes, scopedStructure{node: node, chapter: chapter, subchapter: subchapter})
+		for i := range node.Children {
+			walk(&node.Children[i], chapter, subchapter)
+		}
+	}
+	walk(root, "", "")
+
+	matched := make(map[*TitleStructure]scopedStructure)
+	for _, ref := range refs {
+		for _, scope := range scopes {
+			if referenceMatchesScope(ref, scope) {
+				matched[scope.node] = scope
+			}
+		}
+	}
+
+	total := 0
+	for node, scope := range matched {
+		if coveredByBroaderScope(node, scope, matched) {
+			continue
+		}
+		total += node.Size
+	}
+	return total
+}
+
+func referenceMatchesScope(ref models.AgencyCFRReference, scope scopedStructure) bool {
+	chapter := stringValue(ref.Chapter)
+	subchapter := stringValue(ref.Subchapter)
+	part := stringValue(ref.Part)
+	node := scope.node
+
+	switch {
+	case part != "":
+		return node.Type == NodeTypePart && node.Identifier == part
+	case subchapter != "":
+		return node.Type == NodeTypeSubchapter && node.Identifier == subchapter &&
+			(chapter == "" || scope.chapter == chapter)
+	case chapter != "":
+		return node.Type == NodeTypeChapter && node.Identifier == chapter
+	default:
+		return node.Type == NodeTypeTitle
+	}
+}
+
+func coveredByBroaderScope(node *TitleStructure, scope scopedStructure, matched map[*TitleStructure]scopedStructure) bool {
+	for other, broader := range matched {
+		if other == node {
+			continue
+		}
+		switch other.Type {
+		case NodeTypeTitle:
+			return true
+		case NodeTypeChapter:
+			if scope.chapter == other.Identifier {
+				return true
+			}
+		case NodeTypeSubchapter:
+			if node.Type == NodeTypePart && scope.subchapter == other.Identifier && scope.chapter == broader.chapter {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Statute is a U.S. Code section or public law named as the authority of a
+// CFR part.
+type Statute struct {
+	Type     string `json:"type"`
+	Title    int    `json:"title"`
+	Section  string `json:"section"`
+	Citation string `json:"citation"`
+}
+
+// AgencyStatute is a statute an agency regulates under, with the number of
+// the agency's parts naming it as their authority.
+type AgencyStatute struct {
+	Statute
+	Parts int `json:"parts"`
+}
+
+// PartNotes summarizes the authority and source notes of one part: the
+// statutes it is issued under and the Federal Register documents that
+// published and amended it, counted over the part and its sections.
+type PartNotes struct {
+	NodeID         uuid.UUID  `json:"nodeId"`
+	Part           string     `json:"part"`
+	Heading        string     `json:"heading"`
+	Statutes       []Statute  `json:"statutes" gorm:"-"`
+	FRDocuments    int        `json:"frDocuments"`
+	Amendments     int        `json:"amendments"`
+	FirstPublished *time.Time `json:"firstPublished,omitempty"`
+	LastAmended    *time.Time `json:"lastAmended,omitempty"`
+}
+
+// statuteCitation formats a statute, e.g. "29 U.S.C. 655" or "Pub. L. 101-552".
+func statuteCitation(statuteType string, title int, section string) string {
+	if statuteType == StatuteTypePublicLaw {
+		return fmt.Sprintf("Pub. L. %d-%s", title, section)
+	}
+	return referenceCitation(ReferenceTargetUSC, title, section, nil)
+}
+
+// AgencyStatutesAsOf lists the statutes named in the authority notes of the
+// parts attributed to an agency, in the content in effect on asOf, most
+// widely used first and U.S. Code sections before public laws.
+func AgencyStatutesAsOf(asOf *time.Time, agencyID uuid.UUID) ([]AgencyStatute, error) {
+	statutes := []AgencyStatute{}
+	err := database.DB.Raw(currentContents+`
+		SELECT sc.statute_type AS type, sc.statute_title AS title, sc.statute_section AS section,
+			COUNT(DISTINCT ap.node_id) AS parts
+		FROM statute_citations sc
+		JOIN current_contents tc ON tc.id = sc.title_content_id
+		JOIN agency_parts ap ON ap.title_content_id = sc.title_content_id AND ap.part = sc.part
+		WHERE ap.agency_id = @agency
+		GROUP BY sc.statute_type, sc.statute_title, sc.statute_section
+		ORDER BY parts DESC, type DESC, title, section
+	`, asOfArg(asOf), sql.Named("agency", agencyID)).Scan(&statutes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agency statutes: %w", err)
+	}
+	for i := range statutes {
+		statute := &statutes[i].Statute
+		statute.Citation = statuteCitation(statute.Type, statute.Title, statute.Section)
+	}
+	return statutes, nil
+}
+
+// TitlePartNotesAsOf summarizes the authority and source notes of every part
+// of a title in the content version in effect on asOf, in document order.
+func TitlePartNotesAsOf(asOf *time.Time, titleID uuid.UUID) ([]PartNotes, error) {
+	args := []interface{}{asOfArg(asOf), sql.Named("title", titleID)}
+
+	parts := []PartNotes{}
+	err := database.DB.Raw(currentContents+`
+		SELECT p.id AS node_id, p.identifier AS part, p.heading,
+			COALESCE(fr.fr_documents, 0) AS fr_documents,
+			COALESCE(fr.amendments, 0) AS amendments,
+			fr.first_published, fr.last_amended
+		FROM current_contents tc
+		JOIN structure_nodes p ON p.title_content_id = tc.id AND p.node_type = 'part'
+		LEFT JOIN LATERAL (
+			SELECT
+				COUNT(DISTINCT (fc.volume, fc.page)) AS fr_documents,
+				COUNT(DISTINCT (fc.volume, fc.page)) FILTER (WHERE fc.amendment) AS amendments,
+				MIN(fc.published_on) AS first_published,
+				MAX(fc.published_on) FILTER (WHERE fc.amendment) AS last_amended
+			FROM federal_register_citations fc
+			WHERE fc.title_content_id = tc.id AND fc.part = p.identifier
+		) fr ON true
+		WHERE tc.title_id = @title
+		ORDER BY p.position
+	`, args...).Scan(&parts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch part source notes: %w", err)
+	}
+
+	var statutes []struct {
+		Part string
+		Statute
+	}
+	err = database.DB.Raw(currentContents+`
+		SELECT DISTINCT sc.part, sc.statute_type AS type, sc.statute_title AS title, sc.statute_section AS section
+		FROM statute_citations sc
+		JOIN current_contents tc ON tc.id = sc.title_content_id
+		WHERE tc.title_id = @title AND sc.part IS NOT NULL
+		ORDER BY sc.part, type DESC, title, section
+	`, args...).Scan(&statutes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch part statutes: %w", err)
+	}
+
+	byPart := make(map[string][]Statute)
+	for _, row := range statutes {
+		statute := row.Statute
+		statute.Citation = statuteCitation(statute.Type, statute.Title, statute.Section)
+		byPart[row.Part] = append(byPart[row.Part], statute)
+	}
+	for i := range parts {
+		parts[i].Statutes = byPart[parts[i].Part]
+		if parts[i].Statutes == nil {
+			parts[i].Statutes = []Statute{}
+		}
+	}
+	return parts, nil
+}
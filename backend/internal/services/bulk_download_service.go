@@ -1,58 +1,119 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"time"
 )
 
-const (
-	BulkRepositoryBaseURL = "https://www.govinfo.gov/bulkdata/ECFR"
-	BulkDownloadTimeout   = 60 * time.Second
-)
+const BulkRepositoryBaseURL = "https://www.govinfo.gov/bulkdata/ECFR"
 
 type BulkDownloadService struct {
-	client *http.Client
+	client  *http.Client
+	baseURL string
 }
 
 func NewBulkDownloadService() *BulkDownloadService {
+	return NewBulkDownloadServiceWithConfig(DefaultUpstreamConfig())
+}
+
+// NewBulkDownloadServiceWithConfig creates a bulk download service for the
+// repository at config.BulkBaseURL using config.Transport for all requests.
+func NewBulkDownloadServiceWithConfig(config UpstreamConfig) *BulkDownloadService {
 	return &BulkDownloadService{
+		// Timeouts and retries are handled per attempt by the transport
 		client: &http.Client{
-			Timeout: BulkDownloadTimeout,
+			Transport: config.transport(),
 		},
+		baseURL: config.BulkBaseURL,
 	}
 }
 
-func (b *BulkDownloadService) DownloadTitleXML(titleNumber int) (string, error) {
-	url := fmt.Sprintf("%s/title-%d/ECFR-title%d.xml", BulkRepositoryBaseURL, titleNumber, titleNumber)
+// ErrNotModified is returned by conditional downloads when the file has not
+// changed since the download the validators came from.
+var ErrNotModified = errors.New("content not modified")
+
+// ContentValidators are the HTTP cache validators of a downloaded file, sent
+// back as If-None-Match and If-Modified-Since on the next download.
+type ContentValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// OpenTitleXML starts downloading a title and returns the response body. The
+// caller must close it.
+func (b *BulkDownloadService) OpenTitleXML(ctx context.Context, titleNumber int) (io.ReadCloser, error) {
+	body, _, err := b.OpenTitleXMLIfModified(ctx, titleNumber, nil)
+	return body, err
+}
+
+// OpenTitleXMLIfModified starts downloading a title unless the repository
+// reports it unchanged since the download previous came from, in which case it
+// returns ErrNotModified. previous may be nil. The caller must close the
+// returned body.
+func (b *BulkDownloadService) OpenTitleXMLIfModified(ctx context.Context, titleNumber int, previous *ContentValidators) (io.ReadCloser, *ContentValidators, error) {
+	url := fmt.Sprintf("%s/title-%d/ECFR-title%d.xml", b.baseURL, titleNumber, titleNumber)
 	log.Printf("[BULK_DOWNLOAD] Downloading title %d XML from: %s", titleNumber, url)
 	
-	resp, err := b.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request for title %d XML: %w", titleNumber, err)
+	}
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	resp, err := b.client.Do(req)
 	if err != nil {
 		log.Printf("[BULK_DOWNLOAD] Failed to download title %d XML: %v", titleNumber, err)
-		return "", fmt.Errorf("failed to download title %d XML: %w", titleNumber, err)
+		return nil, nil, fmt.Errorf("failed to download title %d XML: %w", titleNumber, err)
 	}
-	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		log.Printf("[BULK_DOWNLOAD] Title %d XML not modified", titleNumber)
+		return nil, previous, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		log.Printf("[BULK_DOWNLOAD] Unexpected status code for title %d: %d", titleNumber, resp.StatusCode)
-		return "", fmt.Errorf("unexpected status code for title %d: %d", titleNumber, resp.StatusCode)
+		return nil, nil, fmt.Errorf("unexpected status code for title %d: %d", titleNumber, resp.StatusCode)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[BULK_DOWNLOAD] Failed to read title %d XML content: %v", titleNumber, err)
-		return "", fmt.Errorf("failed to read title %d XML content: %w", titleNumber, err)
+	validators := &ContentValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
 	}
+	return resp.Body, validators, nil
+}
 
-	log.Printf("[BULK_DOWNLOAD] Successfully downloaded title %d XML (%d bytes)", titleNumber, len(body))
-	return string(body), nil
+// Probe checks that the repository serves title 1 with a HEAD request, which
+// costs no download.
+func (b *BulkDownloadService) Probe(ctx context.Context) error {
+	url := fmt.Sprintf("%s/title-1/ECFR-title1.xml", b.baseURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bulk repository probe failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bulk repository probe returned status %d", resp.StatusCode)
+	}
+	return nil
 }
 
-func (b *BulkDownloadService) IsAvailable() bool {
-	// Test with title 1 which should always exist
-	_, err := b.DownloadTitleXML(1)
-	return err == nil
-}
\ No newline at end of file
+func (b *BulkDownloadService) IsAvailable(ctx context.Context) bool {
+	return b.Probe(ctx) == nil
+}
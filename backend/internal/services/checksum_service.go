@@ -0,0 +1,132 @@
+package services
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Results of storing one agency checksum
+const (
+	ChecksumCreated = "created"
+	ChecksumUpdated = "updated"
+	ChecksumSkipped = "skipped"
+)
+
+type ChecksumStats struct {
+	Total          int `json:"total"`
+	CreatedUpdated int `json:"created_updated"`
+	Skipped        int `json:"skipped"`
+	Errors         int `json:"errors"`
+}
+
+type ChecksumService struct{}
+
+func NewChecksumService() *ChecksumService {
+	return &ChecksumService{}
+}
+
+// RecalculateAll recalculates and caches the checksum of every agency. Errors
+// for single agencies are counted in the stats rather than returned.
+func (c *ChecksumService) RecalculateAll() (*ChecksumStats, error) {
+	var agencies []models.Agency
+	if err := database.DB.Find(&agencies).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch agencies: %w", err)
+	}
+
+	log.Printf("[CHECKSUMS] Found %d agencies to process", len(agencies))
+
+	stats := &ChecksumStats{Total: len(agencies)}
+	for _, agency := range agencies {
+		result, err := c.CalculateAndStoreAgencyChecksum(agency.ID)
+		if err != nil {
+			log.Printf("[CHECKSUMS] Failed to process agency %s: %v", agency.Name, err)
+			stats.Errors++
+			continue
+		}
+
+		switch result {
+		case ChecksumCreated, ChecksumUpdated:
+			stats.CreatedUpdated++
+		case ChecksumSkipped:
+			stats.Skipped++
+		}
+	}
+
+	log.Printf("[CHECKSUMS] Calculation completed: %d created/updated, %d skipped, %d errors",
+		stats.CreatedUpdated, stats.Skipped, stats.Errors)
+	return stats, nil
+}
+
+// CalculateAndStoreAgencyChecksum combines the checksums of the content
+// attributed to an agency and caches the result. It reports whether the cached
+// checksum was created, updated or skipped because nothing changed.
+func (c *ChecksumService) CalculateAndStoreAgencyChecksum(agencyID uuid.UUID) (string, error) {
+	// Get the checksums of the latest content attributed to this agency
+	titleChecksums, err := AttributedChecksumsAsOf(nil, []uuid.UUID{agencyID})
+	if err != nil {
+		return "", err
+	}
+
+	if len(titleChecksums) == 0 {
+		// No content for this agency, skip
+		return ChecksumSkipped, nil
+	}
+
+	// Create deterministic content hash from title checksums
+	var contentBuilder strings.Builder
+	for _, tc := range titleChecksums {
+		contentBuilder.WriteString(AttributedChecksumLine(tc.TitleNumber, tc.ScopeType, tc.ScopeIdentifier, tc.Checksum))
+	}
+
+	contentHash := fmt.Sprintf("%x", sha256.Sum256([]byte(contentBuilder.String())))
+
+	// Create agency checksum from the combined content
+	agencyChecksum := fmt.Sprintf("%x", sha256.Sum256([]byte(contentBuilder.String())))
+
+	// Check if we need to update (content changed)
+	var existingChecksum models.AgencyChecksum
+	err = database.DB.Where("agency_id = ?", agencyID).First(&existingChecksum).Error
+	if err == nil {
+		// Record exists, check if content hash changed
+		if existingChecksum.ContentHash == contentHash {
+			return ChecksumSkipped, nil
+		}
+
+		existingChecksum.Checksum = agencyChecksum
+		existingChecksum.ContentHash = contentHash
+		existingChecksum.UpdatedAt = time.Now().UTC()
+
+		if err := database.DB.Save(&existingChecksum).Error; err != nil {
+			return "", err
+		}
+		return ChecksumUpdated, nil
+	}
+
+	newChecksum := models.AgencyChecksum{
+		AgencyID:    agencyID,
+		Checksum:    agencyChecksum,
+		ContentHash: contentHash,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	if err := database.DB.Create(&newChecksum).Error; err != nil {
+		return "", err
+	}
+	return ChecksumCreated, nil
+}
+
+// AttributedChecksumLine renders one attributed scope of an agency as a line of
+// the checksum input. Whole-title scopes keep the original TITLE_n format.
+func AttributedChecksumLine(titleNumber int, scopeType string, scopeIdentifier *string, checksum string) string {
+	if scopeType == "title" || scopeIdentifier == nil {
+		return fmt.Sprintf("TITLE_%d:%s\n", titleNumber, checksum)
+	}
+	return fmt.Sprintf("TITLE_%d:%s_%s:%s\n", titleNumber, strings.ToUpper(scopeType), *scopeIdentifier, checksum)
+}
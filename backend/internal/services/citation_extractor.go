@@ -0,0 +1,134 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Target types of section references
+const (
+	ReferenceTargetSection = "section"
+	ReferenceTargetPart    = "part"
+	ReferenceTargetUSC     = "usc"
+)
+
+// extractedReference is one target cited by a section.
+type extractedReference struct {
+	TargetType       string
+	TargetTitle      int
+	TargetIdentifier string
+}
+
+const (
+	// A CFR section number with optional paragraph designations,
+	// "1910.134(c)(1)"
+	citedSection = `\d+[A-Za-z]?\.\d+[A-Za-z0-9-]*(?:\([A-Za-z0-9]+\))*`
+	citedPart    = `\d+[A-Za-z]?`
+	// A U.S.C. section or range with optional subsections, "7401-7671q"
+	citedUSCSection = `\d+[A-Za-z0-9-]*(?:\([A-Za-z0-9]+\))*`
+	// Separators of cited lists: "60.1, 60.2, and 60.3", "parts 60 through 63"
+	citedSeparator = `(?:,\s*|,?\s+(?:and|or|through|to)\s+)`
+)
+
+var (
+	// Patterns of the single targets in a matched list
+	targetPatterns = map[string]*regexp.Regexp{
+		ReferenceTargetSection: regexp.MustCompile(citedSection),
+		ReferenceTargetPart:    regexp.MustCompile(citedPart),
+		ReferenceTargetUSC:     regexp.MustCompile(citedUSCSection),
+	}
+	// nextCitationTitle follows a number that is the volume or title of the
+	// next citation rather than the last item of a list: "parts 60, 40 CFR
+	// 61.1", "42 U.S.C. 7401, 104 Stat. 2399"
+	nextCitationTitle = regexp.MustCompile(`^\s+(?:CFR|U\.\s?S\.\s?C\.|Stat\.|FR\b)`)
+
+	// Citations are matched in this order, and the text each one matches is
+	// blanked out so later patterns do not match it again: "12 CFR § 1026.5"
+	// cites title 12, not the section of the same number in the citing title.
+	citationPatterns = []struct {
+		pattern    *regexp.Regexp
+		targetType string
+		sameTitle  bool
+	}{
+		{regexp.MustCompile(`(\d+)\s+CFR\s+[Pp]arts?\s+(` + citedPart + `(?:` + citedSeparator + citedPart + `)*)\b`), ReferenceTargetPart, false},
+		{regexp.MustCompile(`(\d+)\s+CFR\s+(?:§§?\s*)?(` + citedSection + `(?:` + citedSeparator + citedSection + `)*)`), ReferenceTargetSection, false},
+		{regexp.MustCompile(`(\d+)\s+U\.\s?S\.\s?C\.\s+(?:§§?\s*|[Ss]ec(?:tions?|s?\.)\s+)?(` + citedUSCSection + `(?:` + citedSeparator + citedUSCSection + `)*)`), ReferenceTargetUSC, false},
+		{regexp.MustCompile(`§§?\s*(` + citedSection + `(?:` + citedSeparator + citedSection + `)*)`), ReferenceTargetSection, true},
+		{regexp.MustCompile(`\b[Pp]arts?\s+(` + citedPart + `(?:` + citedSeparator + citedPart + `)*)\s+of\s+this\s+(?:title|chapter|subchapter)\b`), ReferenceTargetPart, true},
+	}
+)
+
+// extractReferences finds the CFR sections and parts and the U.S.C. sections
+// cited in the text of a section of the given title, and counts how often
+// each is cited. Section numbers without a title ("§ 1910.134") and parts "of
+// this chapter" are in the citing title. Citations of the section itself are
+// left out.
+func extractReferences(text string, titleNumber int, identifier string) map[extractedReference]int {
+	references := make(map[extractedReference]int)
+	for _, citation := range citationPatterns {
+		var spans [][2]int
+		for pos := 0; pos < len(text); {
+			match := citation.pattern.FindStringSubmatchIndex(text[pos:])
+			if match == nil {
+				break
+			}
+			for i := range match {
+				if match[i] >= 0 {
+					match[i] += pos
+				}
+			}
+
+			title := titleNumber
+			listStart, listEnd := match[2], match[3]
+			if !citation.sameTitle {
+				number, err := strconv.Atoi(text[match[2]:match[3]])
+				if err != nil {
+					pos = match[1]
+					continue
+				}
+				title = number
+				listStart, listEnd = match[4], match[5]
+			}
+
+			end := match[1]
+			items := targetPatterns[citation.targetType].FindAllStringIndex(text[listStart:listEnd], -1)
+			if len(items) > 1 && nextCitationTitle.MatchString(text[end:]) {
+				// Leave the title of the next citation for the next match
+				end = listStart + items[len(items)-1][0]
+				items = items[:len(items)-1]
+			}
+			for _, item := range items {
+				// Paragraphs and subsections cite the whole section
+				target := text[listStart+item[0] : listStart+item[1]]
+				if paragraph := strings.IndexByte(target, '('); paragraph >= 0 {
+					target = target[:paragraph]
+				}
+				target = strings.TrimRight(target, "-")
+				if citation.targetType == ReferenceTargetSection && title == titleNumber && target == identifier {
+					continue
+				}
+				references[extractedReference{
+					TargetType:       citation.targetType,
+					TargetTitle:      title,
+					TargetIdentifier: target,
+				}]++
+			}
+			spans = append(spans, [2]int{match[0], end})
+			pos = end
+		}
+		if len(spans) == 0 {
+			continue
+		}
+
+		// Blank out what was matched, keeping offsets for the next pattern
+		masked := []byte(text)
+		for _, span := range spans {
+			for i := span[0]; i < span[1]; i++ {
+				masked[i] = ' '
+			}
+		}
+		text = string(masked)
+	}
+	return references
+}
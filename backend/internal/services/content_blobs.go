@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"ecfr-analyzer/internal/blobstore"
+	"ecfr-analyzer/internal/models"
+)
+
+// ingestedXML describes title XML written to the blob store. Root and
+// TitleNumber are the root element and the N of the title DIV, as far as the
+// document could be parsed; ParseErr is set when it is not well-formed.
+type ingestedXML struct {
+	Checksum    string
+	Size        int64
+	WordCount   int
+	Root        string
+	TitleNumber string
+	ParseErr    error
+
+	Restrictions models.RestrictionCounts
+}
+
+// ingestTitleXML streams title XML into the blob store while hashing it,
+// counting its words and restriction terms and checking that it parses, so
+// memory use does not grow with the size of the title. The blob is stored
+// under the resulting checksum even if it does not parse, so that it can be
+// reviewed.
+func ingestTitleXML(ctx context.Context, body io.Reader) (*ingestedXML, error) {
+	writer, err := blobstore.Default.Create(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store title XML: %w", err)
+	}
+	defer writer.Abort()
+
+	hash := sha256.New()
+	words := newWordCounter()
+	restrictions := newRestrictionCounter()
+	check := newTitleXMLCheck()
+	size, err := io.Copy(io.MultiWriter(hash, words, restrictions, check, writer), body)
+	check.finish(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read title XML: %w", err)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err := writer.Commit(checksum); err != nil {
+		return nil, fmt.Errorf("failed to store title XML: %w", err)
+	}
+	return &ingestedXML{
+		Checksum:    checksum,
+		Size:        size,
+		WordCount:   words.Count(),
+		Root:        check.root,
+		TitleNumber: check.titleNumber,
+		ParseErr:    check.parseErr,
+
+		Restrictions: restrictions.Counts(),
+	}, nil
+}
+
+// OpenTitleXML opens the XML of a stored title content version, which lives in
+// the blob store under the version's checksum.
+func OpenTitleXML(ctx context.Context, content *models.TitleContent) (io.ReadCloser, error) {
+	if content.Checksum == nil {
+		return nil, fmt.Errorf("title content %s has no checksum to locate its XML", content.ID)
+	}
+	return blobstore.Default.Open(ctx, *content.Checksum)
+}
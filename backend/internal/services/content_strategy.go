@@ -1,14 +1,42 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"time"
 )
 
+// ContentDownloadStrategy is a source of full title XML. Content is returned
+// as a stream so titles of any size can be processed in bounded memory; the
+// caller must close it.
 type ContentDownloadStrategy interface {
-	DownloadTitleContent(titleNumber int) (string, error)
+	OpenTitleContent(ctx context.Context, titleNumber int) (io.ReadCloser, error)
+	// Probe cheaply checks whether the source is reachable, without
+	// downloading any title.
+	Probe(ctx context.Context) error
 	GetStrategyName() string
 }
 
+// ConditionalContentStrategy is implemented by strategies that can tell an
+// unchanged title from the validators of an earlier download without
+// downloading it again.
+type ConditionalContentStrategy interface {
+	ContentDownloadStrategy
+	OpenTitleContentIfModified(ctx context.Context, titleNumber int, previous *ContentValidators) (io.ReadCloser, *ContentValidators, error)
+}
+
+// DownloadedContent is a title's XML stream together with the strategy that
+// opened it and, for conditional strategies, the validators to send next time.
+// Body must be closed.
+type DownloadedContent struct {
+	Body       io.ReadCloser
+	Strategy   string
+	Validators *ContentValidators
+}
+
 type APIContentStrategy struct {
 	client *ECFRClient
 }
@@ -21,60 +49,197 @@ func NewAPIContentStrategy(client *ECFRClient) *APIContentStrategy {
 	return &APIContentStrategy{client: client}
 }
 
-func NewBulkContentStrategy() *BulkContentStrategy {
+func NewBulkContentStrategy(bulkService *BulkDownloadService) *BulkContentStrategy {
 	return &BulkContentStrategy{
-		bulkService: NewBulkDownloadService(),
+		bulkService: bulkService,
 	}
 }
 
-func (a *APIContentStrategy) DownloadTitleContent(titleNumber int) (string, error) {
-	return a.client.FetchTitleContent(titleNumber, "")
+func (a *APIContentStrategy) OpenTitleContent(ctx context.Context, titleNumber int) (io.ReadCloser, error) {
+	return a.client.OpenTitleContent(ctx, titleNumber, "")
+}
+
+func (a *APIContentStrategy) Probe(ctx context.Context) error {
+	return a.client.Probe(ctx)
 }
 
 func (a *APIContentStrategy) GetStrategyName() string {
 	return "API"
 }
 
-func (b *BulkContentStrategy) DownloadTitleContent(titleNumber int) (string, error) {
-	return b.bulkService.DownloadTitleXML(titleNumber)
+func (b *BulkContentStrategy) OpenTitleContent(ctx context.Context, titleNumber int) (io.ReadCloser, error) {
+	return b.bulkService.OpenTitleXML(ctx, titleNumber)
+}
+
+func (b *BulkContentStrategy) OpenTitleContentIfModified(ctx context.Context, titleNumber int, previous *ContentValidators) (io.ReadCloser, *ContentValidators, error) {
+	return b.bulkService.OpenTitleXMLIfModified(ctx, titleNumber, previous)
+}
+
+func (b *BulkContentStrategy) Probe(ctx context.Context) error {
+	return b.bulkService.Probe(ctx)
 }
 
 func (b *BulkContentStrategy) GetStrategyName() string {
 	return "Bulk Repository"
 }
 
-// ContentDownloader manages different download strategies
+// ErrNoHealthyStrategy is returned by DownloadTitle when every configured
+// strategy is skipped for failing repeatedly.
+var ErrNoHealthyStrategy = errors.New("every content download strategy is failing, retrying after cooldown")
+
+// Content download strategy keys used in ContentDownloaderConfig
+const (
+	StrategyBulk = "bulk"
+	StrategyAPI  = "api"
+)
+
+// newContentStrategy creates the strategy for a configuration key.
+func newContentStrategy(key string, config UpstreamConfig) (ContentDownloadStrategy, error) {
+	switch key {
+	case StrategyBulk:
+		return NewBulkContentStrategy(NewBulkDownloadServiceWithConfig(config)), nil
+	case StrategyAPI:
+		return NewAPIContentStrategy(NewECFRClientWithConfig(config)), nil
+	}
+	return nil, fmt.Errorf("unknown content strategy %q", key)
+}
+
+// ContentDownloader manages different download strategies. It tracks how each
+// strategy performs, tries healthy strategies first and leaves out strategies
+// that keep failing until a health probe succeeds.
 type ContentDownloader struct {
-	strategies []ContentDownloadStrategy
+	strategies []*strategyState
+	config     ContentDownloaderConfig
 }
 
 func NewContentDownloader() *ContentDownloader {
-	// Create strategies in preferred order (bulk first, then API fallback)
-	strategies := []ContentDownloadStrategy{
-		NewBulkContentStrategy(),
-		NewAPIContentStrategy(NewECFRClient()),
-	}
-	
-	return &ContentDownloader{
-		strategies: strategies,
+	return NewContentDownloaderWithConfig(DefaultUpstreamConfig())
+}
+
+// NewContentDownloaderWithConfig creates a downloader for the given upstreams
+// with the strategy chain configured by CONTENT_STRATEGIES.
+func NewContentDownloaderWithConfig(config UpstreamConfig) *ContentDownloader {
+	return NewConfiguredContentDownloader(config, DefaultContentDownloaderConfig())
+}
+
+// NewConfiguredContentDownloader creates a downloader with an explicit
+// strategy chain. Unknown strategies are logged and left out.
+func NewConfiguredContentDownloader(config UpstreamConfig, downloaderConfig ContentDownloaderConfig) *ContentDownloader {
+	cd := &ContentDownloader{config: downloaderConfig}
+	for _, strategyConfig := range downloaderConfig.Strategies {
+		strategy, err := newContentStrategy(strategyConfig.Key, config)
+		if err != nil {
+			log.Printf("[CONTENT] Ignoring strategy: %v", err)
+			continue
+		}
+		cd.strategies = append(cd.strategies, &strategyState{
+			key:      strategyConfig.Key,
+			timeout:  strategyConfig.Timeout,
+			strategy: strategy,
+		})
 	}
+	return cd
 }
 
-func (cd *ContentDownloader) DownloadTitleContent(titleNumber int) (string, error) {
-	var lastErr error
+// DownloadTitle tries each usable strategy until one opens the title.
+// Conditional strategies are given the validators of the previous download
+// (which may be nil); when one of them reports the title unchanged,
+// ErrNotModified is returned without trying the remaining strategies. Only
+// opening the download falls back; a failure while the body streams does not.
+func (cd *ContentDownloader) DownloadTitle(ctx context.Context, titleNumber int, previous *ContentValidators) (*DownloadedContent, error) {
+	lastErr := errors.New("no content download strategy is enabled")
+	usable := cd.usableStrategies(ctx)
+	if len(usable) == 0 && len(cd.strategies) > 0 {
+		lastErr = ErrNoHealthyStrategy
+	}
 	
-	for _, strategy := range cd.strategies {
+	for _, state := range usable {
+		strategy := state.strategy
 		log.Printf("Attempting to download title %d using %s strategy", titleNumber, strategy.GetStrategyName())
 		
-		content, err := strategy.DownloadTitleContent(titleNumber)
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if state.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, state.timeout)
+		}
+		started := time.Now()
+		
+		var body io.ReadCloser
+		var validators *ContentValidators
+		var err error
+		if conditional, ok := strategy.(ConditionalContentStrategy); ok {
+			body, validators, err = conditional.OpenTitleContentIfModified(attemptCtx, titleNumber, previous)
+		} else {
+			body, err = strategy.OpenTitleContent(attemptCtx, titleNumber)
+		}
+		if errors.Is(err, ErrNotModified) {
+			cancel()
+			state.recordNotModified()
+			log.Printf("Title %d not modified according to %s strategy", titleNumber, strategy.GetStrategyName())
+			return nil, err
+		}
 		if err == nil {
-			log.Printf("Successfully downloaded title %d using %s strategy", titleNumber, strategy.GetStrategyName())
-			return content, nil
+			log.Printf("Opened title %d using %s strategy", titleNumber, strategy.GetStrategyName())
+			return &DownloadedContent{
+				Body:       newTrackedBody(body, state, started, cancel, cd.config),
+				Strategy:   strategy.GetStrategyName(),
+				Validators: validators,
+			}, nil
+		}
+		cancel()
+		
+		// Falling back to the next strategy is pointless once cancelled
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 		
+		state.recordFailure(err, time.Since(started), cd.config)
 		log.Printf("Failed to download title %d using %s strategy: %s", titleNumber, strategy.GetStrategyName(), err.Error())
 		lastErr = err
 	}
 	
-	return "", lastErr
-}
\ No newline at end of file
+	return nil, lastErr
+}
+
+// usableStrategies orders the strategies for one download: healthy ones in
+// configured order, then failing ones whose cooldown has passed and whose
+// health probe succeeds. Failing strategies still cooling down, or being
+// probed by another download, are skipped.
+func (cd *ContentDownloader) usableStrategies(ctx context.Context) []*strategyState {
+	var healthy, recovering []*strategyState
+	for _, state := range cd.strategies {
+		switch state.health(cd.config) {
+		case strategyHealthy:
+			healthy = append(healthy, state)
+		case strategyProbeDue:
+			err := state.probe(ctx, cd.config)
+			if errors.Is(err, errProbeInProgress) {
+				// Another download is probing it; skip it until that succeeds
+				continue
+			}
+			if err != nil {
+				log.Printf("[CONTENT] %s strategy still unhealthy: %v", state.strategy.GetStrategyName(), err)
+				continue
+			}
+			recovering = append(recovering, state)
+		}
+	}
+	return append(healthy, recovering...)
+}
+
+// Stats reports how each strategy has performed, in configured order.
+func (cd *ContentDownloader) Stats() []StrategyStats {
+	stats := make([]StrategyStats, 0, len(cd.strategies))
+	for i, state := range cd.strategies {
+		stats = append(stats, state.stats(i+1, cd.config))
+	}
+	return stats
+}
+
+// ProbeAll runs the health probe of every strategy and records the results.
+func (cd *ContentDownloader) ProbeAll(ctx context.Context) {
+	for _, state := range cd.strategies {
+		if err := state.probe(ctx, cd.config); err != nil {
+			log.Printf("[CONTENT] %s strategy probe failed: %v", state.strategy.GetStrategyName(), err)
+		}
+	}
+}
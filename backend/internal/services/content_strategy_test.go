@@ -0,0 +1,144 @@
+package services_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"ecfr-analyzer/internal/ecfrfake"
+	"ecfr-analyzer/internal/services"
+)
+
+func TestContentDownloaderDownloadsFromFakeECFR(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategies   string
+		brokenBulk   bool
+		title        int
+		wantStrategy string
+		wantFixture  string
+		wantErr      bool
+	}{
+		{
+			name:         "bulk repository first",
+			strategies:   "bulk,api",
+			title:        1,
+			wantStrategy: "Bulk Repository",
+			wantFixture:  "titles/title-1/2024-06-01.xml",
+		},
+		{
+			name:         "api only",
+			strategies:   "api",
+			title:        40,
+			wantStrategy: "API",
+			wantFixture:  "titles/title-40/2024-03-15.xml",
+		},
+		{
+			name:         "falls back to the api",
+			strategies:   "bulk,api",
+			brokenBulk:   true,
+			title:        40,
+			wantStrategy: "API",
+			wantFixture:  "titles/title-40/2024-03-15.xml",
+		},
+		{
+			name:       "unknown title",
+			strategies: "bulk,api",
+			title:      99,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := ecfrfake.NewServer()
+			defer server.Close()
+
+			upstream := server.UpstreamConfig()
+			if tt.brokenBulk {
+				upstream.BulkBaseURL = server.URL + "/missing"
+			}
+			downloader := newTestDownloader(t, upstream, tt.strategies)
+
+			downloaded, err := downloader.DownloadTitle(context.Background(), tt.title, nil)
+			if tt.wantErr {
+				if err == nil {
+					downloaded.Body.Close()
+					t.Fatal("DownloadTitle() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadTitle() failed: %v", err)
+			}
+			checksum := readChecksum(t, downloaded.Body)
+
+			if downloaded.Strategy != tt.wantStrategy {
+				t.Errorf("Strategy = %q, want %q", downloaded.Strategy, tt.wantStrategy)
+			}
+			if want := fixtureChecksum(t, tt.wantFixture); checksum != want {
+				t.Errorf("content checksum = %s, want %s of %s", checksum, want, tt.wantFixture)
+			}
+
+			stats := downloader.Stats()
+			succeeded := int64(0)
+			for _, stat := range stats {
+				succeeded += stat.Successes
+			}
+			if succeeded != 1 {
+				t.Errorf("strategies recorded %d successes, want 1", succeeded)
+			}
+		})
+	}
+}
+
+func TestContentDownloaderSkipsUnmodifiedTitles(t *testing.T) {
+	server := ecfrfake.NewServer()
+	defer server.Close()
+	downloader := newTestDownloader(t, server.UpstreamConfig(), "bulk,api")
+
+	downloaded, err := downloader.DownloadTitle(context.Background(), 1, nil)
+	if err != nil {
+		t.Fatalf("DownloadTitle() failed: %v", err)
+	}
+	readChecksum(t, downloaded.Body)
+	if downloaded.Validators == nil || downloaded.Validators.ETag == "" {
+		t.Fatalf("Validators = %+v, want the ETag of the bulk file", downloaded.Validators)
+	}
+
+	_, err = downloader.DownloadTitle(context.Background(), 1, downloaded.Validators)
+	if !errors.Is(err, services.ErrNotModified) {
+		t.Fatalf("DownloadTitle() with validators = %v, want ErrNotModified", err)
+	}
+	if requests := server.Handler.RequestCount("/api/versioner/v1/full/"); requests != 0 {
+		t.Errorf("made %d API requests, want none once the bulk file is unchanged", requests)
+	}
+}
+
+func newTestDownloader(t *testing.T, upstream services.UpstreamConfig, strategies string) *services.ContentDownloader {
+	t.Helper()
+	chain, err := services.ParseContentStrategies(strategies)
+	if err != nil {
+		t.Fatalf("ParseContentStrategies(%q) failed: %v", strategies, err)
+	}
+	return services.NewConfiguredContentDownloader(upstream, services.ContentDownloaderConfig{
+		Strategies:       chain,
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+	})
+}
+
+// readChecksum reads and closes a download, returning its hex SHA-256.
+func readChecksum(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
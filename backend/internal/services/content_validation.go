@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/blobstore"
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Quarantined content review statuses
+const (
+	QuarantinePending   = "pending"
+	QuarantineReleased  = "released"
+	QuarantineDiscarded = "discarded"
+)
+
+var (
+	// ErrContentQuarantined is returned when downloaded title XML fails
+	// validation and is held for review instead of being stored.
+	ErrContentQuarantined = errors.New("downloaded content failed validation and was quarantined")
+	// ErrQuarantineNotFound is returned when reviewing an unknown entry.
+	ErrQuarantineNotFound = errors.New("quarantined content not found")
+	// ErrQuarantineReviewed is returned when reviewing an entry twice.
+	ErrQuarantineReviewed = errors.New("quarantined content has already been reviewed")
+	// ErrQuarantineMalformed is returned when releasing XML that does not
+	// parse.
+	ErrQuarantineMalformed = errors.New("quarantined content is not well-formed XML and cannot be released")
+)
+
+// ContentValidationConfig sets how far a title may shrink between two
+// downloads before the new content is quarantined. A ratio of 0 disables the
+// check.
+type ContentValidationConfig struct {
+	MinSizeRatio      float64
+	MinWordCountRatio float64
+}
+
+// DefaultContentValidationConfig reads CONTENT_MIN_SIZE_RATIO and
+// CONTENT_MIN_WORD_RATIO, both defaulting to 0.5: content less than half the
+// size or word count of the previous version is held for review.
+func DefaultContentValidationConfig() ContentValidationConfig {
+	return ContentValidationConfig{
+		MinSizeRatio:      ratioFromEnv("CONTENT_MIN_SIZE_RATIO", 0.5),
+		MinWordCountRatio: ratioFromEnv("CONTENT_MIN_WORD_RATIO", 0.5),
+	}
+}
+
+func ratioFromEnv(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		log.Printf("[CONTENT] Invalid %s %q, using %g", name, value, fallback)
+		return fallback
+	}
+	return ratio
+}
+
+// titleXMLCheck parses title XML as it streams through ingestTitleXML. It is
+// fed through a pipe so the document is parsed once, alongside hashing and
+// storing it, without buffering it.
+type titleXMLCheck struct {
+	pipe *io.PipeWriter
+	done chan struct{}
+
+	root        string
+	titleNumber string
+	parseErr    error
+}
+
+func newTitleXMLCheck() *titleXMLCheck {
+	reader, writer := io.Pipe()
+	check := &titleXMLCheck{pipe: writer, done: make(chan struct{})}
+	go func() {
+		defer close(check.done)
+		check.parseErr = check.scan(reader)
+		// Keep accepting the rest of the document after a parse error
+		io.Copy(io.Discard, reader)
+	}()
+	return check
+}
+
+func (c *titleXMLCheck) Write(p []byte) (int, error) {
+	return c.pipe.Write(p)
+}
+
+// finish ends the document, or abandons it with readErr, and waits for the
+// parser.
+func (c *titleXMLCheck) finish(readErr error) {
+	c.pipe.CloseWithError(readErr)
+	<-c.done
+}
+
+// scan reads the whole document, recording its root element and the N
+// attribute of the first DIV of TYPE="TITLE".
+func (c *titleXMLCheck) scan(r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if c.root == "" {
+			c.root = start.Name.Local
+		}
+		if c.titleNumber != "" {
+			continue
+		}
+		var n, divType string
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "N":
+				n = attr.Value
+			case "TYPE":
+				divType = attr.Value
+			}
+		}
+		if strings.EqualFold(divType, "TITLE") {
+			c.titleNumber = strings.TrimSpace(n)
+		}
+	}
+
+	if c.root == "" {
+		return errors.New("document has no root element")
+	}
+	return nil
+}
+
+// previousContent is the stored content version new content is compared to.
+type previousContent struct {
+	ID        uuid.UUID
+	WordCount *int
+	SizeBytes *int64
+}
+
+// latestTitleContent returns the newest stored content version of a title, or
+// nil if it has none.
+func latestTitleContent(titleID uuid.UUID) (*previousContent, error) {
+	var previous []previousContent
+	err := database.DB.Raw(`
+		SELECT id, word_count, size_bytes
+		FROM title_contents
+		WHERE title_id = ?
+		ORDER BY content_date DESC
+		LIMIT 1
+	`, titleID).Scan(&previous).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(previous) == 0 {
+		return nil, nil
+	}
+	return &previous[0], nil
+}
+
+// validateTitleXML lists what is wrong with downloaded title XML: it must be
+// well-formed eCFR XML for the requested title, and must not be implausibly
+// smaller than the previous version.
+func validateTitleXML(titleNumber int, ingested *ingestedXML, previous *previousContent, config ContentValidationConfig) []string {
+	var problems []string
+	if ingested.ParseErr != nil {
+		problems = append(problems, fmt.Sprintf("not well-formed XML: %v", ingested.ParseErr))
+	} else if ingested.TitleNumber == "" {
+		problems = append(problems, fmt.Sprintf("not eCFR title XML: no title DIV under root element <%s>", ingested.Root))
+	}
+	if ingested.TitleNumber != "" && ingested.TitleNumber != strconv.Itoa(titleNumber) {
+		problems = append(problems, fmt.Sprintf("document is title %s, expected title %d", ingested.TitleNumber, titleNumber))
+	}
+
+	if previous == nil {
+		return problems
+	}
+	if previous.SizeBytes != nil && config.MinSizeRatio > 0 {
+		if float64(ingested.Size) < float64(*previous.SizeBytes)*config.MinSizeRatio {
+			problems = append(problems, fmt.Sprintf("size dropped from %d to %d bytes", *previous.SizeBytes, ingested.Size))
+		}
+	}
+	if previous.WordCount != nil && config.MinWordCountRatio > 0 {
+		if float64(ingested.WordCount) < float64(*previous.WordCount)*config.MinWordCountRatio {
+			problems = append(problems, fmt.Sprintf("word count dropped from %d to %d", *previous.WordCount, ingested.WordCount))
+		}
+	}
+	return problems
+}
+
+// quarantineContent records downloaded content that failed validation. Its
+// XML stays in the blob store under the checksum so it can be reviewed. The
+// same content of a title is only recorded once, so downloading it again on
+// every refresh does not add entries, whether or not it was reviewed.
+func quarantineContent(title models.Title, contentDate time.Time, ingested *ingestedXML, downloaded *DownloadedContent, previous *previousContent, problems []string) error {
+	var existing []models.QuarantinedContent
+	err := database.DB.Where("title_id = ? AND checksum = ?", title.ID, ingested.Checksum).
+		Order("created_at DESC").Limit(1).Find(&existing).Error
+	if err != nil {
+		return fmt.Errorf("failed to check quarantined content of title %d: %w", title.Number, err)
+	}
+	if len(existing) > 0 {
+		log.Printf("[CONTENT] Title %d content %s already quarantined as %s (%s)", title.Number, ingested.Checksum[:8], existing[0].ID, existing[0].Status)
+		return fmt.Errorf("%w: %s", ErrContentQuarantined, existing[0].Reason)
+	}
+
+	entry := &models.QuarantinedContent{
+		TitleID:     title.ID,
+		TitleNumber: title.Number,
+		ContentDate: contentDate,
+		Checksum:    ingested.Checksum,
+		SizeBytes:   ingested.Size,
+		WordCount:   ingested.WordCount,
+		WellFormed:  ingested.ParseErr == nil,
+		Strategy:    optionalString(downloaded.Strategy),
+		Reason:      strings.Join(problems, "; "),
+		Status:      QuarantinePending,
+	}
+	if downloaded.Validators != nil {
+		entry.ETag = optionalString(downloaded.Validators.ETag)
+		entry.LastModified = optionalString(downloaded.Validators.LastModified)
+	}
+	if previous != nil {
+		entry.PreviousContentID = &previous.ID
+	}
+	if err := database.DB.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to quarantine content of title %d: %w", title.Number, err)
+	}
+	log.Printf("[CONTENT] Quarantined title %d content %s: %s", title.Number, ingested.Checksum[:8], entry.Reason)
+	return fmt.Errorf("%w: %s", ErrContentQuarantined, entry.Reason)
+}
+
+// ListQuarantinedContent returns quarantined content, newest first, optionally
+// filtered by review status.
+func ListQuarantinedContent(status string, limit int) ([]models.QuarantinedContent, error) {
+	query := database.DB.Order("created_at DESC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var entries []models.QuarantinedContent
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list quarantined content: %w", err)
+	}
+	return entries, nil
+}
+
+// ReleaseQuarantinedContent stores reviewed content as if it had passed
+// validation, as the version of its title in effect from its date. Like an
+// import, it holds the import lock, and a version is only added when the
+// content differs from the one in effect; ErrImportInProgress is returned
+// while an import runs.
+func (s *ImportService) ReleaseQuarantinedContent(ctx context.Context, id uuid.UUID) (*models.TitleContent, error) {
+	entry, err := pendingQuarantineEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.WellFormed {
+		return nil, ErrQuarantineMalformed
+	}
+
+	lock, err := database.TryAdvisoryLock(ctx, database.ImportLockKey)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, ErrImportInProgress
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("[CONTENT] Failed to release import lock after releasing %s: %v", entry.ID, err)
+		}
+	}()
+
+	wordCount := entry.WordCount
+	checksum := entry.Checksum
+	size := entry.SizeBytes
+	content := &models.TitleContent{
+		TitleID:      entry.TitleID,
+		ContentDate:  entry.ContentDate,
+		WordCount:    &wordCount,
+		Checksum:     &checksum,
+		SizeBytes:    &size,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+	}
+	outcome, err := storeContentVersion(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store released content: %w", err)
+	}
+
+	if outcome != contentVersionUnchanged {
+		xml, err := blobstore.Default.Open(ctx, checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open quarantined XML: %w", err)
+		}
+		defer xml.Close()
+		if _, err := s.structureService.StoreStructure(content, xml); err != nil {
+			return nil, fmt.Errorf("failed to store structure of released content: %w", err)
+		}
+	}
+
+	if err := reviewQuarantineEntry(entry, QuarantineReleased); err != nil {
+		return nil, err
+	}
+
+	// The release completes the refresh the quarantine held back, so the
+	// next one skips the title until it is amended again
+	var title models.Title
+	if err := database.DB.Where("id = ?", entry.TitleID).First(&title).Error; err != nil {
+		log.Printf("[CONTENT] Failed to load title %d after releasing %s: %v", entry.TitleNumber, entry.ID, err)
+	} else {
+		markContentAmendedOn(title.ID, title)
+	}
+	log.Printf("[CONTENT] Released quarantined content %s of title %d (%s version)", entry.ID, entry.TitleNumber, outcome)
+	return content, nil
+}
+
+// DiscardQuarantinedContent marks quarantined content as rejected. Its blob
+// is kept, since identical content may be referenced elsewhere.
+func DiscardQuarantinedContent(id uuid.UUID) (*models.QuarantinedContent, error) {
+	entry, err := pendingQuarantineEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := reviewQuarantineEntry(entry, QuarantineDiscarded); err != nil {
+		return nil, err
+	}
+	log.Printf("[CONTENT] Discarded quarantined content %s of title %d", entry.ID, entry.TitleNumber)
+	return entry, nil
+}
+
+func pendingQuarantineEntry(id uuid.UUID) (*models.QuarantinedContent, error) {
+	var entries []models.QuarantinedContent
+	if err := database.DB.Where("id = ?", id).Limit(1).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to load quarantined content: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrQuarantineNotFound
+	}
+	if entries[0].Status != QuarantinePending {
+		return nil, ErrQuarantineReviewed
+	}
+	return &entries[0], nil
+}
+
+func reviewQuarantineEntry(entry *models.QuarantinedContent, status string) error {
+	reviewedAt := time.Now().UTC()
+	result := database.DB.Model(entry).
+		Where("status = ?", QuarantinePending).
+		Updates(map[string]interface{}{"status": status, "reviewed_at": reviewedAt})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update quarantined content: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrQuarantineReviewed
+	}
+	entry.Status = status
+	entry.ReviewedAt = &reviewedAt
+	return nil
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outcomes of storing downloaded content as a title content version
+const (
+	contentVersionCreated   = "created"
+	contentVersionReplaced  = "replaced"
+	contentVersionUnchanged = "unchanged"
+)
+
+// ErrContentUnchanged is returned when downloaded content has the checksum of
+// the version already in effect, so no version is created or replaced.
+var ErrContentUnchanged = errors.New("downloaded content is identical to the stored version")
+
+// effectiveContentDate is the date content downloaded now is in effect from:
+// the title's up_to_date_as_of as published by the eCFR, or today when it is
+// not reported.
+func effectiveContentDate(fetched models.Title) time.Time {
+	if fetched.UpToDateAsOf != nil {
+		return fetched.UpToDateAsOf.UTC().Truncate(24 * time.Hour)
+	}
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// storedContentVersions returns, for every title, the effective dates whose
+// content and structure are both stored.
+func storedContentVersions() (map[uuid.UUID]map[string]bool, error) {
+	var rows []struct {
+		TitleID     uuid.UUID
+		ContentDate time.Time
+	}
+	err := database.DB.Raw(`
+		SELECT tc.title_id, tc.content_date
+		FROM title_contents tc
+		WHERE EXISTS (SELECT 1 FROM structure_nodes sn WHERE sn.title_content_id = tc.id)
+	`).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[uuid.UUID]map[string]bool)
+	for _, row := range rows {
+		if stored[row.TitleID] == nil {
+			stored[row.TitleID] = make(map[string]bool)
+		}
+		stored[row.TitleID][row.ContentDate.Format("2006-01-02")] = true
+	}
+	return stored, nil
+}
+
+// contentVersion is the stored version a download is compared to.
+type contentVersion struct {
+	ID           uuid.UUID
+	ContentDate  time.Time
+	Checksum     *string
+	HasStructure bool
+}
+
+// storeContentVersion stores downloaded content as the version of its title
+// in effect from content.ContentDate. Versions are only added when the content
+// changes: if the version in effect on that date has the same checksum, only
+// its validators are refreshed and content is pointed at it. A version already
+// stored for the same date with a different checksum is replaced. The
+// returned outcome tells the caller whether the structure must be (re)built;
+// unchanged content whose structure was never stored is reported as replaced.
+func storeContentVersion(content *models.TitleContent) (string, error) {
+	outcome := contentVersionCreated
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var versions []contentVersion
+		err := tx.Raw(`
+			SELECT
+				tc.id,
+				tc.content_date,
+				tc.checksum,
+				EXISTS (SELECT 1 FROM structure_nodes sn WHERE sn.title_content_id = tc.id) AS has_structure
+			FROM title_contents tc
+			WHERE tc.title_id = ? AND tc.content_date <= ?
+			ORDER BY tc.content_date DESC
+			LIMIT 1
+		`, content.TitleID, content.ContentDate).Scan(&versions).Error
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return tx.Create(content).Error
+		}
+
+		current := versions[0]
+		// Validators are kept when the same content came from a strategy
+		// without them
+		updates := make(map[string]interface{})
+		if content.ETag != nil || content.LastModified != nil {
+			updates["etag"] = content.ETag
+			updates["last_modified"] = content.LastModified
+		}
+		sameDay := current.ContentDate.Format("2006-01-02") == content.ContentDate.Format("2006-01-02")
+		sameContent := current.Checksum != nil && *current.Checksum == *content.Checksum
+		switch {
+		case sameContent:
+			outcome = contentVersionUnchanged
+			if !current.HasStructure {
+				outcome = contentVersionReplaced
+			}
+		case sameDay:
+			outcome = contentVersionReplaced
+			updates["checksum"] = content.Checksum
+			updates["word_count"] = content.WordCount
+			updates["restriction_count"] = content.RestrictionCount
+			updates["size_bytes"] = content.SizeBytes
+			updates["etag"] = content.ETag
+			updates["last_modified"] = content.LastModified
+		default:
+			return tx.Create(content).Error
+		}
+
+		content.ID = current.ID
+		content.ContentDate = current.ContentDate
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&models.TitleContent{}).Where("id = ?", current.ID).Updates(updates).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store content version: %w", err)
+	}
+	return outcome, nil
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Section change kinds reported by the diff service.
+const (
+	SectionAdded    = "added"
+	SectionRemoved  = "removed"
+	SectionModified = "modified"
+)
+
+// ErrContentNotFound is returned when no stored content version is in effect
+// on a requested date.
+var ErrContentNotFound = errors.New("no title content found for date")
+
+type SectionChange struct {
+	Type        string      `json:"type"`
+	Identifier  string      `json:"identifier"`
+	Heading     string      `json:"heading"`
+	Part        string      `json:"part,omitempty"`
+	Change      string      `json:"change"`
+	WordsBefore int         `json:"wordsBefore"`
+	WordsAfter  int         `json:"wordsAfter"`
+	WordDelta   int         `json:"wordDelta"`
+	TextDiff    []DiffChunk `json:"textDiff,omitempty"`
+}
+
+type TitleDiff struct {
+	TitleNumber  int             `json:"titleNumber"`
+	FromDate     string          `json:"fromDate"`
+	ToDate       string          `json:"toDate"`
+	FromChecksum *string         `json:"fromChecksum,omitempty"`
+	ToChecksum   *string         `json:"toChecksum,omitempty"`
+	Added        int             `json:"added"`
+	Removed      int             `json:"removed"`
+	Modified     int             `json:"modified"`
+	WordDelta    int             `json:"wordDelta"`
+	Sections     []SectionChange `json:"sections"`
+}
+
+type DiffService struct{}
+
+func NewDiffService() *DiffService {
+	return &DiffService{}
+}
+
+// DiffTitle compares the content versions of a title in effect on two dates
+// section by section. Word-level text diffs are only included when
+// includeText is set.
+func (d *DiffService) DiffTitle(ctx context.Context, titleNumber int, from, to time.Time, includeText bool) (*TitleDiff, error) {
+	var title models.Title
+	if err := database.DB.Where("number = ?", titleNumber).First(&title).Error; err != nil {
+		return nil, fmt.Errorf("failed to find title %d: %w", titleNumber, err)
+	}
+
+	fromContent, err := contentInEffect(title.ID, from)
+	if err != nil {
+		return nil, err
+	}
+	toContent, err := contentInEffect(title.ID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &TitleDiff{
+		TitleNumber:  titleNumber,
+		FromDate:     fromContent.ContentDate.Format("2006-01-02"),
+		ToDate:       toContent.ContentDate.Format("2006-01-02"),
+		FromChecksum: fromContent.Checksum,
+		ToChecksum:   toContent.Checksum,
+		Sections:     []SectionChange{},
+	}
+
+	// Same version (or identical bytes) means nothing changed
+	if fromContent.ID == toContent.ID ||
+		(fromContent.Checksum != nil && toContent.Checksum != nil && *fromContent.Checksum == *toContent.Checksum) {
+		return diff, nil
+	}
+
+	log.Printf("[DIFF] Comparing title %d %s -> %s", titleNumber, diff.FromDate, diff.ToDate)
+
+	before, err := collectSections(ctx, fromContent)
+	if err != nil {
+		return nil, err
+	}
+	after, err := collectSections(ctx, toContent)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, old := range before {
+		updated, exists := after[key]
+		if !exists {
+			diff.Sections = append(diff.Sections, sectionChange(old, SectionRemoved, old.WordCount, 0, nil))
+			continue
+		}
+		if old.Checksum == updated.Checksum {
+			continue
+		}
+		var textDiff []DiffChunk
+		if includeText {
+			textDiff = DiffWords(old.Text, updated.Text)
+		}
+		diff.Sections = append(diff.Sections, sectionChange(updated, SectionModified, old.WordCount, updated.WordCount, textDiff))
+	}
+	for key, added := range after {
+		if _, exists := before[key]; exists {
+			continue
+		}
+		var textDiff []DiffChunk
+		if includeText {
+			textDiff = DiffWords("", added.Text)
+		}
+		diff.Sections = append(diff.Sections, sectionChange(added, SectionAdded, 0, added.WordCount, textDiff))
+	}
+
+	for _, change := range diff.Sections {
+		switch change.Change {
+		case SectionAdded:
+			diff.Added++
+		case SectionRemoved:
+			diff.Removed++
+		case SectionModified:
+			diff.Modified++
+		}
+		diff.WordDelta += change.WordDelta
+	}
+
+	sort.Slice(diff.Sections, func(i, j int) bool {
+		if diff.Sections[i].Part != diff.Sections[j].Part {
+			return compareIdentifiers(diff.Sections[i].Part, diff.Sections[j].Part) < 0
+		}
+		return compareIdentifiers(diff.Sections[i].Identifier, diff.Sections[j].Identifier) < 0
+	})
+
+	return diff, nil
+}
+
+// contentInEffect returns the newest content version dated on or before date.
+func contentInEffect(titleID uuid.UUID, date time.Time) (*models.TitleContent, error) {
+	var content models.TitleContent
+	err := database.DB.Where("title_id = ? AND content_date <= ?", titleID, date).
+		Order("content_date DESC").
+		First(&content).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrContentNotFound, date.Format("2006-01-02"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// collectSections parses a content version and returns its sections and
+// appendices keyed by type and identifier.
+func collectSections(ctx context.Context, content *models.TitleContent) (map[string]*ParsedNode, error) {
+	xml, err := OpenTitleXML(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	defer xml.Close()
+
+	sections := make(map[string]*ParsedNode)
+	err = ParseTitleStructure(xml, func(node *ParsedNode) error {
+		if node.Type != NodeTypeSection && node.Type != NodeTypeAppendix {
+			return nil
+		}
+		sections[node.Type+":"+node.Part+":"+node.Identifier] = node
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+func sectionChange(node *ParsedNode, change string, wordsBefore, wordsAfter int, textDiff []DiffChunk) SectionChange {
+	return SectionChange{
+		Type:        node.Type,
+		Identifier:  node.Identifier,
+		Heading:     node.Heading,
+		Part:        node.Part,
+		Change:      change,
+		WordsBefore: wordsBefore,
+		WordsAfter:  wordsAfter,
+		WordDelta:   wordsAfter - wordsBefore,
+		TextDiff:    textDiff,
+	}
+}
+
+// compareIdentifiers orders CFR identifiers such as "60.2" before "60.10" by
+// comparing their dot-separated numeric components numerically.
+func compareIdentifiers(a, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		if partsA[i] == partsB[i] {
+			continue
+		}
+		numA, errA := parseLeadingInt(partsA[i])
+		numB, errB := parseLeadingInt(partsB[i])
+		if errA == nil && errB == nil && numA != numB {
+			if numA < numB {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(partsA[i], partsB[i])
+	}
+	return len(partsA) - len(partsB)
+}
+
+func parseLeadingInt(value string) (int, error) {
+	end := 0
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return 0, fmt.Errorf("no leading number in %q", value)
+	}
+	number := 0
+	for _, digit := range value[:end] {
+		number = number*10 + int(digit-'0')
+	}
+	return number, nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,11 +12,13 @@ import (
 
 const (
 	BaseURL = "https://www.ecfr.gov"
+	// Timeout is how long an upstream attempt may wait for response headers
 	Timeout = 30 * time.Second
 )
 
 type ECFRClient struct {
-	client *http.Client
+	client  *http.Client
+	baseURL string
 }
 
 type AgencyData struct {
@@ -25,8 +28,10 @@ type AgencyData struct {
 	ParentID  string `json:"parent_id"`
 	Children  []AgencyData `json:"children"`
 	CFRReferences []struct {
-		Title   int    `json:"title"`
-		Chapter string `json:"chapter"`
+		Title      int    `json:"title"`
+		Chapter    string `json:"chapter"`
+		Subchapter string `json:"subchapter"`
+		Part       string `json:"part"`
 	} `json:"cfr_references"`
 }
 
@@ -46,26 +51,81 @@ type TitleResponse struct {
 }
 
 type TitleStructure struct {
-	Identifier string `json:"identifier"`
-	Label      string `json:"label"`
-	Size       int    `json:"size"`
-	Type       string `json:"type"`
-	Reserved   bool   `json:"reserved"`
+	Identifier string           `json:"identifier"`
+	Label      string           `json:"label"`
+	Size       int              `json:"size"`
+	Type       string           `json:"type"`
+	Reserved   bool             `json:"reserved"`
+	Children   []TitleStructure `json:"children"`
+}
+
+// TitleVersion is one entry of the versioner versions list: a section or
+// appendix that changed on Date.
+type TitleVersion struct {
+	Date          string  `json:"date"`
+	AmendmentDate string  `json:"amendment_date"`
+	IssueDate     string  `json:"issue_date"`
+	Identifier    string  `json:"identifier"`
+	Name          string  `json:"name"`
+	Part          string  `json:"part"`
+	Subpart       *string `json:"subpart"`
+	Substantive   bool    `json:"substantive"`
+	Removed       bool    `json:"removed"`
+	Type          string  `json:"type"`
+}
+
+type TitleVersionsResponse struct {
+	ContentVersions []TitleVersion `json:"content_versions"`
 }
 
 func NewECFRClient() *ECFRClient {
+	return NewECFRClientWithConfig(DefaultUpstreamConfig())
+}
+
+// NewECFRClientWithConfig creates a client for the eCFR API at
+// config.ECFRBaseURL using config.Transport for all requests.
+func NewECFRClientWithConfig(config UpstreamConfig) *ECFRClient {
 	return &ECFRClient{
+		// Timeouts and retries are handled per attempt by the transport
 		client: &http.Client{
-			Timeout: Timeout,
+			Transport: config.transport(),
 		},
+		baseURL: config.ECFRBaseURL,
+	}
+}
+
+// get issues a GET request that is aborted when ctx is cancelled.
+func (c *ECFRClient) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// Probe checks that the API answers a HEAD request for the titles list.
+func (c *ECFRClient) Probe(ctx context.Context) error {
+	url := fmt.Sprintf("%s/api/versioner/v1/titles.json", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("eCFR API probe failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("eCFR API probe returned status %d", resp.StatusCode)
 	}
+	return nil
 }
 
-func (c *ECFRClient) FetchAgencies() (*AgencyResponse, error) {
-	url := fmt.Sprintf("%s/api/admin/v1/agencies.json", BaseURL)
+func (c *ECFRClient) FetchAgencies(ctx context.Context) (*AgencyResponse, error) {
+	url := fmt.Sprintf("%s/api/admin/v1/agencies.json", c.baseURL)
 	log.Printf("[ECFR_CLIENT] Fetching agencies from: %s", url)
 	
-	resp, err := c.client.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
 		log.Printf("[ECFR_CLIENT] Failed to fetch agencies: %v", err)
 		return nil, fmt.Errorf("failed to fetch agencies: %w", err)
@@ -93,11 +153,11 @@ func (c *ECFRClient) FetchAgencies() (*AgencyResponse, error) {
 	return &agencies, nil
 }
 
-func (c *ECFRClient) FetchTitles() (*TitleResponse, error) {
-	url := fmt.Sprintf("%s/api/versioner/v1/titles.json", BaseURL)
+func (c *ECFRClient) FetchTitles(ctx context.Context) (*TitleResponse, error) {
+	url := fmt.Sprintf("%s/api/versioner/v1/titles.json", c.baseURL)
 	log.Printf("[ECFR_CLIENT] Fetching titles from: %s", url)
 	
-	resp, err := c.client.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
 		log.Printf("[ECFR_CLIENT] Failed to fetch titles: %v", err)
 		return nil, fmt.Errorf("failed to fetch titles: %w", err)
@@ -125,39 +185,36 @@ func (c *ECFRClient) FetchTitles() (*TitleResponse, error) {
 	return &titles, nil
 }
 
-func (c *ECFRClient) FetchTitleContent(titleNumber int, date string) (string, error) {
+// OpenTitleContent starts downloading the full XML of a title on a date
+// (today if empty) and returns the response body. The caller must close it.
+func (c *ECFRClient) OpenTitleContent(ctx context.Context, titleNumber int, date string) (io.ReadCloser, error) {
 	if date == "" {
 		date = time.Now().Format("2006-01-02")
 	}
 	
-	url := fmt.Sprintf("%s/api/versioner/v1/full/%s/title-%d.xml", BaseURL, date, titleNumber)
+	url := fmt.Sprintf("%s/api/versioner/v1/full/%s/title-%d.xml", c.baseURL, date, titleNumber)
 	
-	resp, err := c.client.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch title %d content: %w", titleNumber, err)
+		return nil, fmt.Errorf("failed to fetch title %d content: %w", titleNumber, err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code for title %d: %d", titleNumber, resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read title %d content: %w", titleNumber, err)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code for title %d: %d", titleNumber, resp.StatusCode)
 	}
 
-	return string(body), nil
+	return resp.Body, nil
 }
 
-func (c *ECFRClient) FetchTitleStructure(titleNumber int, date string) (*TitleStructure, error) {
+func (c *ECFRClient) FetchTitleStructure(ctx context.Context, titleNumber int, date string) (*TitleStructure, error) {
 	if date == "" {
 		date = time.Now().Format("2006-01-02")
 	}
 	
-	url := fmt.Sprintf("%s/api/versioner/v1/structure/%s/title-%d.json", BaseURL, date, titleNumber)
+	url := fmt.Sprintf("%s/api/versioner/v1/structure/%s/title-%d.json", c.baseURL, date, titleNumber)
 	
-	resp, err := c.client.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch title %d structure: %w", titleNumber, err)
 	}
@@ -178,4 +235,27 @@ func (c *ECFRClient) FetchTitleStructure(titleNumber int, date string) (*TitleSt
 	}
 
 	return &structure, nil
-}
\ No newline at end of file
+}
+
+// FetchTitleVersions lists every dated change to the sections and appendices
+// of a title.
+func (c *ECFRClient) FetchTitleVersions(ctx context.Context, titleNumber int) (*TitleVersionsResponse, error) {
+	url := fmt.Sprintf("%s/api/versioner/v1/versions/title-%d.json", c.baseURL, titleNumber)
+	
+	resp, err := c.get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch title %d versions: %w", titleNumber, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code for title %d versions: %d", titleNumber, resp.StatusCode)
+	}
+
+	var versions TitleVersionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal title %d versions: %w", titleNumber, err)
+	}
+
+	return &versions, nil
+}
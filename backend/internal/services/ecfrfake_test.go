@@ -0,0 +1,130 @@
+package services_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ecfr-analyzer/internal/blobstore"
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/ecfrfake"
+)
+
+// fixtureChecksum returns the hex SHA-256 of a bundled fixture, which is the
+// checksum stored for content downloaded from it.
+func fixtureChecksum(t *testing.T, name string) string {
+	t.Helper()
+	data, err := fs.ReadFile(ecfrfake.Fixtures(), name)
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recentFixtures returns the bundled fixtures with the dates of the title XML
+// files moved, keeping their spacing, so the newest is a month old and all of
+// them fall within the two years the historical import looks back. shifted
+// maps each bundled date to its moved one.
+func recentFixtures(t *testing.T) (fixtures fs.FS, shifted map[string]string) {
+	t.Helper()
+	bundled := ecfrfake.Fixtures()
+	files, err := fs.Glob(bundled, "titles/*/*.xml")
+	if err != nil {
+		t.Fatalf("failed to list title fixtures: %v", err)
+	}
+
+	newest := ""
+	for _, file := range files {
+		if date := strings.TrimSuffix(path.Base(file), ".xml"); date > newest {
+			newest = date
+		}
+	}
+	newestDate, err := time.Parse("2006-01-02", newest)
+	if err != nil {
+		t.Fatalf("invalid fixture date %q: %v", newest, err)
+	}
+	target := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, -1, 0)
+	offset := target.Sub(newestDate)
+
+	mapped := fstest.MapFS{}
+	shifted = make(map[string]string)
+	for _, name := range []string{"agencies.json", "titles.json"} {
+		data, err := fs.ReadFile(bundled, name)
+		if err != nil {
+			t.Fatalf("failed to read fixture %s: %v", name, err)
+		}
+		mapped[name] = &fstest.MapFile{Data: data}
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(bundled, file)
+		if err != nil {
+			t.Fatalf("failed to read fixture %s: %v", file, err)
+		}
+		date := strings.TrimSuffix(path.Base(file), ".xml")
+		parsed, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			t.Fatalf("invalid fixture date %q: %v", date, err)
+		}
+		shifted[date] = parsed.Add(offset).Format("2006-01-02")
+		mapped[path.Join(path.Dir(file), shifted[date]+".xml")] = &fstest.MapFile{Data: data}
+	}
+	return mapped, shifted
+}
+
+// newFakeECFR starts a fake eCFR server on the recent fixtures and stops it
+// when the test ends.
+func newFakeECFR(t *testing.T) (*ecfrfake.Server, map[string]string) {
+	t.Helper()
+	fixtures, shifted := recentFixtures(t)
+	server := ecfrfake.NewServerWithFixtures(fixtures)
+	t.Cleanup(server.Close)
+	return server, shifted
+}
+
+// openTestDatabase connects to the database named by TEST_DB_NAME, using the
+// other DB_* variables like the server does, and empties every table in it.
+// Blobs are stored in a temporary directory. Tests are skipped when
+// TEST_DB_NAME is not set, so they never touch the database of a running
+// server by accident.
+func openTestDatabase(t *testing.T) {
+	t.Helper()
+	name := os.Getenv("TEST_DB_NAME")
+	if name == "" {
+		t.Skip("TEST_DB_NAME is not set; skipping test that needs Postgres")
+	}
+	t.Setenv("DB_NAME", name)
+	if err := database.Connect(); err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() {
+		database.Close()
+	})
+
+	var tables []string
+	err := database.DB.Raw("SELECT tablename FROM pg_tables WHERE schemaname = current_schema()").Scan(&tables).Error
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	if len(tables) > 0 {
+		if err := database.DB.Exec("TRUNCATE " + strings.Join(tables, ", ") + " CASCADE").Error; err != nil {
+			t.Fatalf("failed to empty test database: %v", err)
+		}
+	}
+
+	store, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+	previous := blobstore.Default
+	blobstore.Default = store
+	t.Cleanup(func() {
+		blobstore.Default = previous
+	})
+}
@@ -1,13 +1,19 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
 	"ecfr-analyzer/internal/database"
 	"ecfr-analyzer/internal/models"
+	"gorm.io/gorm"
 )
 
 type HistoricalService struct {
@@ -15,8 +21,12 @@ type HistoricalService struct {
 }
 
 func NewHistoricalService() *HistoricalService {
+	return NewHistoricalServiceWithConfig(DefaultUpstreamConfig())
+}
+
+func NewHistoricalServiceWithConfig(config UpstreamConfig) *HistoricalService {
 	return &HistoricalService{
-		client: NewECFRClient(),
+		client: NewECFRClientWithConfig(config),
 	}
 }
 
@@ -24,7 +34,8 @@ func NewHistoricalService() *HistoricalService {
 func (h *HistoricalService) CaptureSnapshot() error {
 	log.Println("Starting historical snapshot capture...")
 	
-	// Find the latest content date from title_contents table
+	// Titles are versioned by their own effective dates; the snapshot is
+	// dated by the newest one and covers the latest version of every title
 	var latestContentDate time.Time
 	err := database.DB.Table("title_contents").
 		Select("MAX(content_date)").
@@ -57,82 +68,84 @@ func (h *HistoricalService) CaptureSnapshot() error {
 	return nil
 }
 
-// captureOverallSnapshot captures total CFR word count
+// captureOverallSnapshot captures total CFR word and restriction count
 func (h *HistoricalService) captureOverallSnapshot(snapshotDate time.Time) error {
-	var totalWords int64
+	var totals struct {
+		WordCount        int64
+		RestrictionCount *int
+	}
 	
-	// Sum all word counts from title_contents for today's date
+	// Sum the counts of the version of each title in effect
 	err := database.DB.Table("title_contents").
-		Where("content_date = ?", snapshotDate).
-		Select("COALESCE(SUM(word_count), 0)").
-		Scan(&totalWords).Error
+		Where("id IN (SELECT id FROM title_contents_as_of(NULL))").
+		Select("COALESCE(SUM(word_count), 0) AS word_count, SUM(restriction_count) AS restriction_count").
+		Scan(&totals).Error
 	if err != nil {
 		return err
 	}
+	totalWords := totals.WordCount
 	
 	log.Printf("Overall snapshot: %d total words", totalWords)
 	
 	// Create overall snapshot (no agency_id or title_id)
+	method := MeasurementFullText
 	snapshot := &models.HistoricalSnapshot{
-		SnapshotDate: snapshotDate,
-		WordCount:    &[]int{int(totalWords)}[0],
+		SnapshotDate:      snapshotDate,
+		WordCount:         &[]int{int(totalWords)}[0],
+		RestrictionCount:  totals.RestrictionCount,
+		MeasurementMethod: &method,
 	}
 	
 	// Upsert snapshot
-	err = database.DB.Where("snapshot_date = ? AND agency_id IS NULL AND title_id IS NULL", 
-		snapshotDate).FirstOrCreate(snapshot).Error
-	if err != nil {
+	if err := storeSnapshot(snapshot); err != nil {
 		return err
 	}
 	
 	return nil
 }
 
-// captureAgencySnapshots captures word counts per agency
+// captureAgencySnapshots captures word counts per agency, in total and for
+// each title the agency's content is in
 func (h *HistoricalService) captureAgencySnapshots(snapshotDate time.Time) error {
 	log.Println("Capturing per-agency snapshots...")
 	
-	// Query to get word count per agency
-	type AgencyWordCount struct {
-		AgencyID  uuid.UUID
-		WordCount int64
+	// Query to get word count per agency and title
+	type AgencyTitleWordCount struct {
+		AgencyID         uuid.UUID
+		TitleID          uuid.UUID
+		WordCount        int64
+		RestrictionCount *int
 	}
 	
-	var agencyWordCounts []AgencyWordCount
+	var agencyWordCounts []AgencyTitleWordCount
 	
-	// Join agencies -> agency_cfr_references -> titles -> title_contents
-	// Sum word counts per agency
+	// Sum the chapters, subchapters and parts each agency owns
 	err := database.DB.Table("agencies a").
-		Select("a.id as agency_id, COALESCE(SUM(tc.word_count), 0) as word_count").
-		Joins("LEFT JOIN agency_cfr_references acr ON a.id = acr.agency_id").
-		Joins("LEFT JOIN title_contents tc ON acr.title_id = tc.title_id AND tc.content_date = ?", snapshotDate).
-		Group("a.id").
+		Select("a.id as agency_id, aca.title_id, COALESCE(SUM(aca.word_count), 0) as word_count, SUM(aca.restriction_count) as restriction_count").
+		Joins("JOIN agency_content_attribution_as_of(NULL) aca ON a.id = aca.agency_id").
+		Where("a.deleted_at IS NULL").
+		Group("a.id, aca.title_id").
 		Scan(&agencyWordCounts).Error
 	if err != nil {
 		return err
 	}
 	
-	log.Printf("Found %d agencies to snapshot", len(agencyWordCounts))
-	
-	// Create snapshots for each agency
+	titleSizes := make(map[uuid.UUID]map[uuid.UUID]int)
+	titleRestrictions := make(map[uuid.UUID]map[uuid.UUID]*int)
 	for _, awc := range agencyWordCounts {
-		if awc.WordCount == 0 {
-			continue // Skip agencies with no content
-		}
-		
-		snapshot := &models.HistoricalSnapshot{
-			SnapshotDate: snapshotDate,
-			AgencyID:     &awc.AgencyID,
-			WordCount:    &[]int{int(awc.WordCount)}[0],
-		}
-		
-		// Upsert snapshot
-		err = database.DB.Where("snapshot_date = ? AND agency_id = ? AND title_id IS NULL", 
-			snapshotDate, awc.AgencyID).FirstOrCreate(snapshot).Error
-		if err != nil {
-			log.Printf("Error creating agency snapshot for %s: %v", awc.AgencyID, err)
-			continue
+		if titleSizes[awc.AgencyID] == nil {
+			titleSizes[awc.AgencyID] = make(map[uuid.UUID]int)
+			titleRestrictions[awc.AgencyID] = make(map[uuid.UUID]*int)
 		}
+		titleSizes[awc.AgencyID][awc.TitleID] = int(awc.WordCount)
+		titleRestrictions[awc.AgencyID][awc.TitleID] = awc.RestrictionCount
+	}
+	
+	log.Printf("Found %d agencies to snapshot", len(titleSizes))
+	
+	// Create snapshots for each agency
+	for agencyID, sizes := range titleSizes {
+		storeAgencySnapshots(snapshotDate, agencyID, sizes, titleRestrictions[agencyID], MeasurementFullText)
 	}
 	
 	return nil
@@ -142,9 +155,9 @@ func (h *HistoricalService) captureAgencySnapshots(snapshotDate time.Time) error
 func (h *HistoricalService) captureTitleSnapshots(snapshotDate time.Time) error {
 	log.Println("Capturing per-title snapshots...")
 	
-	// Get all title contents for today
+	// Get the version of each title in effect
 	var titleContents []models.TitleContent
-	err := database.DB.Where("content_date = ?", snapshotDate).Find(&titleContents).Error
+	err := database.DB.Raw("SELECT * FROM title_contents_as_of(NULL)").Scan(&titleContents).Error
 	if err != nil {
 		return err
 	}
@@ -157,17 +170,18 @@ func (h *HistoricalService) captureTitleSnapshots(snapshotDate time.Time) error
 			continue // Skip titles with no word count
 		}
 		
+		method := MeasurementFullText
 		snapshot := &models.HistoricalSnapshot{
-			SnapshotDate: snapshotDate,
-			TitleID:      &tc.TitleID,
-			WordCount:    tc.WordCount,
-			Checksum:     tc.Checksum,
+			SnapshotDate:      snapshotDate,
+			TitleID:           &tc.TitleID,
+			WordCount:         tc.WordCount,
+			RestrictionCount:  tc.RestrictionCount,
+			Checksum:          tc.Checksum,
+			MeasurementMethod: &method,
 		}
 		
 		// Upsert snapshot
-		err = database.DB.Where("snapshot_date = ? AND title_id = ? AND agency_id IS NULL", 
-			snapshotDate, tc.TitleID).FirstOrCreate(snapshot).Error
-		if err != nil {
+		if err := storeSnapshot(snapshot); err != nil {
 			log.Printf("Error creating title snapshot for %s: %v", tc.TitleID, err)
 			continue
 		}
@@ -176,9 +190,51 @@ func (h *HistoricalService) captureTitleSnapshots(snapshotDate time.Time) error
 	return nil
 }
 
-// ImportHistoricalData imports historical data from eCFR API for the past 2 years
-func (h *HistoricalService) ImportHistoricalData() error {
-	log.Println("Starting historical data import from eCFR API...")
+// HistoricalMode selects how historical word counts are measured.
+type HistoricalMode string
+
+const (
+	// HistoricalModeEstimate estimates words as structure size / 5, which
+	// needs one small structure request per title and date.
+	HistoricalModeEstimate HistoricalMode = "estimate"
+	// HistoricalModeFullText downloads the full XML of every title for each
+	// date and counts words the same way as current content.
+	HistoricalModeFullText HistoricalMode = "full-text"
+)
+
+// Measurement methods stored on HistoricalSnapshot.MeasurementMethod
+const (
+	MeasurementFullText          = "full_text"
+	MeasurementStructureEstimate = "structure_estimate"
+)
+
+func (m HistoricalMode) measurementMethod() string {
+	if m == HistoricalModeFullText {
+		return MeasurementFullText
+	}
+	return MeasurementStructureEstimate
+}
+
+// ParseHistoricalMode validates a mode name, defaulting to the estimate mode.
+func ParseHistoricalMode(mode string) (HistoricalMode, error) {
+	switch HistoricalMode(mode) {
+	case "", HistoricalModeEstimate:
+		return HistoricalModeEstimate, nil
+	case HistoricalModeFullText:
+		return HistoricalModeFullText, nil
+	}
+	return "", fmt.Errorf("unknown historical mode %q", mode)
+}
+
+// ImportHistoricalData captures snapshots on every date within the past 2 years
+// on which a title was amended, according to the versioner versions list.
+// Dates that already have snapshots are skipped. All titles are measured on
+// the first date that has none yet, and again after any skipped date; on the
+// following dates only the titles amended on them are measured again and the
+// others carry their previous values forward.
+// In full-text mode existing estimates are replaced with real word counts.
+func (h *HistoricalService) ImportHistoricalData(ctx context.Context, mode HistoricalMode) error {
+	log.Printf("Starting historical data import from eCFR API (mode: %s)...", mode)
 	
 	// Get all active titles from database
 	var titles []models.Title
@@ -189,140 +245,428 @@ func (h *HistoricalService) ImportHistoricalData() error {
 	
 	log.Printf("Found %d active titles to import historical data for", len(titles))
 	
-	// Generate monthly snapshots for the past 24 months
+	if err := h.SyncAmendmentEvents(ctx, titles); err != nil {
+		return err
+	}
+	
 	now := time.Now().UTC()
-	for monthsBack := 1; monthsBack <= 24; monthsBack++ {
-		snapshotDate := now.AddDate(0, -monthsBack, 0)
-		snapshotDate = time.Date(snapshotDate.Year(), snapshotDate.Month(), 1, 0, 0, 0, 0, time.UTC)
-		
-		log.Printf("Processing historical data for %s", snapshotDate.Format("2006-01"))
+	timeline, err := amendmentTimeline(now.AddDate(0, -24, 0), now)
+	if err != nil {
+		return fmt.Errorf("failed to load amendment dates: %w", err)
+	}
+	if len(timeline) == 0 {
+		log.Println("No amendment dates found in the past 24 months")
+		return nil
+	}
+	
+	log.Printf("Found %d amendment dates in the past 24 months", len(timeline))
+	
+	titlesByID := make(map[uuid.UUID]models.Title, len(titles))
+	for _, title := range titles {
+		titlesByID[title.ID] = title
+	}
+	
+	state, err := newTimelineState()
+	if err != nil {
+		return err
+	}
+	
+	baselineMeasured := false
+	for _, point := range timeline {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dateStr := point.Date.Format("2006-01-02")
 		
-		// Skip if we already have data for this month
+		// Skip if we already have data for this date measured at least as well
+		query := database.DB.Model(&models.HistoricalSnapshot{}).Where("snapshot_date = ?", point.Date)
+		if mode == HistoricalModeFullText {
+			query = query.Where("measurement_method = ?", MeasurementFullText)
+		}
 		var existingCount int64
-		database.DB.Model(&models.HistoricalSnapshot{}).Where("snapshot_date = ?", snapshotDate).Count(&existingCount)
+		query.Count(&existingCount)
 		if existingCount > 0 {
-			log.Printf("Skipping %s - data already exists", snapshotDate.Format("2006-01"))
+			log.Printf("Skipping %s - data already exists", dateStr)
+			// The carried measurements miss the amendments of this date
+			baselineMeasured = false
 			continue
 		}
 		
+		// Measure every title on the first date that still needs snapshots
+		// after skipped ones; later dates only need the titles amended on them
+		var amended []models.Title
+		if !baselineMeasured {
+			amended = titles
+			baselineMeasured = true
+		} else {
+			for _, titleID := range point.TitleIDs {
+				if title, ok := titlesByID[titleID]; ok {
+					amended = append(amended, title)
+				}
+			}
+		}
+		
+		log.Printf("Processing historical data for %s (%d titles to measure)", dateStr, len(amended))
+		
 		// Import historical snapshots for this date
-		if err := h.importSnapshotsForDate(titles, snapshotDate); err != nil {
-			log.Printf("Error importing snapshots for %s: %v", snapshotDate.Format("2006-01"), err)
+		if err := h.importSnapshotsForDate(ctx, amended, point.Date, mode, state); err != nil {
+			log.Printf("Error importing snapshots for %s: %v", dateStr, err)
 			continue
 		}
-		
-		// Add delay to avoid overwhelming the API
-		time.Sleep(500 * time.Millisecond)
 	}
 	
 	log.Println("Historical data import completed")
 	return nil
 }
 
-// importSnapshotsForDate imports historical snapshots for a specific date
-func (h *HistoricalService) importSnapshotsForDate(titles []models.Title, snapshotDate time.Time) error {
+// StartHistoricalImport captures a snapshot of the current content and then
+// imports historical data in the background. It holds the import lock while
+// it runs, so it never overlaps an import run; ErrImportInProgress is
+// returned when another import holds it.
+func (h *HistoricalService) StartHistoricalImport(mode HistoricalMode) error {
+	lock, err := database.TryAdvisoryLock(context.Background(), database.ImportLockKey)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return ErrImportInProgress
+	}
+	
+	go func() {
+		defer func() {
+			if err := lock.Release(); err != nil {
+				log.Printf("[HISTORICAL] Failed to release import lock: %v", err)
+			}
+		}()
+		
+		// First capture current snapshot; errors are already logged
+		h.CaptureSnapshot()
+		
+		// Then import historical data from eCFR API
+		if err := h.ImportHistoricalData(context.Background(), mode); err != nil {
+			log.Printf("[HISTORICAL] Historical import failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// timelineState holds the latest measurement of every title while walking the
+// amendment timeline, so totals on a date include titles not amended on it.
+type timelineState struct {
+	// refs groups agency references by title and agency
+	refs        map[uuid.UUID]map[uuid.UUID][]models.AgencyCFRReference
+	wordCounts  map[uuid.UUID]int
+	agencySizes map[uuid.UUID]map[uuid.UUID]int
+	// restrictionCounts only holds titles measured in full text
+	restrictionCounts map[uuid.UUID]int
+}
+
+func newTimelineState() (*timelineState, error) {
+	var refs []models.AgencyCFRReference
+	if err := database.DB.Find(&refs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load agency references: %w", err)
+	}
+	
+	state := &timelineState{
+		refs:        make(map[uuid.UUID]map[uuid.UUID][]models.AgencyCFRReference),
+		wordCounts:  make(map[uuid.UUID]int),
+		agencySizes: make(map[uuid.UUID]map[uuid.UUID]int),
+
+		restrictionCounts: make(map[uuid.UUID]int),
+	}
+	for _, ref := range refs {
+		if state.refs[ref.TitleID] == nil {
+			state.refs[ref.TitleID] = make(map[uuid.UUID][]models.AgencyCFRReference)
+		}
+		state.refs[ref.TitleID][ref.AgencyID] = append(state.refs[ref.TitleID][ref.AgencyID], ref)
+	}
+	return state, nil
+}
+
+// update records a title's measurement and the part of it each agency owns.
+func (s *timelineState) update(titleID uuid.UUID, measurement *titleMeasurement) {
+	s.wordCounts[titleID] = measurement.wordCount
+	if measurement.restrictionCount != nil {
+		s.restrictionCounts[titleID] = *measurement.restrictionCount
+	} else {
+		delete(s.restrictionCounts, titleID)
+	}
+	sizes := make(map[uuid.UUID]int)
+	for agencyID, refs := range s.refs[titleID] {
+		sizes[agencyID] = attributedStructureSize(measurement.structure, refs)
+	}
+	s.agencySizes[titleID] = sizes
+}
+
+func (s *timelineState) totalWords() int64 {
+	total := int64(0)
+	for _, wordCount := range s.wordCounts {
+		total += int64(wordCount)
+	}
+	return total
+}
+
+// totalRestrictions sums the restriction counts of all titles, or returns nil
+// unless every measured title has one.
+func (s *timelineState) totalRestrictions() *int {
+	if len(s.restrictionCounts) == 0 || len(s.restrictionCounts) != len(s.wordCounts) {
+		return nil
+	}
+	total := 0
+	for _, restrictionCount := range s.restrictionCounts {
+		total += restrictionCount
+	}
+	return &total
+}
+
+// agencyTitleSizes returns the attributed size of each agency in each title.
+func (s *timelineState) agencyTitleSizes() map[uuid.UUID]map[uuid.UUID]int {
+	sizes := make(map[uuid.UUID]map[uuid.UUID]int)
+	for titleID, titleSizes := range s.agencySizes {
+		for agencyID, size := range titleSizes {
+			if sizes[agencyID] == nil {
+				sizes[agencyID] = make(map[uuid.UUID]int)
+			}
+			sizes[agencyID][titleID] = size
+		}
+	}
+	return sizes
+}
+
+// titleMeasurement is a title's word count on a historical date together with
+// the structure tree used to attribute it to agencies. Structure sizes are in
+// the same unit as the mode measures (characters for estimates, words for
+// full text). Restriction terms are only counted in full text.
+type titleMeasurement struct {
+	wordCount        int
+	restrictionCount *int
+	checksum         *string
+	structure        *TitleStructure
+}
+
+// measureTitle measures one title on a historical date using the given mode
+func (h *HistoricalService) measureTitle(ctx context.Context, title models.Title, dateStr string, mode HistoricalMode) (*titleMeasurement, error) {
+	if mode == HistoricalModeFullText {
+		body, err := h.client.OpenTitleContent(ctx, title.Number, dateStr)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		
+		// Hash and count the XML as the structure parser streams through it
+		hash := sha256.New()
+		words := newWordCounter()
+		restrictions := newRestrictionCounter()
+		tee := io.TeeReader(body, io.MultiWriter(hash, words, restrictions))
+		structure, err := BuildTitleStructure(tee, func(node *ParsedNode) int {
+			return countWords(node.Text)
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The parser stops at the end of the root element
+		if _, err := io.Copy(io.Discard, tee); err != nil {
+			return nil, err
+		}
+		checksum := hex.EncodeToString(hash.Sum(nil))
+		restrictionCount := RestrictionTotal(restrictions.Counts())
+		return &titleMeasurement{
+			wordCount:        words.Count(),
+			restrictionCount: &restrictionCount,
+			checksum:         &checksum,
+			structure:        structure,
+		}, nil
+	}
+	
+	// Fetch historical structure data from eCFR API
+	structure, err := h.client.FetchTitleStructure(ctx, title.Number, dateStr)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Estimate word count from character count (roughly 5 chars per word)
+	return &titleMeasurement{
+		wordCount: structure.Size / 5,
+		structure: structure,
+	}, nil
+}
+
+// importSnapshotsForDate measures the given titles on a date and stores their
+// snapshots, then stores overall and agency snapshots from the carried state
+func (h *HistoricalService) importSnapshotsForDate(ctx context.Context, titles []models.Title, snapshotDate time.Time, mode HistoricalMode, state *timelineState) error {
 	dateStr := snapshotDate.Format("2006-01-02")
-	totalWords := int64(0)
+	method := mode.measurementMethod()
 	validTitles := 0
 	
 	log.Printf("Importing historical data for %d titles on %s", len(titles), dateStr)
 	
 	// Process each title
 	for _, title := range titles {
-		// Fetch historical structure data from eCFR API
-		structureData, err := h.client.FetchTitleStructure(title.Number, dateStr)
+		measurement, err := h.measureTitle(ctx, title, dateStr, mode)
+		if ctx.Err() != nil {
+			// A cancelled import must not store partial totals for this date
+			return ctx.Err()
+		}
 		if err != nil {
-			log.Printf("Failed to fetch structure for title %d on %s: %v", title.Number, dateStr, err)
+			log.Printf("Failed to measure title %d on %s: %v", title.Number, dateStr, err)
 			continue
 		}
+		state.update(title.ID, measurement)
 		
-		// Extract size (character count) from structure data
-		charCount := structureData.Size
-		if charCount == 0 {
+		if measurement.wordCount == 0 {
 			continue // Skip titles with no content
 		}
 		
-		// Estimate word count from character count (roughly 5 chars per word)
-		estimatedWordCount := int(charCount / 5)
-		totalWords += int64(estimatedWordCount)
+		wordCount := measurement.wordCount
 		validTitles++
 		
 		// Create title snapshot
+		titleID := title.ID
 		titleSnapshot := &models.HistoricalSnapshot{
-			SnapshotDate: snapshotDate,
-			TitleID:      &title.ID,
-			WordCount:    &estimatedWordCount,
+			SnapshotDate:      snapshotDate,
+			TitleID:           &titleID,
+			WordCount:         &wordCount,
+			RestrictionCount:  measurement.restrictionCount,
+			Checksum:          measurement.checksum,
+			MeasurementMethod: &method,
 		}
 		
 		// Store title snapshot
-		err = database.DB.Where("snapshot_date = ? AND title_id = ? AND agency_id IS NULL",
-			snapshotDate, title.ID).FirstOrCreate(titleSnapshot).Error
-		if err != nil {
+		if err := storeSnapshot(titleSnapshot); err != nil {
 			log.Printf("Error creating title snapshot for %d on %s: %v", title.Number, dateStr, err)
 		}
-		
-		// Small delay to avoid overwhelming API
-		time.Sleep(100 * time.Millisecond)
 	}
 	
-	log.Printf("Processed %d valid titles with %d total estimated words for %s", validTitles, totalWords, dateStr)
+	totalWords := state.totalWords()
+	log.Printf("Measured %d valid titles, %d total words (%s) for %s", validTitles, totalWords, method, dateStr)
 	
 	// Create overall snapshot (total across all titles)
 	if totalWords > 0 {
 		overallSnapshot := &models.HistoricalSnapshot{
-			SnapshotDate: snapshotDate,
-			WordCount:    &[]int{int(totalWords)}[0],
+			SnapshotDate:      snapshotDate,
+			WordCount:         &[]int{int(totalWords)}[0],
+			RestrictionCount:  state.totalRestrictions(),
+			MeasurementMethod: &method,
 		}
 		
-		err := database.DB.Where("snapshot_date = ? AND agency_id IS NULL AND title_id IS NULL",
-			snapshotDate).FirstOrCreate(overallSnapshot).Error
-		if err != nil {
+		if err := storeSnapshot(overallSnapshot); err != nil {
 			log.Printf("Error creating overall snapshot for %s: %v", dateStr, err)
 		}
 	}
 	
-	// Create agency snapshots by aggregating title data
-	return h.createAgencySnapshotsFromTitles(snapshotDate)
+	// Create agency snapshots from the chapters each agency owns
+	return h.createAgencySnapshots(snapshotDate, state, mode)
+}
+
+// createAgencySnapshots creates agency snapshots from the attributed sizes of
+// the referenced chapters, subchapters and parts of each title
+func (h *HistoricalService) createAgencySnapshots(snapshotDate time.Time, state *timelineState, mode HistoricalMode) error {
+	method := mode.measurementMethod()
+	created := 0
+	for agencyID, sizes := range state.agencyTitleSizes() {
+		wordCounts := make(map[uuid.UUID]int, len(sizes))
+		for titleID, size := range sizes {
+			wordCounts[titleID] = size
+			if mode == HistoricalModeEstimate {
+				// Estimate word count from character count (roughly 5 chars per word)
+				wordCounts[titleID] = size / 5
+			}
+		}
+		if storeAgencySnapshots(snapshotDate, agencyID, wordCounts, nil, method) {
+			created++
+		}
+	}
+	
+	log.Printf("Created agency snapshots for %d agencies on %s", created, snapshotDate.Format("2006-01-02"))
+	return nil
 }
 
-// createAgencySnapshotsFromTitles creates agency snapshots by aggregating title snapshots
-func (h *HistoricalService) createAgencySnapshotsFromTitles(snapshotDate time.Time) error {
-	type AgencyWordCount struct {
-		AgencyID  uuid.UUID
-		WordCount int64
+// storeAgencySnapshots stores an agency's share of each title and its total
+// on a date. Point-in-time queries use the share of every title that has no
+// content in effect, like they use the title snapshots; the total is what
+// the agency history charts. restrictionCounts is nil where restriction terms
+// were not counted. It reports whether the agency had any words to store.
+func storeAgencySnapshots(snapshotDate time.Time, agencyID uuid.UUID, wordCounts map[uuid.UUID]int, restrictionCounts map[uuid.UUID]*int, method string) bool {
+	totalWords := 0
+	var totalRestrictions *int
+	if restrictionCounts != nil {
+		totalRestrictions = new(int)
+	}
+	for titleID, wordCount := range wordCounts {
+		totalWords += wordCount
+		restrictionCount := restrictionCounts[titleID]
+		if restrictionCount == nil {
+			totalRestrictions = nil
+		} else if totalRestrictions != nil {
+			*totalRestrictions += *restrictionCount
+		}
+		if wordCount == 0 {
+			continue
+		}
+		
+		agencyID, titleID, wordCount := agencyID, titleID, wordCount
+		snapshot := &models.HistoricalSnapshot{
+			SnapshotDate:      snapshotDate,
+			AgencyID:          &agencyID,
+			TitleID:           &titleID,
+			WordCount:         &wordCount,
+			RestrictionCount:  restrictionCount,
+			MeasurementMethod: &method,
+		}
+		if err := storeSnapshot(snapshot); err != nil {
+			log.Printf("Error creating agency snapshot for %s in title %s on %s: %v", agencyID, titleID, snapshotDate.Format("2006-01-02"), err)
+		}
+	}
+	if totalWords == 0 {
+		return false
 	}
 	
-	var agencyWordCounts []AgencyWordCount
+	snapshot := &models.HistoricalSnapshot{
+		SnapshotDate:      snapshotDate,
+		AgencyID:          &agencyID,
+		WordCount:         &totalWords,
+		RestrictionCount:  totalRestrictions,
+		MeasurementMethod: &method,
+	}
+	if err := storeSnapshot(snapshot); err != nil {
+		log.Printf("Error creating agency snapshot for %s on %s: %v", agencyID, snapshotDate.Format("2006-01-02"), err)
+		return false
+	}
+	return true
+}
+
+// storeSnapshot creates a snapshot for its date, agency and title. An existing
+// snapshot is only replaced by a full-text measurement, so real word counts
+// overwrite estimates but estimates never overwrite anything.
+func storeSnapshot(snapshot *models.HistoricalSnapshot) error {
+	query := database.DB.Where("snapshot_date = ?", snapshot.SnapshotDate)
+	if snapshot.AgencyID != nil {
+		query = query.Where("agency_id = ?", *snapshot.AgencyID)
+	} else {
+		query = query.Where("agency_id IS NULL")
+	}
+	if snapshot.TitleID != nil {
+		query = query.Where("title_id = ?", *snapshot.TitleID)
+	} else {
+		query = query.Where("title_id IS NULL")
+	}
 	
-	// Join agencies -> agency_cfr_references -> historical_snapshots (title-based)
-	// Sum word counts per agency for this snapshot date
-	err := database.DB.Table("agencies a").
-		Select("a.id as agency_id, COALESCE(SUM(hs.word_count), 0) as word_count").
-		Joins("LEFT JOIN agency_cfr_references acr ON a.id = acr.agency_id").
-		Joins("LEFT JOIN historical_snapshots hs ON acr.title_id = hs.title_id AND hs.snapshot_date = ? AND hs.agency_id IS NULL", snapshotDate).
-		Group("a.id").
-		Having("COALESCE(SUM(hs.word_count), 0) > 0").
-		Scan(&agencyWordCounts).Error
+	var existing models.HistoricalSnapshot
+	err := query.First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return database.DB.Create(snapshot).Error
+	}
 	if err != nil {
 		return err
 	}
 	
-	log.Printf("Creating agency snapshots for %d agencies on %s", len(agencyWordCounts), snapshotDate.Format("2006-01-02"))
-	
-	// Create snapshots for each agency
-	for _, awc := range agencyWordCounts {
-		snapshot := &models.HistoricalSnapshot{
-			SnapshotDate: snapshotDate,
-			AgencyID:     &awc.AgencyID,
-			WordCount:    &[]int{int(awc.WordCount)}[0],
-		}
-		
-		err = database.DB.Where("snapshot_date = ? AND agency_id = ? AND title_id IS NULL",
-			snapshotDate, awc.AgencyID).FirstOrCreate(snapshot).Error
-		if err != nil {
-			log.Printf("Error creating agency snapshot for %s on %s: %v", awc.AgencyID, snapshotDate.Format("2006-01-02"), err)
-		}
+	if snapshot.MeasurementMethod == nil || *snapshot.MeasurementMethod != MeasurementFullText {
+		return nil
 	}
-	
-	return nil
-}
\ No newline at end of file
+	return database.DB.Model(&existing).Updates(map[string]interface{}{
+		"word_count":         snapshot.WordCount,
+		"restriction_count":  snapshot.RestrictionCount,
+		"checksum":           snapshot.Checksum,
+		"measurement_method": snapshot.MeasurementMethod,
+	}).Error
+}
@@ -0,0 +1,166 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/services"
+)
+
+func TestHistoricalServiceImportsFromFakeECFR(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       services.HistoricalMode
+		wantMethod string
+		// wantChecksums is whether title snapshots carry the checksum of the
+		// XML in effect on their date, which only full text downloads
+		wantChecksums bool
+	}{
+		{
+			name:       "estimate",
+			mode:       services.HistoricalModeEstimate,
+			wantMethod: services.MeasurementStructureEstimate,
+		},
+		{
+			name:          "full text",
+			mode:          services.HistoricalModeFullText,
+			wantMethod:    services.MeasurementFullText,
+			wantChecksums: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDatabase(t)
+			server, shifted := newFakeECFR(t)
+			ctx := context.Background()
+
+			// A refresh stores the titles and their content without snapshots.
+			// Agencies come second, as references are only stored for titles
+			// that exist
+			importService := services.NewImportServiceWithConfig(server.UpstreamConfig())
+			if err := importService.RefreshTitles(ctx); err != nil {
+				t.Fatalf("RefreshTitles() failed: %v", err)
+			}
+			if err := importService.ImportAgencies(ctx); err != nil {
+				t.Fatalf("ImportAgencies() failed: %v", err)
+			}
+
+			historicalService := services.NewHistoricalServiceWithConfig(server.UpstreamConfig())
+			if err := historicalService.ImportHistoricalData(ctx, tt.mode); err != nil {
+				t.Fatalf("ImportHistoricalData() failed: %v", err)
+			}
+
+			// Every fixture is one amendment date of its title
+			var events []struct {
+				Number int
+				Dates  int
+			}
+			err := database.DB.Raw(`
+				SELECT t.number, COUNT(DISTINCT ae.amendment_date) AS dates
+				FROM amendment_events ae
+				JOIN titles t ON t.id = ae.title_id
+				GROUP BY t.number
+				ORDER BY t.number
+			`).Scan(&events).Error
+			if err != nil {
+				t.Fatalf("failed to load amendment events: %v", err)
+			}
+			if len(events) != 2 || events[0].Number != 1 || events[0].Dates != 2 || events[1].Number != 40 || events[1].Dates != 1 {
+				t.Errorf("amendment dates per title = %+v, want 2 of title 1 and 1 of title 40", events)
+			}
+
+			// Titles are measured on the dates they were amended
+			wantTitleSnapshots := []struct {
+				number  int
+				date    string
+				fixture string
+			}{
+				{1, "2024-01-01", "titles/title-1/2024-01-01.xml"},
+				{40, "2024-03-15", "titles/title-40/2024-03-15.xml"},
+				{1, "2024-06-01", "titles/title-1/2024-06-01.xml"},
+			}
+			var titleSnapshots []struct {
+				Number            int
+				SnapshotDate      string
+				WordCount         *int
+				Checksum          *string
+				MeasurementMethod string
+			}
+			err = database.DB.Raw(`
+				SELECT t.number, to_char(hs.snapshot_date, 'YYYY-MM-DD') AS snapshot_date,
+					hs.word_count, hs.checksum, hs.measurement_method
+				FROM historical_snapshots hs
+				JOIN titles t ON t.id = hs.title_id
+				WHERE hs.agency_id IS NULL
+				ORDER BY hs.snapshot_date, t.number
+			`).Scan(&titleSnapshots).Error
+			if err != nil {
+				t.Fatalf("failed to load title snapshots: %v", err)
+			}
+			if len(titleSnapshots) != len(wantTitleSnapshots) {
+				t.Fatalf("stored %d title snapshots, want %d: %+v", len(titleSnapshots), len(wantTitleSnapshots), titleSnapshots)
+			}
+			for i, want := range wantTitleSnapshots {
+				snapshot := titleSnapshots[i]
+				if snapshot.Number != want.number || snapshot.SnapshotDate != shifted[want.date] {
+					t.Errorf("snapshot %d is of title %d on %s, want title %d on %s", i,
+						snapshot.Number, snapshot.SnapshotDate, want.number, shifted[want.date])
+					continue
+				}
+				if snapshot.MeasurementMethod != tt.wantMethod {
+					t.Errorf("title %d snapshot on %s measured by %s, want %s", want.number, snapshot.SnapshotDate, snapshot.MeasurementMethod, tt.wantMethod)
+				}
+				if snapshot.WordCount == nil || *snapshot.WordCount == 0 {
+					t.Errorf("title %d snapshot on %s has no word count", want.number, snapshot.SnapshotDate)
+				}
+				if !tt.wantChecksums {
+					continue
+				}
+				if wantChecksum := fixtureChecksum(t, want.fixture); snapshot.Checksum == nil || *snapshot.Checksum != wantChecksum {
+					t.Errorf("title %d snapshot on %s checksum = %v, want %s of %s", want.number, snapshot.SnapshotDate, snapshot.Checksum, wantChecksum, want.fixture)
+				}
+			}
+
+			// Totals cover every title on each date, and agencies their share
+			// of each title as well as their total
+			var counts struct {
+				Overall int
+				Agency  int
+				Shares  int
+			}
+			err = database.DB.Raw(`
+				SELECT
+					COUNT(*) FILTER (WHERE agency_id IS NULL AND title_id IS NULL) AS overall,
+					COUNT(*) FILTER (WHERE agency_id IS NOT NULL AND title_id IS NULL) AS agency,
+					COUNT(*) FILTER (WHERE agency_id IS NOT NULL AND title_id IS NOT NULL) AS shares
+				FROM historical_snapshots
+			`).Scan(&counts).Error
+			if err != nil {
+				t.Fatalf("failed to count snapshots: %v", err)
+			}
+			if counts.Overall != 3 {
+				t.Errorf("stored %d overall snapshots, want one on each of the 3 amendment dates", counts.Overall)
+			}
+			if counts.Agency == 0 || counts.Shares == 0 {
+				t.Errorf("stored %d agency and %d agency share snapshots, want some of both", counts.Agency, counts.Shares)
+			}
+
+			// Dates that already have snapshots are not measured again
+			var before, after int64
+			database.DB.Table("historical_snapshots").Count(&before)
+			requests := server.Handler.RequestCount("/api/versioner/v1/structure/") + server.Handler.RequestCount("/api/versioner/v1/full/")
+			if err := historicalService.ImportHistoricalData(ctx, tt.mode); err != nil {
+				t.Fatalf("second ImportHistoricalData() failed: %v", err)
+			}
+			database.DB.Table("historical_snapshots").Count(&after)
+			if after != before {
+				t.Errorf("second import changed the snapshot count from %d to %d", before, after)
+			}
+			if again := server.Handler.RequestCount("/api/versioner/v1/structure/") + server.Handler.RequestCount("/api/versioner/v1/full/"); again != requests {
+				t.Errorf("second import measured %d titles again, want none", again-requests)
+			}
+		})
+	}
+}
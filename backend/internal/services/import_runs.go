@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Import run kinds
+const (
+	ImportKindAgencies = "agencies"
+	ImportKindTitles   = "titles"
+	ImportKindRefresh  = "refresh"
+	ImportKindAll      = "all"
+)
+
+// Import run statuses
+const (
+	ImportRunRunning     = "running"
+	ImportRunSucceeded   = "succeeded"
+	ImportRunPartial     = "partial"
+	ImportRunFailed      = "failed"
+	ImportRunCancelled   = "cancelled"
+	ImportRunInterrupted = "interrupted"
+)
+
+// Import run item outcomes
+const (
+	ImportItemSucceeded   = "succeeded"
+	ImportItemFailed      = "failed"
+	ImportItemSkipped     = "skipped"
+	ImportItemQuarantined = "quarantined"
+)
+
+// cancelPollInterval is how often a running import checks whether another
+// process asked for it to be cancelled.
+const cancelPollInterval = 5 * time.Second
+
+var (
+	// ErrImportInProgress is returned when another import run holds the
+	// import lock, in this process or any other.
+	ErrImportInProgress = errors.New("another import is already running")
+	// ErrImportRunNotFound is returned when cancelling an unknown run.
+	ErrImportRunNotFound = errors.New("import run not found")
+	// ErrImportRunFinished is returned when cancelling a run that has ended.
+	ErrImportRunFinished = errors.New("import run is not running")
+	// ErrSomeTitlesFailed is returned by a run that stored some of the titles
+	// it downloaded but not all; the run is recorded as partial.
+	ErrSomeTitlesFailed = errors.New("some titles failed to import")
+)
+
+// ImportOptions adjust how an import run treats content already stored.
+type ImportOptions struct {
+	// Replace downloads titles again even when their version for the current
+	// effective date is stored, replacing it if the checksum differs.
+	// Otherwise stored versions are kept and those titles skipped.
+	Replace bool
+}
+
+func startImportRun(kind string, options ImportOptions) (*models.ImportRun, error) {
+	run := &models.ImportRun{
+		Kind:      kind,
+		Replace:   options.Replace,
+		Status:    ImportRunRunning,
+		StartedAt: time.Now().UTC(),
+	}
+	if err := database.DB.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to record import run: %w", err)
+	}
+	log.Printf("[IMPORT_RUN] Started %s run %s", kind, run.ID)
+	return run, nil
+}
+
+func finishImportRun(run *models.ImportRun, runErr error) {
+	finishedAt := time.Now().UTC()
+	updates := map[string]interface{}{
+		"status":      ImportRunSucceeded,
+		"finished_at": finishedAt,
+	}
+	if errors.Is(runErr, context.Canceled) {
+		updates["status"] = ImportRunCancelled
+	} else if errors.Is(runErr, ErrSomeTitlesFailed) {
+		updates["status"] = ImportRunPartial
+		updates["error"] = runErr.Error()
+	} else if runErr != nil {
+		updates["status"] = ImportRunFailed
+		updates["error"] = runErr.Error()
+	}
+
+	if err := database.DB.Model(run).Updates(updates).Error; err != nil {
+		log.Printf("[IMPORT_RUN] Failed to finish run %s: %v", run.ID, err)
+		return
+	}
+	log.Printf("[IMPORT_RUN] Finished %s run %s: %s", run.Kind, run.ID, updates["status"])
+}
+
+// watchForCancellation cancels a run when its cancel_requested flag is set,
+// until ctx is done.
+func watchForCancellation(ctx context.Context, runID uuid.UUID, cancel context.CancelFunc) {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var requested bool
+			err := database.DB.Model(&models.ImportRun{}).
+				Select("cancel_requested").
+				Where("id = ?", runID).
+				Scan(&requested).Error
+			if err != nil {
+				log.Printf("[IMPORT_RUN] Failed to check cancellation of run %s: %v", runID, err)
+				continue
+			}
+			if requested {
+				log.Printf("[IMPORT_RUN] Cancellation requested for run %s", runID)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// requestImportRunCancel flags a running run for cancellation by whichever
+// process runs it.
+func requestImportRunCancel(runID uuid.UUID) error {
+	result := database.DB.Model(&models.ImportRun{}).
+		Where("id = ? AND status = ?", runID, ImportRunRunning).
+		Update("cancel_requested", true)
+	if result.Error != nil {
+		return fmt.Errorf("failed to request cancellation: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.ImportRun{}).Where("id = ?", runID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrImportRunNotFound
+	}
+	return ErrImportRunFinished
+}
+
+func setImportRunTotal(run *models.ImportRun, total int) {
+	if err := database.DB.Model(run).Update("titles_total", total).Error; err != nil {
+		log.Printf("[IMPORT_RUN] Failed to update run %s: %v", run.ID, err)
+	}
+}
+
+func newImportRunItem(run *models.ImportRun, title models.Title) *models.ImportRunItem {
+	return &models.ImportRunItem{
+		RunID:       run.ID,
+		TitleID:     title.ID,
+		TitleNumber: title.Number,
+		StartedAt:   time.Now().UTC(),
+	}
+}
+
+// recordImportRunItem stores the outcome of one title and counts it on the run.
+// Workers record items concurrently, so the counters are incremented in SQL.
+func recordImportRunItem(run *models.ImportRun, item *models.ImportRunItem, itemErr error) {
+	item.Outcome = ImportItemSucceeded
+	counter := "titles_succeeded"
+	if itemErr != nil {
+		message := itemErr.Error()
+		item.Outcome = ImportItemFailed
+		item.Error = &message
+		counter = "titles_failed"
+		if errors.Is(itemErr, ErrContentQuarantined) {
+			item.Outcome = ImportItemQuarantined
+			counter = "titles_quarantined"
+		}
+	}
+	storeImportRunItem(run, item, counter)
+}
+
+// titleTally counts the titles the workers of a run tried to store and how
+// many of them failed or were quarantined.
+type titleTally struct {
+	mutex     sync.Mutex
+	attempted int
+	failed    int
+}
+
+func (t *titleTally) record(itemErr error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.attempted++
+	if itemErr != nil {
+		t.failed++
+	}
+}
+
+// err returns ErrSomeTitlesFailed when some of the attempted titles failed
+// and a plain error when all of them did, so the run is recorded as partial
+// or failed.
+func (t *titleTally) err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.failed == 0 {
+		return nil
+	}
+	if t.failed == t.attempted {
+		return fmt.Errorf("all %d titles failed to import", t.attempted)
+	}
+	return fmt.Errorf("%w: %d of %d", ErrSomeTitlesFailed, t.failed, t.attempted)
+}
+
+// skipImportRunItem records a title that the run did not need to download.
+func skipImportRunItem(run *models.ImportRun, item *models.ImportRunItem, reason string) {
+	item.Outcome = ImportItemSkipped
+	item.SkipReason = &reason
+	storeImportRunItem(run, item, "titles_skipped")
+}
+
+func storeImportRunItem(run *models.ImportRun, item *models.ImportRunItem, counter string) {
+	finishedAt := time.Now().UTC()
+	item.FinishedAt = &finishedAt
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+		return tx.Model(run).UpdateColumn(counter, gorm.Expr(counter+" + 1")).Error
+	})
+	if err != nil {
+		log.Printf("[IMPORT_RUN] Failed to record title %d for run %s: %v", item.TitleNumber, run.ID, err)
+	}
+}
+
+// MarkInterruptedImportRuns flags runs still marked running that no process is
+// working on. If another process holds the import lock its run is left alone.
+func MarkInterruptedImportRuns() error {
+	lock, err := database.TryAdvisoryLock(context.Background(), database.ImportLockKey)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		log.Println("[IMPORT_RUN] Another process is importing; leaving running imports alone")
+		return nil
+	}
+	defer lock.Release()
+
+	result := database.DB.Model(&models.ImportRun{}).
+		Where("status = ?", ImportRunRunning).
+		Updates(map[string]interface{}{
+			"status":      ImportRunInterrupted,
+			"finished_at": time.Now().UTC(),
+			"error":       "server restarted before the run finished",
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark interrupted import runs: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("[IMPORT_RUN] Marked %d unfinished runs as interrupted", result.RowsAffected)
+	}
+	return nil
+}
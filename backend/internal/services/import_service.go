@@ -1,16 +1,18 @@
 package services
 
 import (
-	"crypto/sha256"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
-	"strings"
 	"sync"
 	"time"
 
+	"ecfr-analyzer/internal/blobstore"
 	"ecfr-analyzer/internal/database"
 	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
 )
 
 type ImportStatus struct {
@@ -31,16 +33,28 @@ type ImportStatus struct {
 }
 
 type ImportService struct {
+	config            UpstreamConfig
 	client            *ECFRClient
 	contentDownloader *ContentDownloader
+	structureService  *StructureService
+	validation        ContentValidationConfig
 	status            *ImportStatus
 	mutex             sync.RWMutex
+	// cancels holds the cancel functions of runs executing in this process
+	cancels map[uuid.UUID]context.CancelFunc
 }
 
 func NewImportService() *ImportService {
+	return NewImportServiceWithConfig(DefaultUpstreamConfig())
+}
+
+func NewImportServiceWithConfig(config UpstreamConfig) *ImportService {
 	return &ImportService{
-		client:            NewECFRClient(),
-		contentDownloader: NewContentDownloader(),
+		config:            config,
+		client:            NewECFRClientWithConfig(config),
+		contentDownloader: NewContentDownloaderWithConfig(config),
+		structureService:  NewStructureService(),
+		validation:        DefaultContentValidationConfig(),
 		status: &ImportStatus{
 			IsLoading:      false,
 			CurrentStep:    "Ready",
@@ -54,6 +68,7 @@ func NewImportService() *ImportService {
 			ContentDone:    false,
 			HistoricalDone: false,
 		},
+		cancels: make(map[uuid.UUID]context.CancelFunc),
 	}
 }
 
@@ -63,6 +78,12 @@ func (s *ImportService) GetStatus() ImportStatus {
 	return *s.status
 }
 
+// ContentDownloader returns the downloader used for title content, whose
+// strategy statistics are reported by the API.
+func (s *ImportService) ContentDownloader() *ContentDownloader {
+	return s.contentDownloader
+}
+
 func (s *ImportService) updateStatus(step string, progress int, err string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -78,122 +99,171 @@ func (s *ImportService) setLoading(loading bool) {
 	s.status.IsLoading = loading
 }
 
-func (s *ImportService) ImportAgencies() error {
-	log.Println("Starting agency import...")
-	s.setOverallStep(1, "Importing agencies")
-	s.updateStatus("Importing agencies", 0, "")
+// StartImport records a new import run of the given kind and executes it in
+// the background. Only one import runs at a time across all processes
+// sharing the database; ErrImportInProgress is returned otherwise.
+func (s *ImportService) StartImport(kind string, options ImportOptions) (*models.ImportRun, error) {
+	run, runCtx, done, err := s.beginRun(context.Background(), kind, options)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer done()
+		s.executeRun(runCtx, run)
+	}()
+	return run, nil
+}
 
-	agencies, err := s.client.FetchAgencies()
+// runImport records a new import run of the given kind and waits for it.
+func (s *ImportService) runImport(ctx context.Context, kind string) error {
+	run, runCtx, done, err := s.beginRun(ctx, kind, ImportOptions{})
 	if err != nil {
-		s.updateStatus("Failed to import agencies", 0, err.Error())
 		return err
 	}
+	defer done()
+	return s.executeRun(runCtx, run)
+}
 
-	// Create a map to track agencies by slug for parent relationships
-	agencyMap := make(map[string]*models.Agency)
+// beginRun takes the import lock and records a run. The returned context is
+// cancelled by CancelImport; done must be called when the run has finished.
+func (s *ImportService) beginRun(ctx context.Context, kind string, options ImportOptions) (*models.ImportRun, context.Context, func(), error) {
+	lock, err := database.TryAdvisoryLock(ctx, database.ImportLockKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if lock == nil {
+		return nil, nil, nil, ErrImportInProgress
+	}
 
-	// First pass: create all agencies without parent relationships
-	for i, agencyData := range agencies.Agencies {
-		agency := &models.Agency{
-			Name:      agencyData.Name,
-			ShortName: &agencyData.ShortName,
-			Slug:      agencyData.Slug,
-		}
+	run, err := startImportRun(kind, options)
+	if err != nil {
+		lock.Release()
+		return nil, nil, nil, err
+	}
 
-		// Upsert agency
-		err := database.DB.Where("slug = ?", agency.Slug).FirstOrCreate(agency).Error
-		if err != nil {
-			log.Printf("Error creating agency %s: %s", agency.Slug, err.Error())
-			continue
+	runCtx, cancel := context.WithCancel(ctx)
+	s.mutex.Lock()
+	s.cancels[run.ID] = cancel
+	s.mutex.Unlock()
+	go watchForCancellation(runCtx, run.ID, cancel)
+
+	done := func() {
+		s.mutex.Lock()
+		delete(s.cancels, run.ID)
+		s.mutex.Unlock()
+		cancel()
+		if err := lock.Release(); err != nil {
+			log.Printf("[SERVICE] Failed to release import lock for run %s: %v", run.ID, err)
 		}
+	}
+	return run, runCtx, done, nil
+}
 
-		agencyMap[agency.Slug] = agency
+// CancelImport stops a running import. Runs in this process stop right away;
+// runs in other processes stop once they notice the request.
+func (s *ImportService) CancelImport(runID uuid.UUID) error {
+	if err := requestImportRunCancel(runID); err != nil {
+		return err
+	}
 
-		progress := int(float64(i+1) / float64(len(agencies.Agencies)) * 100)
-		s.updateStatus(fmt.Sprintf("Importing agencies (%d/%d)", i+1, len(agencies.Agencies)), progress, "")
+	s.mutex.Lock()
+	cancel, ok := s.cancels[runID]
+	s.mutex.Unlock()
+	if ok {
+		cancel()
 	}
+	return nil
+}
 
-	// Second pass: process hierarchical structure (children arrays)
-	for _, agencyData := range agencies.Agencies {
-		if parent, exists := agencyMap[agencyData.Slug]; exists {
-			// Process children of this agency
-			for _, childData := range agencyData.Children {
-				// Create child agency if it doesn't exist
-				childAgency := &models.Agency{
-					Name:      childData.Name,
-					ShortName: &childData.ShortName,
-					Slug:      childData.Slug,
-					ParentID:  &parent.ID,
-				}
+func (s *ImportService) executeRun(ctx context.Context, run *models.ImportRun) error {
+	var err error
+	switch run.Kind {
+	case ImportKindAgencies:
+		err = s.importAgencies(ctx, &run.ID)
+	case ImportKindTitles:
+		err = s.importTitles(ctx, run, false)
+	case ImportKindRefresh:
+		err = s.importTitles(ctx, run, true)
+	case ImportKindAll:
+		err = s.loadAllData(ctx, run)
+	default:
+		err = fmt.Errorf("unknown import kind %q", run.Kind)
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		s.updateStatus("Import cancelled", 0, "")
+		s.setLoading(false)
+	}
+	if err != nil {
+		log.Printf("[SERVICE] Import run %s failed: %v", run.ID, err)
+	}
+	finishImportRun(run, err)
+	return err
+}
 
-				// Upsert child agency
-				err := database.DB.Where("slug = ?", childAgency.Slug).FirstOrCreate(childAgency).Error
-				if err != nil {
-					log.Printf("Error creating child agency %s: %s", childAgency.Slug, err.Error())
-					continue
-				}
+func (s *ImportService) ImportAgencies(ctx context.Context) error {
+	return s.runImport(ctx, ImportKindAgencies)
+}
 
-				// Update parent_id if it changed
-				if childAgency.ParentID == nil || *childAgency.ParentID != parent.ID {
-					childAgency.ParentID = &parent.ID
-					database.DB.Save(childAgency)
-				}
+// importAgencies syncs the agency hierarchy and CFR references with the
+// published list, recording every change against runID in the audit log.
+func (s *ImportService) importAgencies(ctx context.Context, runID *uuid.UUID) error {
+	log.Println("Starting agency import...")
+	s.setOverallStep(1, "Importing agencies")
+	s.updateStatus("Importing agencies", 0, "")
 
-				agencyMap[childAgency.Slug] = childAgency
-			}
-		}
+	agencies, err := s.client.FetchAgencies(ctx)
+	if err != nil {
+		s.updateStatus("Failed to import agencies", 0, err.Error())
+		return err
 	}
 
-	// Third pass: create CFR references for all agencies (parent and children)
-	log.Println("Creating CFR references for agencies...")
-	totalRefs := 0
-	
-	var processAgencyCFRRefs func(agencyData AgencyData)
-	processAgencyCFRRefs = func(agencyData AgencyData) {
-		if agency, exists := agencyMap[agencyData.Slug]; exists {
-			for _, ref := range agencyData.CFRReferences {
-				var title models.Title
-				if err := database.DB.Where("number = ?", ref.Title).First(&title).Error; err == nil {
-					cfrRef := &models.AgencyCFRReference{
-						AgencyID: agency.ID,
-						TitleID:  title.ID,
-						Chapter:  &ref.Chapter,
-					}
-					database.DB.Where("agency_id = ? AND title_id = ? AND chapter = ?", 
-						cfrRef.AgencyID, cfrRef.TitleID, ref.Chapter).FirstOrCreate(cfrRef)
-					totalRefs++
-				}
-			}
-			
-			// Recursively process children
-			for _, childData := range agencyData.Children {
-				processAgencyCFRRefs(childData)
-			}
-		}
-	}
-	
-	// Process all agencies (parent and children)
-	for _, agencyData := range agencies.Agencies {
-		processAgencyCFRRefs(agencyData)
+	// Reconcile the stored hierarchy with the published one
+	s.updateStatus(fmt.Sprintf("Syncing %d top-level agencies", len(agencies.Agencies)), 50, "")
+	stats, err := syncAgencies(database.DB, runID, agencies.Agencies)
+	if err != nil {
+		s.updateStatus("Failed to sync agencies", 0, err.Error())
+		return err
 	}
 
-	log.Printf("Successfully imported %d agencies with %d CFR references", len(agencyMap), totalRefs)
+	log.Printf("Synced %d agencies (%d created, %d updated, %d moved, %d deleted, %d restored) with %d CFR references (%d added, %d removed)",
+		stats.Agencies, stats.Created, stats.Updated, stats.Moved, stats.Deleted, stats.Restored,
+		stats.References, stats.ReferencesAdded, stats.ReferencesRemoved)
 	s.markStepComplete("agencies")
 	s.markStepComplete("references") // CFR references are now done with agencies
 	return nil
 }
 
-func (s *ImportService) ImportTitles() error {
+func (s *ImportService) ImportTitles(ctx context.Context) error {
+	return s.runImport(ctx, ImportKindTitles)
+}
+
+// RefreshTitles downloads only the titles amended since their stored content
+// was downloaded, and only if the bulk repository file changed.
+func (s *ImportService) RefreshTitles(ctx context.Context) error {
+	return s.runImport(ctx, ImportKindRefresh)
+}
+
+// importTitles imports title metadata and content, then historical snapshots.
+// When titles fail to download or store, it returns ErrSomeTitlesFailed, or
+// a plain error if every title it tried failed. In refresh mode titles whose
+// latest amendment date has not moved are skipped, bulk downloads are
+// conditional on the validators of the stored content and no snapshots are
+// imported.
+func (s *ImportService) importTitles(ctx context.Context, run *models.ImportRun, refresh bool) error {
 	log.Println("Starting title import...")
 	s.setOverallStep(2, "Importing titles")
 	s.updateStatus("Importing titles", 0, "")
 
-	titles, err := s.client.FetchTitles()
+	titles, err := s.client.FetchTitles(ctx)
 	if err != nil {
 		s.updateStatus("Failed to import titles", 0, err.Error())
 		return err
 	}
 
+	// Metadata as published now. It is stored straight away; the amendment
+	// date the stored content reflects is only moved once it is refreshed
+	fetchedTitles := make(map[int]models.Title)
 	for i, titleData := range titles.Titles {
 		title := &models.Title{
 			Number:   titleData.Number,
@@ -218,10 +288,11 @@ func (s *ImportService) ImportTitles() error {
 			}
 		}
 
-		// Upsert title
-		err := database.DB.Where("number = ?", title.Number).FirstOrCreate(title).Error
-		if err != nil {
-			log.Printf("Error creating title %d: %s", title.Number, err.Error())
+		fetchedTitles[title.Number] = *title
+
+		// Upsert title, recording changed metadata in its history
+		if _, err := upsertTitleMetadata(&run.ID, *title); err != nil {
+			log.Printf("Error storing title %d: %s", title.Number, err.Error())
 			continue
 		}
 
@@ -248,10 +319,33 @@ func (s *ImportService) ImportTitles() error {
 	s.status.TotalTitles = len(activeTitles)
 	s.status.CurrentTitle = 0
 	s.mutex.Unlock()
+	setImportRunTotal(run, len(activeTitles))
+
+	// Content is stored as the version in effect from the title's
+	// up_to_date_as_of. Unless the run replaces stored versions, titles
+	// whose version for that date is already stored are skipped, and so are
+	// titles already checked against their latest amendment, as unchanged
+	// content adds no version. A restarted import thus picks up where an
+	// earlier run stopped
+	storedVersions, err := storedContentVersions()
+	if err != nil {
+		s.updateStatus("Failed to check stored titles", 0, err.Error())
+		return err
+	}
+	
+	var latestContents map[uuid.UUID]storedContent
+	if refresh {
+		latestContents, err = latestStoredContents()
+		if err != nil {
+			s.updateStatus("Failed to check stored content", 0, err.Error())
+			return err
+		}
+	}
 
 	// Use worker pool pattern with 5 workers
 	titleChan := make(chan models.Title, len(activeTitles))
 	var wg sync.WaitGroup
+	tally := &titleTally{}
 	
 	log.Printf("Starting content import with %d workers for %d titles", 5, len(activeTitles))
 	
@@ -265,8 +359,65 @@ func (s *ImportService) ImportTitles() error {
 			}()
 			log.Printf("Worker %d started", workerID)
 			for title := range titleChan {
+				// Drain the queue without working once the run is cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+				item := newImportRunItem(run, title)
+				fetched := fetchedTitles[title.Number]
+				contentDate := effectiveContentDate(fetched)
+				if !run.Replace {
+					reason := ""
+					if storedVersions[title.ID][contentDate.Format("2006-01-02")] {
+						reason = fmt.Sprintf("already stored for %s", contentDate.Format("2006-01-02"))
+					} else {
+						reason = refreshSkipReason(title, fetched, len(storedVersions[title.ID]) > 0)
+					}
+					if reason != "" {
+						skipImportRunItem(run, item, reason)
+						s.incrementProgress()
+						continue
+					}
+				}
+				var previous *ContentValidators
+				if refresh {
+					latest, hasContent := latestContents[title.ID]
+					if reason := refreshSkipReason(title, fetched, hasContent); reason != "" {
+						skipImportRunItem(run, item, reason)
+						markContentAmendedOn(title.ID, fetched)
+						s.incrementProgress()
+						continue
+					}
+					// Replacing runs download again even if the file is
+					// unchanged
+					if hasContent && !run.Replace {
+						previous = latest.validators()
+					}
+				}
 				log.Printf("Worker %d processing title %d: %s", workerID, title.Number, title.Name)
-				s.downloadAndProcessTitle(title)
+				err := s.downloadAndProcessTitle(ctx, title, contentDate, previous, item)
+				if ctx.Err() != nil {
+					// The title was cut off, not failed; a later run retries it
+					continue
+				}
+				if errors.Is(err, ErrNotModified) {
+					// The stored dates are left alone so the next refresh checks
+					// again
+					skipImportRunItem(run, item, "bulk repository file not modified since the last download")
+					s.incrementProgress()
+					continue
+				}
+				if errors.Is(err, ErrContentUnchanged) {
+					skipImportRunItem(run, item, err.Error())
+					markContentAmendedOn(title.ID, fetched)
+					s.incrementProgress()
+					continue
+				}
+				if err == nil {
+					markContentAmendedOn(title.ID, fetched)
+				}
+				recordImportRunItem(run, item, err)
+				tally.record(err)
 				s.incrementProgress()
 				log.Printf("Worker %d completed title %d", workerID, title.Number)
 			}
@@ -283,18 +434,37 @@ func (s *ImportService) ImportTitles() error {
 
 	// Wait for all workers to complete
 	wg.Wait()
+	
+	if err := ctx.Err(); err != nil {
+		log.Printf("Content import cancelled")
+		return err
+	}
 
 	log.Printf("All workers completed. Successfully processed %d title contents", len(activeTitles))
 	s.updateStatus("Content import completed", 100, "")
 	s.markStepComplete("content")
 	
-	// Import historical data after content is complete
+	// A refresh only downloads content; the snapshot-capture job records it
+	if !refresh {
+		if err := s.importHistory(ctx); err != nil {
+			return err
+		}
+	}
+	
+	// Failed titles do not stop the other titles or the history, but the run
+	// is not recorded as succeeded
+	return tally.err()
+}
+
+// importHistory captures a snapshot of the current content and backfills
+// estimated snapshots of the past two years after a full content import.
+func (s *ImportService) importHistory(ctx context.Context) error {
 	log.Println("Starting historical snapshots import...")
 	s.setOverallStep(4, "Creating historical snapshots")
 	s.updateStatus("Creating historical snapshots", 0, "")
 	
 	// Use the historical service to capture current snapshot and import historical data
-	historicalService := NewHistoricalService()
+	historicalService := NewHistoricalServiceWithConfig(s.config)
 	
 	// First capture current snapshot
 	if err := historicalService.CaptureSnapshot(); err != nil {
@@ -305,7 +475,10 @@ func (s *ImportService) ImportTitles() error {
 	s.updateStatus("Importing historical data from eCFR API", 50, "")
 	
 	// Then import historical data from eCFR API
-	if err := historicalService.ImportHistoricalData(); err != nil {
+	if err := historicalService.ImportHistoricalData(ctx, HistoricalModeEstimate); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		log.Printf("Warning: Failed to import historical data: %v", err)
 		// Don't fail the entire import if historical data fails
 	}
@@ -317,46 +490,168 @@ func (s *ImportService) ImportTitles() error {
 }
 
 
-func (s *ImportService) downloadAndProcessTitle(title models.Title) {
+// downloadAndProcessTitle downloads, stores and parses the current content of
+// a title as its version in effect from contentDate, recording the strategy
+// used and the size on item. With previous validators the bulk download is
+// conditional and may fail with ErrNotModified. Content that fails validation
+// is quarantined and ErrContentQuarantined returned; content identical to the
+// version in effect is not stored again and ErrContentUnchanged returned.
+func (s *ImportService) downloadAndProcessTitle(ctx context.Context, title models.Title, contentDate time.Time, previous *ContentValidators, item *models.ImportRunItem) error {
 	log.Printf("Starting download for title %d: %s", title.Number, title.Name)
 	
 	// Download XML content using the modular content downloader (tries bulk first, then API)
-	content, err := s.contentDownloader.DownloadTitleContent(title.Number)
+	downloaded, err := s.contentDownloader.DownloadTitle(ctx, title.Number, previous)
+	if errors.Is(err, ErrNotModified) {
+		log.Printf("Title %d (%s) unchanged since the last download", title.Number, title.Name)
+		return err
+	}
 	if err != nil {
 		log.Printf("FAILED to download title %d (%s): %s", title.Number, title.Name, err.Error())
-		return
+		return fmt.Errorf("failed to download title %d: %w", title.Number, err)
 	}
+	item.Strategy = &downloaded.Strategy
 	
-	log.Printf("Successfully downloaded title %d (%s), size: %d bytes", title.Number, title.Name, len(content))
-
-	// Calculate word count
-	wordCount := s.calculateWordCount(content)
-	log.Printf("Title %d word count: %d", title.Number, wordCount)
+	// Stream the XML into the blob store, hashing it and counting its words on
+	// the way
+	ingested, err := ingestTitleXML(ctx, downloaded.Body)
+	// Closing the body records how long the strategy took to download, so it
+	// is closed before the content is validated and stored
+	if closeErr := downloaded.Body.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("FAILED to download title %d (%s): %s", title.Number, title.Name, err.Error())
+		return fmt.Errorf("failed to download title %d: %w", title.Number, err)
+	}
+	item.Bytes = ingested.Size
+	wordCount := ingested.WordCount
+	restrictionCount := RestrictionTotal(ingested.Restrictions)
+	checksum := ingested.Checksum
+	size := ingested.Size
 	
-	// Calculate checksum
-	checksum := s.calculateChecksum(content)
+	// Hold back content that is not this title's XML or has shrunk implausibly
+	previousVersion, err := latestTitleContent(title.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load previous content of title %d: %w", title.Number, err)
+	}
+	if problems := validateTitleXML(title.Number, ingested, previousVersion, s.validation); len(problems) > 0 {
+		return quarantineContent(title, contentDate, ingested, downloaded, previousVersion, problems)
+	}
+	
+	log.Printf("Successfully downloaded title %d (%s), size: %d bytes", title.Number, title.Name, ingested.Size)
+	log.Printf("Title %d word count: %d", title.Number, wordCount)
+	log.Printf("Title %d restriction terms: %d", title.Number, restrictionCount)
 	log.Printf("Title %d checksum: %s", title.Number, checksum[:8]+"...")
 	
 	// Store in database
 	titleContent := &models.TitleContent{
 		TitleID:     title.ID,
-		ContentDate: time.Now().UTC().Truncate(24 * time.Hour), // Store as date only
-		XMLContent:  content,
+		ContentDate: contentDate,
 		WordCount:   &wordCount,
 		Checksum:    &checksum,
+		SizeBytes:   &size,
+
+		RestrictionCount: &restrictionCount,
+	}
+	if downloaded.Validators != nil {
+		titleContent.ETag = optionalString(downloaded.Validators.ETag)
+		titleContent.LastModified = optionalString(downloaded.Validators.LastModified)
 	}
 
-	log.Printf("Storing title %d content to database...", title.Number)
-	// Upsert content (update if exists for same title and date)
-	err = database.DB.Where("title_id = ? AND content_date = ?", 
-		titleContent.TitleID, titleContent.ContentDate).
-		FirstOrCreate(titleContent).Error
+	log.Printf("Storing title %d content for %s to database...", title.Number, contentDate.Format("2006-01-02"))
+	// Add a version only if the checksum differs from the one in effect
+	outcome, err := storeContentVersion(titleContent)
 	if err != nil {
 		log.Printf("FAILED to store content for title %d (%s): %s", title.Number, title.Name, err.Error())
-		return
+		return fmt.Errorf("failed to store content for title %d: %w", title.Number, err)
+	}
+	if outcome == contentVersionUnchanged {
+		log.Printf("Title %d (%s) content unchanged since %s", title.Number, title.Name, titleContent.ContentDate.Format("2006-01-02"))
+		return fmt.Errorf("%w of %s (checksum %s)", ErrContentUnchanged, titleContent.ContentDate.Format("2006-01-02"), checksum[:8])
+	}
+	
+	log.Printf("Successfully stored title %d (%s) content to database (%s version)", title.Number, title.Name, outcome)
+
+	// Break the title into its parts and sections, reading the XML back from
+	// the blob store
+	xml, err := blobstore.Default.Open(ctx, checksum)
+	if err != nil {
+		log.Printf("FAILED to open stored XML for title %d (%s): %s", title.Number, title.Name, err.Error())
+		return fmt.Errorf("failed to open stored XML for title %d: %w", title.Number, err)
+	}
+	defer xml.Close()
+	nodeCount, err := s.structureService.StoreStructure(titleContent, xml)
+	if err != nil {
+		log.Printf("FAILED to store structure for title %d (%s): %s", title.Number, title.Name, err.Error())
+		return fmt.Errorf("failed to store structure for title %d: %w", title.Number, err)
+	}
+	log.Printf("Stored %d structure nodes for title %d", nodeCount, title.Number)
+	return nil
+}
+
+// storedContent is the newest stored content version of a title.
+type storedContent struct {
+	TitleID      uuid.UUID
+	ContentDate  time.Time
+	ETag         *string
+	LastModified *string
+}
+
+func (c storedContent) validators() *ContentValidators {
+	if c.ETag == nil && c.LastModified == nil {
+		return nil
+	}
+	validators := &ContentValidators{}
+	if c.ETag != nil {
+		validators.ETag = *c.ETag
+	}
+	if c.LastModified != nil {
+		validators.LastModified = *c.LastModified
+	}
+	return validators
+}
+
+// latestStoredContents returns the newest content version of every title that
+// has one.
+func latestStoredContents() (map[uuid.UUID]storedContent, error) {
+	var contents []storedContent
+	err := database.DB.Raw(`
+		SELECT DISTINCT ON (title_id) title_id, content_date, etag, last_modified
+		FROM title_contents
+		ORDER BY title_id, content_date DESC
+	`).Scan(&contents).Error
+	if err != nil {
+		return nil, err
 	}
 	
-	log.Printf("Successfully stored title %d (%s) content to database", title.Number, title.Name)
+	latest := make(map[uuid.UUID]storedContent, len(contents))
+	for _, content := range contents {
+		latest[content.TitleID] = content
+	}
+	return latest, nil
+}
+
+// refreshSkipReason explains why an import can skip a title, or returns ""
+// when it has to be downloaded. stored holds the amendment date of the last
+// refreshed content, fetched the dates just published in titles.json.
+func refreshSkipReason(stored, fetched models.Title, hasContent bool) string {
+	if !hasContent || stored.ContentAmendedOn == nil || fetched.LatestAmendedOn == nil {
+		return ""
+	}
+	if !fetched.LatestAmendedOn.Equal(*stored.ContentAmendedOn) {
+		return ""
+	}
+	return fmt.Sprintf("not amended since %s", stored.ContentAmendedOn.Format("2006-01-02"))
+}
+
+// markContentAmendedOn stores the amendment date a title's content was
+// refreshed against.
+func markContentAmendedOn(titleID uuid.UUID, fetched models.Title) {
+	err := database.DB.Model(&models.Title{}).Where("id = ?", titleID).
+		Update("content_amended_on", fetched.LatestAmendedOn).Error
+	if err != nil {
+		log.Printf("Error updating content date of title %d: %v", fetched.Number, err)
+	}
 }
 
 func (s *ImportService) incrementProgress() {
@@ -417,44 +712,25 @@ func (s *ImportService) markStepComplete(stepName string) {
 	}
 }
 
-func (s *ImportService) calculateWordCount(xmlContent string) int {
-	// Strip XML tags
-	re := regexp.MustCompile(`<[^>]*>`)
-	text := re.ReplaceAllString(xmlContent, " ")
-	
-	// Normalize whitespace
-	text = regexp.MustCompile(`\s+`).ReplaceAllString(strings.TrimSpace(text), " ")
-	
-	// Count words
-	if text == "" {
-		return 0
-	}
-	return len(strings.Fields(text))
+func (s *ImportService) LoadAllData(ctx context.Context) error {
+	return s.runImport(ctx, ImportKindAll)
 }
 
-func (s *ImportService) calculateChecksum(content string) string {
-	hash := sha256.Sum256([]byte(content))
-	return fmt.Sprintf("%x", hash)
-}
-
-
-
-
-func (s *ImportService) LoadAllData() error {
+func (s *ImportService) loadAllData(ctx context.Context, run *models.ImportRun) error {
 	log.Println("[SERVICE] Starting LoadAllData process")
 	s.setLoading(true)
 	defer s.setLoading(false)
 
 	// Import in sequence: agencies (with CFR refs) -> titles (with content + historical data)
 	log.Println("[SERVICE] Starting agency import")
-	if err := s.ImportAgencies(); err != nil {
+	if err := s.importAgencies(ctx, &run.ID); err != nil {
 		log.Printf("[SERVICE] Agency import failed: %v", err)
 		return err
 	}
 	log.Println("[SERVICE] Agency import completed successfully")
 
 	log.Println("[SERVICE] Starting title import")
-	if err := s.ImportTitles(); err != nil {
+	if err := s.importTitles(ctx, run, false); err != nil {
 		log.Printf("[SERVICE] Title import failed: %v", err)
 		return err
 	}
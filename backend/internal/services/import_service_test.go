@@ -0,0 +1,240 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"ecfr-analyzer/internal/blobstore"
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+	"ecfr-analyzer/internal/services"
+)
+
+func TestImportServiceImportsFromFakeECFR(t *testing.T) {
+	loadAll := (*services.ImportService).LoadAllData
+	refresh := (*services.ImportService).RefreshTitles
+
+	tests := []struct {
+		name    string
+		imports []func(*services.ImportService, context.Context) error
+		// wantRun holds the kind, status and title counters of the last run
+		wantRun       models.ImportRun
+		wantSnapshots bool
+	}{
+		{
+			name:          "full load",
+			imports:       []func(*services.ImportService, context.Context) error{loadAll},
+			wantRun:       models.ImportRun{Kind: services.ImportKindAll, Status: services.ImportRunSucceeded, TitlesTotal: 2, TitlesSucceeded: 2},
+			wantSnapshots: true,
+		},
+		{
+			name:          "full load twice",
+			imports:       []func(*services.ImportService, context.Context) error{loadAll, loadAll},
+			wantRun:       models.ImportRun{Kind: services.ImportKindAll, Status: services.ImportRunSucceeded, TitlesTotal: 2, TitlesSkipped: 2},
+			wantSnapshots: true,
+		},
+		{
+			name:    "refresh of an empty database",
+			imports: []func(*services.ImportService, context.Context) error{refresh},
+			wantRun: models.ImportRun{Kind: services.ImportKindRefresh, Status: services.ImportRunSucceeded, TitlesTotal: 2, TitlesSucceeded: 2},
+		},
+		{
+			name:          "refresh after a full load",
+			imports:       []func(*services.ImportService, context.Context) error{loadAll, refresh},
+			wantRun:       models.ImportRun{Kind: services.ImportKindRefresh, Status: services.ImportRunSucceeded, TitlesTotal: 2, TitlesSkipped: 2},
+			wantSnapshots: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDatabase(t)
+			server, shifted := newFakeECFR(t)
+			importService := services.NewImportServiceWithConfig(server.UpstreamConfig())
+
+			for _, runImport := range tt.imports {
+				if err := runImport(importService, context.Background()); err != nil {
+					t.Fatalf("import failed: %v", err)
+				}
+			}
+
+			var run models.ImportRun
+			if err := database.DB.Order("started_at DESC").First(&run).Error; err != nil {
+				t.Fatalf("failed to load import run: %v", err)
+			}
+			if run.Kind != tt.wantRun.Kind || run.Status != tt.wantRun.Status ||
+				run.TitlesTotal != tt.wantRun.TitlesTotal || run.TitlesSucceeded != tt.wantRun.TitlesSucceeded ||
+				run.TitlesSkipped != tt.wantRun.TitlesSkipped || run.TitlesFailed != 0 {
+				t.Errorf("last run = %s %s, %d titles (%d succeeded, %d skipped, %d failed), want %s %s, %d titles (%d succeeded, %d skipped, 0 failed)",
+					run.Kind, run.Status, run.TitlesTotal, run.TitlesSucceeded, run.TitlesSkipped, run.TitlesFailed,
+					tt.wantRun.Kind, tt.wantRun.Status, tt.wantRun.TitlesTotal, tt.wantRun.TitlesSucceeded, tt.wantRun.TitlesSkipped)
+			}
+
+			checksums := assertImportedContent(t)
+			assertCurrentSnapshots(t, checksums, tt.wantSnapshots)
+			assertBackfilledSnapshots(t, shifted, tt.wantSnapshots)
+		})
+	}
+}
+
+// assertImportedContent checks the titles and content stored from the
+// fixtures and returns the content checksum of each title number.
+func assertImportedContent(t *testing.T) map[int]string {
+	t.Helper()
+
+	var titles []models.Title
+	if err := database.DB.Order("number").Find(&titles).Error; err != nil {
+		t.Fatalf("failed to load titles: %v", err)
+	}
+	wantTitles := []struct {
+		number   int
+		name     string
+		reserved bool
+	}{
+		{1, "General Provisions", false},
+		{35, "Reserved", true},
+		{40, "Protection of Environment", false},
+	}
+	if len(titles) != len(wantTitles) {
+		t.Fatalf("stored %d titles, want %d", len(titles), len(wantTitles))
+	}
+	for i, want := range wantTitles {
+		if titles[i].Number != want.number || titles[i].Name != want.name || titles[i].Reserved != want.reserved {
+			t.Errorf("title %d = %d %q (reserved %t), want %d %q (reserved %t)", i,
+				titles[i].Number, titles[i].Name, titles[i].Reserved, want.number, want.name, want.reserved)
+		}
+	}
+
+	var contents []struct {
+		Number      int
+		ContentDate string
+		WordCount   *int
+		Checksum    *string
+	}
+	err := database.DB.Raw(`
+		SELECT t.number, to_char(tc.content_date, 'YYYY-MM-DD') AS content_date, tc.word_count, tc.checksum
+		FROM title_contents tc
+		JOIN titles t ON t.id = tc.title_id
+		ORDER BY t.number
+	`).Scan(&contents).Error
+	if err != nil {
+		t.Fatalf("failed to load title contents: %v", err)
+	}
+
+	// The bulk repository serves the newest fixture of each title, stored as
+	// the version in effect from the title's up_to_date_as_of
+	wantContents := []struct {
+		number  int
+		fixture string
+	}{
+		{1, "titles/title-1/2024-06-01.xml"},
+		{40, "titles/title-40/2024-03-15.xml"},
+	}
+	if len(contents) != len(wantContents) {
+		t.Fatalf("stored %d content versions, want %d", len(contents), len(wantContents))
+	}
+	checksums := make(map[int]string)
+	for i, want := range wantContents {
+		content := contents[i]
+		wantChecksum := fixtureChecksum(t, want.fixture)
+		if content.Number != want.number || content.ContentDate != "2024-06-03" {
+			t.Errorf("content %d is of title %d on %s, want title %d on 2024-06-03", i, content.Number, content.ContentDate, want.number)
+		}
+		if content.Checksum == nil || *content.Checksum != wantChecksum {
+			t.Errorf("title %d checksum = %v, want %s of %s", want.number, content.Checksum, wantChecksum, want.fixture)
+			continue
+		}
+		if content.WordCount == nil || *content.WordCount == 0 {
+			t.Errorf("title %d has no word count", want.number)
+		}
+		stored, err := blobstore.Default.Exists(context.Background(), wantChecksum)
+		if err != nil || !stored {
+			t.Errorf("XML of title %d not in the blob store (err %v)", want.number, err)
+		}
+		checksums[want.number] = wantChecksum
+	}
+	return checksums
+}
+
+// assertCurrentSnapshots checks the snapshot captured of the imported content,
+// dated by its newest content date.
+func assertCurrentSnapshots(t *testing.T, checksums map[int]string, want bool) {
+	t.Helper()
+
+	var titleSnapshots []struct {
+		Number    int
+		WordCount *int
+		Checksum  *string
+	}
+	err := database.DB.Raw(`
+		SELECT t.number, hs.word_count, hs.checksum
+		FROM historical_snapshots hs
+		JOIN titles t ON t.id = hs.title_id
+		WHERE hs.agency_id IS NULL AND hs.snapshot_date::date = DATE '2024-06-03'
+		ORDER BY t.number
+	`).Scan(&titleSnapshots).Error
+	if err != nil {
+		t.Fatalf("failed to load title snapshots: %v", err)
+	}
+	if !want {
+		if len(titleSnapshots) > 0 {
+			t.Errorf("stored %d snapshots of the current content, want none", len(titleSnapshots))
+		}
+		return
+	}
+
+	if len(titleSnapshots) != len(checksums) {
+		t.Fatalf("stored %d title snapshots of the current content, want %d", len(titleSnapshots), len(checksums))
+	}
+	total := 0
+	for _, snapshot := range titleSnapshots {
+		if snapshot.Checksum == nil || *snapshot.Checksum != checksums[snapshot.Number] {
+			t.Errorf("title %d snapshot checksum = %v, want %s", snapshot.Number, snapshot.Checksum, checksums[snapshot.Number])
+		}
+		if snapshot.WordCount != nil {
+			total += *snapshot.WordCount
+		}
+	}
+
+	var overall []int
+	err = database.DB.Raw(`
+		SELECT word_count FROM historical_snapshots
+		WHERE agency_id IS NULL AND title_id IS NULL AND snapshot_date::date = DATE '2024-06-03'
+	`).Scan(&overall).Error
+	if err != nil {
+		t.Fatalf("failed to load overall snapshot: %v", err)
+	}
+	if len(overall) != 1 || overall[0] != total {
+		t.Errorf("overall snapshots = %v, want one of %d words", overall, total)
+	}
+}
+
+// assertBackfilledSnapshots checks the overall snapshots estimated on the
+// dates the fixtures amended a title.
+func assertBackfilledSnapshots(t *testing.T, shifted map[string]string, want bool) {
+	t.Helper()
+
+	var dates []string
+	err := database.DB.Raw(`
+		SELECT to_char(snapshot_date, 'YYYY-MM-DD') FROM historical_snapshots
+		WHERE agency_id IS NULL AND title_id IS NULL AND measurement_method = ?
+		ORDER BY snapshot_date
+	`, services.MeasurementStructureEstimate).Scan(&dates).Error
+	if err != nil {
+		t.Fatalf("failed to load backfilled snapshots: %v", err)
+	}
+
+	var wantDates []string
+	if want {
+		wantDates = []string{shifted["2024-01-01"], shifted["2024-03-15"], shifted["2024-06-01"]}
+	}
+	if len(dates) != len(wantDates) {
+		t.Fatalf("backfilled snapshots on %v, want %v", dates, wantDates)
+	}
+	for i := range dates {
+		if dates[i] != wantDates[i] {
+			t.Errorf("backfilled snapshots on %v, want %v", dates, wantDates)
+			break
+		}
+	}
+}
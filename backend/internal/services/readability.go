@@ -0,0 +1,470 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Readability levels and sort orders accepted by ReadabilityRanking
+const (
+	ReadabilityLevelSection = "section"
+	ReadabilityLevelTitle   = "title"
+	ReadabilityLevelAgency  = "agency"
+
+	ReadabilitySortFleschKincaid  = "fleschKincaidGrade"
+	ReadabilitySortGunningFog     = "gunningFog"
+	ReadabilitySortSentenceLength = "averageSentenceLength"
+	ReadabilitySortLongWordRatio  = "longWordRatio"
+)
+
+// Long words have more than six letters; complex words, as the Gunning fog
+// index defines them, three or more syllables.
+const (
+	readabilityLongWordMinLetters  = 7
+	readabilityComplexMinSyllables = 3
+)
+
+// readabilitySortExpressions rank structure nodes in SQL by the same formulas
+// ReadabilityOf uses. Nodes without sentences must be filtered out first.
+var readabilitySortExpressions = map[string]string{
+	ReadabilitySortFleschKincaid: `0.39 * sn.readability_words::float8 / sn.readability_sentences
+		+ 11.8 * sn.readability_syllables::float8 / sn.readability_words - 15.59`,
+	ReadabilitySortGunningFog: `0.4 * (sn.readability_words::float8 / sn.readability_sentences
+		+ 100.0 * sn.readability_complex_words / sn.readability_words)`,
+	ReadabilitySortSentenceLength: `sn.readability_words::float8 / sn.readability_sentences`,
+	ReadabilitySortLongWordRatio:  `sn.readability_long_words::float8 / sn.readability_words`,
+}
+
+// ValidReadabilitySort reports whether sortBy names a readability score.
+func ValidReadabilitySort(sortBy string) bool {
+	_, ok := readabilitySortExpressions[sortBy]
+	return ok
+}
+
+// ReadabilityScores are the readability scores of a piece of text. Higher
+// grades and fog indexes mean harder to read.
+type ReadabilityScores struct {
+	FleschKincaidGrade    float64 `json:"fleschKincaidGrade"`
+	GunningFog            float64 `json:"gunningFog"`
+	AverageSentenceLength float64 `json:"averageSentenceLength"`
+	LongWordRatio         float64 `json:"longWordRatio"`
+}
+
+// ReadabilityOf computes readability scores from counts, or returns nil when
+// there is no text to score.
+func ReadabilityOf(counts models.ReadabilityCounts) *ReadabilityScores {
+	if counts.Words == 0 || counts.Sentences == 0 {
+		return nil
+	}
+	words := float64(counts.Words)
+	sentenceLength := words / float64(counts.Sentences)
+	return &ReadabilityScores{
+		FleschKincaidGrade:    roundScore(0.39*sentenceLength + 11.8*float64(counts.Syllables)/words - 15.59),
+		GunningFog:            roundScore(0.4 * (sentenceLength + 100*float64(counts.ComplexWords)/words)),
+		AverageSentenceLength: roundScore(sentenceLength),
+		LongWordRatio:         math.Round(float64(counts.LongWords)/words*10000) / 10000,
+	}
+}
+
+func (s *ReadabilityScores) value(sortBy string) float64 {
+	switch sortBy {
+	case ReadabilitySortGunningFog:
+		return s.GunningFog
+	case ReadabilitySortSentenceLength:
+		return s.AverageSentenceLength
+	case ReadabilitySortLongWordRatio:
+		return s.LongWordRatio
+	}
+	return s.FleschKincaidGrade
+}
+
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+// countReadability counts the sentences, words and syllables of a piece of
+// text. Words are runs of letters; tokens containing digits, such as
+// paragraph numbers and citations, are skipped. A sentence ends at a '.', '!'
+// or '?' that follows a word of two or more letters and precedes whitespace,
+// so abbreviations like "U.S.C." and "e.g." do not end one. Text left after
+// the last sentence end counts as one more sentence.
+func countReadability(text string) models.ReadabilityCounts {
+	var counts models.ReadabilityCounts
+	runes := []rune(text)
+	var word []rune
+	hasDigit := false
+	lastWordLen := 0 // letters of the previous token, 0 if it was not a word
+	open := false    // words seen since the last sentence end
+
+	endToken := func() {
+		if len(word) == 0 && !hasDigit {
+			return
+		}
+		lastWordLen = 0
+		if !hasDigit && len(word) > 0 {
+			counts.Words++
+			syllables := countSyllables(word)
+			counts.Syllables += syllables
+			if isComplexWord(word, syllables) {
+				counts.ComplexWords++
+			}
+			if len(word) >= readabilityLongWordMinLetters {
+				counts.LongWords++
+			}
+			lastWordLen = len(word)
+			open = true
+		}
+		word = word[:0]
+		hasDigit = false
+	}
+
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r):
+			word = append(word, unicode.ToLower(r))
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case (r == '\'' || r == '’') && len(word) > 0:
+			// Apostrophes inside words such as "agency's"
+		default:
+			endToken()
+			if r == '.' || r == '!' || r == '?' {
+				followedBySpace := i+1 == len(runes) || unicode.IsSpace(runes[i+1]) ||
+					strings.ContainsRune(`"')]”’`, runes[i+1])
+				if lastWordLen >= 2 && followedBySpace && open {
+					counts.Sentences++
+					open = false
+				}
+			}
+			// Text of inline elements is joined with spaces, so a space may
+			// separate a word from the period after it
+			if !unicode.IsSpace(r) {
+				lastWordLen = 0
+			}
+		}
+	}
+	endToken()
+	if open {
+		counts.Sentences++
+	}
+	return counts
+}
+
+// countSyllables estimates the syllables of a lowercase word as its groups of
+// vowels, not counting a silent final "e" or the "e" of endings like "-res"
+// and "-red".
+func countSyllables(word []rune) int {
+	syllables := 0
+	inVowels := false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouy", r)
+		if vowel && !inVowels {
+			syllables++
+		}
+		inVowels = vowel
+	}
+	n := len(word)
+	switch {
+	case syllables <= 1:
+	case word[n-1] == 'e' && word[n-2] != 'l':
+		syllables--
+	case n >= 3 && word[n-2] == 'e' && word[n-1] == 's':
+		// "rules" but not "uses", "places" or "agencies"
+		if !strings.ContainsRune("aeiouyscgxzh", word[n-3]) {
+			syllables--
+		}
+	case n >= 3 && word[n-2] == 'e' && word[n-1] == 'd':
+		// "required" but not "provided" or "listed"
+		if !strings.ContainsRune("aeiouytd", word[n-3]) {
+			syllables--
+		}
+	}
+	if syllables == 0 {
+		return 1
+	}
+	return syllables
+}
+
+// isComplexWord applies the Gunning fog definition of a complex word: three
+// or more syllables, not counting the common suffixes -es, -ed and -ing.
+func isComplexWord(word []rune, syllables int) bool {
+	if syllables < readabilityComplexMinSyllables {
+		return false
+	}
+	if syllables == readabilityComplexMinSyllables {
+		suffixed := string(word)
+		if strings.HasSuffix(suffixed, "es") || strings.HasSuffix(suffixed, "ed") || strings.HasSuffix(suffixed, "ing") {
+			return false
+		}
+	}
+	return true
+}
+
+func addReadability(total *models.ReadabilityCounts, counts models.ReadabilityCounts) {
+	total.Words += counts.Words
+	total.Sentences += counts.Sentences
+	total.Syllables += counts.Syllables
+	total.ComplexWords += counts.ComplexWords
+	total.LongWords += counts.LongWords
+}
+
+// readabilityRow is the readability of one title or agency.
+type readabilityRow struct {
+	ID          uuid.UUID
+	Key         string
+	Name        string
+	Readability models.ReadabilityCounts `gorm:"embedded;embeddedPrefix:readability_"`
+}
+
+const readabilitySums = `
+	SUM(sn.readability_words) AS readability_words,
+	SUM(sn.readability_sentences) AS readability_sentences,
+	SUM(sn.readability_syllables) AS readability_syllables,
+	SUM(sn.readability_complex_words) AS readability_complex_words,
+	SUM(sn.readability_long_words) AS readability_long_words`
+
+// titleReadabilityRows sums the readability of the title node of each title's
+// content version in effect on asOf.
+func titleReadabilityRows(asOf *time.Time) ([]readabilityRow, error) {
+	var rows []readabilityRow
+	err := database.DB.Raw(`
+		SELECT t.id, t.number::text AS key, t.name, `+readabilitySums+`
+		FROM title_contents_as_of(CAST(@as_of AS date)) tc
+		JOIN titles t ON t.id = tc.title_id
+		JOIN structure_nodes sn ON sn.title_content_id = tc.id AND sn.parent_id IS NULL
+		GROUP BY t.id, t.number, t.name
+	`, asOfArg(asOf)).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch title readability: %w", err)
+	}
+	return rows, nil
+}
+
+// agencyReadabilityRows sums the readability of the chapters, subchapters and
+// parts attributed to each agency through its CFR references, or only to
+// agencyID when it is set.
+func agencyReadabilityRows(asOf *time.Time, agencyID *uuid.UUID) ([]readabilityRow, error) {
+	filters := ""
+	if agencyID != nil {
+		filters = `
+		WHERE aca.agency_id = @agency`
+	}
+
+	var rows []readabilityRow
+	err := database.DB.Raw(`
+		SELECT a.id, a.slug AS key, a.name, `+readabilitySums+`
+		FROM agency_content_attribution_as_of(CAST(@as_of AS date)) aca
+		JOIN structure_nodes sn ON sn.id = aca.node_id
+		JOIN agencies a ON a.id = aca.agency_id AND a.deleted_at IS NULL`+filters+`
+		GROUP BY a.id, a.slug, a.name
+	`, asOfArg(asOf), sql.Named("agency", agencyID)).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agency readability: %w", err)
+	}
+	return rows, nil
+}
+
+func readabilityByID(rows []readabilityRow) map[uuid.UUID]*ReadabilityScores {
+	scores := make(map[uuid.UUID]*ReadabilityScores, len(rows))
+	for _, row := range rows {
+		if score := ReadabilityOf(row.Readability); score != nil {
+			scores[row.ID] = score
+		}
+	}
+	return scores
+}
+
+// TitleReadabilityAsOf returns the readability of every title with parsed
+// content in effect on asOf, keyed by title.
+func TitleReadabilityAsOf(asOf *time.Time) (map[uuid.UUID]*ReadabilityScores, error) {
+	rows, err := titleReadabilityRows(asOf)
+	if err != nil {
+		return nil, err
+	}
+	return readabilityByID(rows), nil
+}
+
+// AgencyReadabilityAsOf returns the readability of the content attributed to
+// every agency, keyed by agency.
+func AgencyReadabilityAsOf(asOf *time.Time) (map[uuid.UUID]*ReadabilityScores, error) {
+	rows, err := agencyReadabilityRows(asOf, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readabilityByID(rows), nil
+}
+
+// ReadabilityOfAgencyAsOf returns the readability of the content attributed
+// to one agency, or nil when none of it is parsed.
+func ReadabilityOfAgencyAsOf(asOf *time.Time, agencyID uuid.UUID) (*ReadabilityScores, error) {
+	rows, err := agencyReadabilityRows(asOf, &agencyID)
+	if err != nil {
+		return nil, err
+	}
+	return readabilityByID(rows)[agencyID], nil
+}
+
+// ReadabilityParams selects what ReadabilityRanking ranks. TitleNumber
+// narrows sections and titles, AgencySlug sections and agencies. Items with
+// fewer than MinWords words are left out, as scores of very short texts are
+// not meaningful.
+type ReadabilityParams struct {
+	Level       string
+	SortBy      string
+	TitleNumber *int
+	AgencySlug  string
+	AsOf        *time.Time
+	MinWords    int
+	Limit       int
+}
+
+// ReadabilityItem is one ranked section, title or agency. Key is the node ID,
+// title number or agency slug.
+type ReadabilityItem struct {
+	Level     string            `json:"level"`
+	Key       string            `json:"key"`
+	Name      string            `json:"name"`
+	Citation  string            `json:"citation,omitempty"`
+	Words     int               `json:"words"`
+	Sentences int               `json:"sentences"`
+	Scores    ReadabilityScores `json:"scores"`
+}
+
+// ReadabilityRanking ranks sections, titles or agencies by a readability
+// score, hardest to read first.
+func ReadabilityRanking(params ReadabilityParams) ([]ReadabilityItem, error) {
+	if params.Level == ReadabilityLevelSection {
+		return sectionReadabilityRanking(params)
+	}
+
+	var rows []readabilityRow
+	var err error
+	if params.Level == ReadabilityLevelAgency {
+		rows, err = agencyReadabilityRows(params.AsOf, nil)
+	} else {
+		rows, err = titleReadabilityRows(params.AsOf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := []ReadabilityItem{}
+	for _, row := range rows {
+		if params.Level == ReadabilityLevelAgency && params.AgencySlug != "" && row.Key != params.AgencySlug {
+			continue
+		}
+		if params.Level == ReadabilityLevelTitle && params.TitleNumber != nil && row.Key != fmt.Sprint(*params.TitleNumber) {
+			continue
+		}
+		scores := ReadabilityOf(row.Readability)
+		if scores == nil || row.Readability.Words < params.MinWords {
+			continue
+		}
+		items = append(items, ReadabilityItem{
+			Level:     params.Level,
+			Key:       row.Key,
+			Name:      row.Name,
+			Words:     row.Readability.Words,
+			Sentences: row.Readability.Sentences,
+			Scores:    *scores,
+		})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Scores.value(params.SortBy), items[j].Scores.value(params.SortBy)
+		if a != b {
+			return a > b
+		}
+		return items[i].Key < items[j].Key
+	})
+	if len(items) > params.Limit {
+		items = items[:params.Limit]
+	}
+	return items, nil
+}
+
+// sectionReadabilityRanking ranks sections and appendices in SQL, as there
+// are too many to score in memory.
+func sectionReadabilityRanking(params ReadabilityParams) ([]ReadabilityItem, error) {
+	filters := ""
+	if params.TitleNumber != nil {
+		filters += " AND t.number = @title"
+	}
+	if params.AgencySlug != "" {
+		filters += `
+		AND EXISTS (
+			SELECT 1 FROM agency_sections ags
+			JOIN agencies a ON a.id = ags.agency_id AND a.deleted_at IS NULL
+			WHERE ags.node_id = sn.id AND a.slug = @agency
+		)`
+	}
+
+	var rows []struct {
+		ID          uuid.UUID
+		TitleNumber int
+		NodeType    string
+		Identifier  string
+		Heading     string
+		Part        *string
+		Readability models.ReadabilityCounts `gorm:"embedded;embeddedPrefix:readability_"`
+	}
+	err := database.DB.Raw(`
+		SELECT
+			sn.id,
+			t.number AS title_number,
+			sn.node_type,
+			sn.identifier,
+			sn.heading,
+			sn.part,
+			sn.readability_words,
+			sn.readability_sentences,
+			sn.readability_syllables,
+			sn.readability_complex_words,
+			sn.readability_long_words
+		FROM title_contents_as_of(CAST(@as_of AS date)) tc
+		JOIN titles t ON t.id = tc.title_id
+		JOIN structure_nodes sn ON sn.title_content_id = tc.id
+		WHERE sn.node_type IN ('section', 'appendix')
+			AND sn.readability_sentences > 0
+			AND sn.readability_words >= @min_words`+filters+`
+		ORDER BY `+readabilitySortExpressions[params.SortBy]+` DESC, t.number, sn.position
+		LIMIT @limit
+	`,
+		asOfArg(params.AsOf),
+		sql.Named("title", params.TitleNumber),
+		sql.Named("agency", params.AgencySlug),
+		sql.Named("min_words", params.MinWords),
+		sql.Named("limit", params.Limit),
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank section readability: %w", err)
+	}
+
+	items := make([]ReadabilityItem, 0, len(rows))
+	for _, row := range rows {
+		scores := ReadabilityOf(row.Readability)
+		if scores == nil {
+			continue
+		}
+		items = append(items, ReadabilityItem{
+			Level:     params.Level,
+			Key:       row.ID.String(),
+			Name:      row.Heading,
+			Citation:  sectionCitation(row.TitleNumber, row.NodeType, row.Identifier, row.Part),
+			Words:     row.Readability.Words,
+			Sentences: row.Readability.Sentences,
+			Scores:    *scores,
+		})
+	}
+	return items, nil
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// ErrSectionNotFound is returned when a title has no section or appendix with
+// the requested identifier in the content in effect.
+var ErrSectionNotFound = errors.New("section not found")
+
+// ReferenceLink is one end of a reference: a target cited by a section, or a
+// section citing one. Type is "section", "part" or "usc" for targets and
+// "section" or "appendix" for citing sections. NodeID and Heading are set when
+// a CFR target is found in the content in effect.
+type ReferenceLink struct {
+	Type        string     `json:"type"`
+	TitleNumber int        `json:"titleNumber"`
+	Identifier  string     `json:"identifier"`
+	Part        *string    `json:"part,omitempty"`
+	Citation    string     `json:"citation"`
+	NodeID      *uuid.UUID `json:"nodeId,omitempty"`
+	Heading     *string    `json:"heading,omitempty"`
+	Occurrences int        `json:"occurrences"`
+}
+
+// SectionReferenceGraph is what a section cites and the sections citing it.
+type SectionReferenceGraph struct {
+	Section     ReferenceLink   `json:"section"`
+	ContentDate time.Time       `json:"contentDate"`
+	Outbound    []ReferenceLink `json:"outbound"`
+	Inbound     []ReferenceLink `json:"inbound"`
+}
+
+// CitedTarget is a section, part or U.S.C. section with the number of
+// sections citing it and how often they do.
+type CitedTarget struct {
+	Type           string     `json:"type"`
+	TitleNumber    int        `json:"titleNumber"`
+	Identifier     string     `json:"identifier"`
+	Citation       string     `json:"citation"`
+	NodeID         *uuid.UUID `json:"nodeId,omitempty"`
+	Heading        *string    `json:"heading,omitempty"`
+	CitingSections int        `json:"citingSections"`
+	Occurrences    int        `json:"occurrences"`
+}
+
+// AgencyDependency counts the references from the sections of one agency to
+// the sections and parts of another.
+type AgencyDependency struct {
+	FromSlug       string `json:"fromAgency"`
+	FromName       string `json:"fromName"`
+	ToSlug         string `json:"toAgency"`
+	ToName         string `json:"toName"`
+	References     int    `json:"references"`
+	CitingSections int    `json:"citingSections"`
+}
+
+// currentContents is the content version of each title in effect on as_of.
+const currentContents = `
+	WITH current_contents AS (
+		SELECT id, title_id, content_date FROM title_contents_as_of(CAST(@as_of AS date))
+	)`
+
+// resolvedTarget finds the node of the section or part cited by the row
+// "target" in the content in effect; U.S.C. targets never have one.
+const resolvedTarget = `
+	LEFT JOIN LATERAL (
+		SELECT sn.id, sn.heading
+		FROM titles tt
+		JOIN current_contents ttc ON ttc.title_id = tt.id
+		JOIN structure_nodes sn ON sn.title_content_id = ttc.id
+		WHERE tt.number = target.target_title
+			AND sn.node_type = target.target_type
+			AND sn.identifier = target.target_identifier
+		ORDER BY sn.position
+		LIMIT 1
+	) resolved ON true`
+
+// SectionReferencesOf returns the references from and to a section or
+// appendix of a title, in the content in effect on asOf. Inbound references
+// are citations of the section itself, not of its part.
+func SectionReferencesOf(titleNumber int, identifier string, asOf *time.Time) (*SectionReferenceGraph, error) {
+	args := []interface{}{
+		asOfArg(asOf),
+		sql.Named("title", titleNumber),
+		sql.Named("identifier", identifier),
+	}
+
+	var section struct {
+		ReferenceLink
+		ContentDate time.Time
+	}
+	err := database.DB.Raw(currentContents+`
+		SELECT sn.id AS node_id, sn.node_type AS type, t.number AS title_number,
+			sn.identifier, sn.part, sn.heading, tc.content_date
+		FROM titles t
+		JOIN current_contents tc ON tc.title_id = t.id
+		JOIN structure_nodes sn ON sn.title_content_id = tc.id
+		WHERE t.number = @title
+			AND sn.node_type IN ('section', 'appendix')
+			AND sn.identifier = @identifier
+		ORDER BY sn.position
+		LIMIT 1
+	`, args...).Scan(&section).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find section: %w", err)
+	}
+	if section.NodeID == nil {
+		return nil, ErrSectionNotFound
+	}
+	graph := &SectionReferenceGraph{
+		Section:     section.ReferenceLink,
+		ContentDate: section.ContentDate,
+		Outbound:    []ReferenceLink{},
+		Inbound:     []ReferenceLink{},
+	}
+	graph.Section.Citation = referenceCitation(graph.Section.Type, titleNumber, identifier, graph.Section.Part)
+	args = append(args, sql.Named("node", *section.NodeID))
+
+	err = database.DB.Raw(currentContents+`
+		SELECT target.target_type AS type, target.target_title AS title_number,
+			target.target_identifier AS identifier, target.occurrences,
+			resolved.id AS node_id, resolved.heading
+		FROM section_references target
+		`+resolvedTarget+`
+		WHERE target.source_node_id = @node
+		ORDER BY target.target_type, target.target_title, target.target_identifier
+	`, args...).Scan(&graph.Outbound).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch outbound references: %w", err)
+	}
+
+	err = database.DB.Raw(currentContents+`
+		SELECT sn.node_type AS type, t.number AS title_number, sn.identifier,
+			sn.part, sn.id AS node_id, sn.heading, sr.occurrences
+		FROM section_references sr
+		JOIN current_contents tc ON tc.id = sr.title_content_id
+		JOIN structure_nodes sn ON sn.id = sr.source_node_id
+		JOIN titles t ON t.id = sn.title_id
+		WHERE sr.target_type = 'section'
+			AND sr.target_title = @title
+			AND sr.target_identifier = @identifier
+		ORDER BY t.number, sn.position
+	`, args...).Scan(&graph.Inbound).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch inbound references: %w", err)
+	}
+
+	for i := range graph.Outbound {
+		link := &graph.Outbound[i]
+		link.Citation = referenceCitation(link.Type, link.TitleNumber, link.Identifier, nil)
+	}
+	for i := range graph.Inbound {
+		link := &graph.Inbound[i]
+		link.Citation = referenceCitation(link.Type, link.TitleNumber, link.Identifier, link.Part)
+	}
+	return graph, nil
+}
+
+// MostCited ranks the targets of one type by the number of sections citing
+// them in the content in effect on asOf. titleNumber narrows the targets to
+// one CFR or U.S.C. title.
+func MostCited(targetType string, titleNumber *int, asOf *time.Time, limit int) ([]CitedTarget, error) {
+	filters := ""
+	if titleNumber != nil {
+		filters = " AND sr.target_title = @title"
+	}
+
+	targets := []CitedTarget{}
+	err := database.DB.Raw(currentContents+`
+		SELECT target.target_type AS type, target.target_title AS title_number,
+			target.target_identifier AS identifier, target.citing_sections,
+			target.occurrences, resolved.id AS node_id, resolved.heading
+		FROM (
+			SELECT sr.target_type, sr.target_title, sr.target_identifier,
+				COUNT(DISTINCT sr.source_node_id) AS citing_sections,
+				SUM(sr.occurrences) AS occurrences
+			FROM section_references sr
+			JOIN current_contents tc ON tc.id = sr.title_content_id
+			WHERE sr.target_type = @type`+filters+`
+			GROUP BY sr.target_type, sr.target_title, sr.target_identifier
+			ORDER BY citing_sections DESC, occurrences DESC, sr.target_title, sr.target_identifier
+			LIMIT @limit
+		) target
+		`+resolvedTarget+`
+		ORDER BY target.citing_sections DESC, target.occurrences DESC, target.target_title, target.target_identifier
+	`, asOfArg(asOf), sql.Named("type", targetType), sql.Named("title", titleNumber), sql.Named("limit", limit)).Scan(&targets).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank cited %ss: %w", targetType, err)
+	}
+	for i := range targets {
+		target := &targets[i]
+		target.Citation = referenceCitation(target.Type, target.TitleNumber, target.Identifier, nil)
+	}
+	return targets, nil
+}
+
+// AgencyDependencies counts how often the sections attributed to one agency
+// cite sections or parts attributed to another, in the content in effect on
+// asOf. A cited part counts once however many of its sections belong to the
+// other agency; citations within an agency are left out. agencySlug, when
+// set, keeps the dependencies from or to that agency.
+func AgencyDependencies(agencySlug string, asOf *time.Time, limit int) ([]AgencyDependency, error) {
+	filters := ""
+	if agencySlug != "" {
+		filters = " AND (fa.slug = @agency OR ta.slug = @agency)"
+	}
+
+	dependencies := []AgencyDependency{}
+	err := database.DB.Raw(currentContents+`,
+	resolved_references AS (
+		SELECT sr.id AS reference_id, sr.source_node_id, target.id AS target_node_id
+		FROM section_references sr
+		JOIN current_contents tc ON tc.id = sr.title_content_id
+		JOIN titles tt ON tt.number = sr.target_title
+		JOIN current_contents ttc ON ttc.title_id = tt.id
+		JOIN structure_nodes target ON target.title_content_id = ttc.id
+			AND target.node_type IN ('section', 'appendix')
+			AND CASE sr.target_type
+				WHEN 'section' THEN target.node_type = 'section' AND target.identifier = sr.target_identifier
+				ELSE target.part = sr.target_identifier
+			END
+		WHERE sr.target_type IN ('section', 'part')
+	)
+	SELECT fa.slug AS from_slug, fa.name AS from_name, ta.slug AS to_slug, ta.name AS to_name,
+		COUNT(DISTINCT rr.reference_id) AS "references",
+		COUNT(DISTINCT rr.source_node_id) AS citing_sections
+	FROM resolved_references rr
+	JOIN agency_sections fas ON fas.node_id = rr.source_node_id
+	JOIN agency_sections tas ON tas.node_id = rr.target_node_id
+	JOIN agencies fa ON fa.id = fas.agency_id AND fa.deleted_at IS NULL
+	JOIN agencies ta ON ta.id = tas.agency_id AND ta.deleted_at IS NULL
+	WHERE fa.id <> ta.id`+filters+`
+	GROUP BY fa.slug, fa.name, ta.slug, ta.name
+	ORDER BY "references" DESC, fa.name, ta.name
+	LIMIT @limit
+	`, asOfArg(asOf), sql.Named("agency", agencySlug), sql.Named("limit", limit)).Scan(&dependencies).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count agency dependencies: %w", err)
+	}
+	return dependencies, nil
+}
+
+// referenceCitation formats a reference target or citing section, e.g.
+// "40 CFR 60.1", "40 CFR part 60" or "42 U.S.C. 7401".
+func referenceCitation(referenceType string, titleNumber int, identifier string, part *string) string {
+	switch referenceType {
+	case ReferenceTargetUSC:
+		return fmt.Sprintf("%d U.S.C. %s", titleNumber, identifier)
+	case ReferenceTargetPart:
+		return fmt.Sprintf("%d CFR part %s", titleNumber, identifier)
+	}
+	return sectionCitation(titleNumber, referenceType, identifier, part)
+}
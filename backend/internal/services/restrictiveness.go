@@ -0,0 +1,119 @@
+package services
+
+import (
+	"ecfr-analyzer/internal/models"
+)
+
+// restrictionCounter counts the obligation and prohibition terms ("shall",
+// "must", "may not", "required" and "prohibited") in text or XML written to it
+// in any number of chunks. Terms are matched as whole words regardless of case;
+// tags separate words as whitespace does, and "may not" is only counted when
+// nothing but whitespace or tags separates the two words.
+type restrictionCounter struct {
+	counts models.RestrictionCounts
+	inTag  bool
+
+	// Lowercased letters of the current word; longer words cannot be terms
+	word     [restrictionTermMaxLen + 1]byte
+	wordLen  int
+	afterMay bool
+}
+
+// restrictionTermMaxLen is the length of the longest term, "prohibited"
+const restrictionTermMaxLen = 10
+
+func newRestrictionCounter() *restrictionCounter {
+	return &restrictionCounter{}
+}
+
+func (c *restrictionCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if c.inTag {
+			if b == '>' {
+				c.inTag = false
+			}
+			continue
+		}
+		switch {
+		case b >= 'a' && b <= 'z':
+			c.letter(b)
+		case b >= 'A' && b <= 'Z':
+			c.letter(b + 'a' - 'A')
+		case b == '<':
+			c.endWord()
+			c.inTag = true
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			c.endWord()
+		default:
+			c.endWord()
+			c.afterMay = false
+		}
+	}
+	return len(p), nil
+}
+
+func (c *restrictionCounter) letter(b byte) {
+	if c.wordLen < len(c.word) {
+		c.word[c.wordLen] = b
+	}
+	c.wordLen++
+}
+
+func (c *restrictionCounter) endWord() {
+	if c.wordLen == 0 {
+		return
+	}
+	word := ""
+	if c.wordLen <= restrictionTermMaxLen {
+		word = string(c.word[:c.wordLen])
+	}
+	c.wordLen = 0
+
+	switch word {
+	case "shall":
+		c.counts.Shall++
+	case "must":
+		c.counts.Must++
+	case "required":
+		c.counts.Required++
+	case "prohibited":
+		c.counts.Prohibited++
+	case "not":
+		if c.afterMay {
+			c.counts.MayNot++
+		}
+	}
+	c.afterMay = word == "may"
+}
+
+// Counts returns the terms counted in everything written so far.
+func (c *restrictionCounter) Counts() models.RestrictionCounts {
+	counts := c.counts
+	if c.wordLen > 0 {
+		// The last word may be a term that has not been ended yet
+		pending := *c
+		pending.endWord()
+		counts = pending.counts
+	}
+	return counts
+}
+
+// countRestrictions counts the restriction terms in a piece of text.
+func countRestrictions(text string) models.RestrictionCounts {
+	counter := newRestrictionCounter()
+	counter.Write([]byte(text))
+	return counter.Counts()
+}
+
+func addRestrictions(total *models.RestrictionCounts, counts models.RestrictionCounts) {
+	total.Shall += counts.Shall
+	total.Must += counts.Must
+	total.MayNot += counts.MayNot
+	total.Required += counts.Required
+	total.Prohibited += counts.Prohibited
+}
+
+// RestrictionTotal is the number of restriction terms of all kinds.
+func RestrictionTotal(counts models.RestrictionCounts) int {
+	return counts.Shall + counts.Must + counts.MayNot + counts.Required + counts.Prohibited
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// SearchParams is a full-text search over section text. Query uses web search
+// syntax: quoted phrases, OR and -excluded words. TitleNumber and AgencySlug
+// narrow the search when set; AsOf searches the content versions in effect on
+// that date instead of the latest.
+type SearchParams struct {
+	Query       string
+	TitleNumber *int
+	AgencySlug  string
+	AsOf        *time.Time
+	Limit       int
+	Offset      int
+}
+
+// SearchHit is one matching section or appendix.
+type SearchHit struct {
+	NodeID      uuid.UUID `json:"nodeId"`
+	TitleNumber int       `json:"titleNumber"`
+	TitleName   string    `json:"titleName"`
+	Part        *string   `json:"part,omitempty"`
+	NodeType    string    `json:"nodeType"`
+	Identifier  string    `json:"identifier"`
+	Heading     string    `json:"heading"`
+	Citation    string    `json:"citation"`
+	// Snippet holds the best matching fragments as HTML-escaped text, matches
+	// wrapped in <mark>
+	Snippet     string    `json:"snippet"`
+	Rank        float64   `json:"rank"`
+	ContentDate time.Time `json:"contentDate"`
+}
+
+// SearchFacet counts matching sections per title or agency.
+type SearchFacet struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// SearchResults is one page of hits, best first, with the total number of
+// matches and facet counts over all of them.
+type SearchResults struct {
+	Query    string        `json:"query"`
+	Total    int           `json:"total"`
+	Hits     []SearchHit   `json:"hits"`
+	Titles   []SearchFacet `json:"titleFacets"`
+	Agencies []SearchFacet `json:"agencyFacets"`
+}
+
+// searchMatches selects the section texts matching the search, in the
+// content version of each title in effect on as_of.
+const searchMatches = `
+	FROM section_texts st
+	JOIN title_contents_as_of(CAST(@as_of AS date)) tc ON tc.id = st.title_content_id
+	JOIN titles t ON t.id = st.title_id
+	CROSS JOIN websearch_to_tsquery('english', @query) query
+	WHERE st.search_vector @@ query`
+
+// Search ranks the sections and appendices matching params.Query.
+func Search(params SearchParams) (*SearchResults, error) {
+	filters := ""
+	if params.TitleNumber != nil {
+		filters += " AND t.number = @title"
+	}
+	if params.AgencySlug != "" {
+		filters += `
+		AND EXISTS (
+			SELECT 1 FROM agency_sections ags
+			JOIN agencies a ON a.id = ags.agency_id AND a.deleted_at IS NULL
+			WHERE ags.node_id = st.structure_node_id AND a.slug = @agency
+		)`
+	}
+	args := []interface{}{
+		asOfArg(params.AsOf),
+		sql.Named("query", params.Query),
+		sql.Named("title", params.TitleNumber),
+		sql.Named("agency", params.AgencySlug),
+		sql.Named("limit", params.Limit),
+		sql.Named("offset", params.Offset),
+	}
+	results := &SearchResults{Query: params.Query, Hits: []SearchHit{}}
+
+	err := database.DB.Raw(`SELECT COUNT(*)`+searchMatches+filters, args...).Scan(&results.Total).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search matches: %w", err)
+	}
+	if results.Total == 0 {
+		return results, nil
+	}
+
+	// Snippets are only highlighted for the page returned
+	err = database.DB.Raw(`
+		SELECT
+			page.node_id,
+			page.title_number,
+			page.title_name,
+			sn.part,
+			sn.node_type,
+			sn.identifier,
+			page.heading,
+			page.rank,
+			page.content_date,
+			ts_headline('english', translate(st.text, @start_sel || @stop_sel, ''), page.query,
+				'MaxFragments=2, MinWords=8, MaxWords=30, FragmentDelimiter=" … ", ' ||
+				'StartSel=' || @start_sel || ', StopSel=' || @stop_sel) AS snippet
+		FROM (
+			SELECT
+				st.structure_node_id AS node_id,
+				t.number AS title_number,
+				t.name AS title_name,
+				st.heading,
+				tc.content_date,
+				query,
+				ts_rank_cd(st.search_vector, query) AS rank
+			`+searchMatches+filters+`
+			ORDER BY rank DESC, t.number, st.structure_node_id
+			LIMIT @limit OFFSET @offset
+		) page
+		JOIN section_texts st ON st.structure_node_id = page.node_id
+		JOIN structure_nodes sn ON sn.id = page.node_id
+		ORDER BY page.rank DESC, page.title_number, sn.position
+	`, append(args, sql.Named("start_sel", snippetStartSel), sql.Named("stop_sel", snippetStopSel))...).Scan(&results.Hits).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search section text: %w", err)
+	}
+	for i := range results.Hits {
+		hit := &results.Hits[i]
+		hit.Citation = sectionCitation(hit.TitleNumber, hit.NodeType, hit.Identifier, hit.Part)
+		hit.Snippet = highlightSnippet(hit.Snippet)
+	}
+
+	err = database.DB.Raw(`
+		SELECT t.number::text AS key, t.name, COUNT(*) AS count
+		`+searchMatches+filters+`
+		GROUP BY t.number, t.name
+		ORDER BY count DESC, t.number
+	`, args...).Scan(&results.Titles).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search matches by title: %w", err)
+	}
+
+	err = database.DB.Raw(`
+		SELECT a.slug AS key, a.name, COUNT(DISTINCT matches.node_id) AS count
+		FROM (SELECT st.structure_node_id AS node_id `+searchMatches+filters+`) matches
+		JOIN agency_sections ags ON ags.node_id = matches.node_id
+		JOIN agencies a ON a.id = ags.agency_id AND a.deleted_at IS NULL
+		GROUP BY a.slug, a.name
+		ORDER BY count DESC, a.name
+	`, args...).Scan(&results.Agencies).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search matches by agency: %w", err)
+	}
+	return results, nil
+}
+
+// ts_headline marks matches with these characters, which are removed from the
+// text first, so the text can be escaped before the marks become HTML
+const (
+	snippetStartSel = "\u27e6"
+	snippetStopSel  = "\u27e7"
+)
+
+// highlightSnippet escapes a snippet as HTML and wraps its matches in <mark>.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStartSel, "<mark>")
+	return strings.ReplaceAll(snippet, snippetStopSel, "</mark>")
+}
+
+// sectionCitation formats a section or appendix as a CFR citation, e.g.
+// "12 CFR 1026.5" or "12 CFR Appendix A to Part 1026".
+func sectionCitation(titleNumber int, nodeType, identifier string, part *string) string {
+	if nodeType == NodeTypeSection {
+		return fmt.Sprintf("%d CFR %s", titleNumber, identifier)
+	}
+	if part != nil && !strings.Contains(strings.ToLower(identifier), "part") {
+		return fmt.Sprintf("%d CFR part %s, %s", titleNumber, *part, identifier)
+	}
+	return fmt.Sprintf("%d CFR %s", titleNumber, identifier)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statute types of statute citations
+const (
+	StatuteTypeUSC       = "usc"
+	StatuteTypePublicLaw = "public_law"
+)
+
+// extractedStatute is a statute named in an authority note.
+type extractedStatute struct {
+	Type    string
+	Title   int
+	Section string
+}
+
+// extractedFRCitation is a Federal Register document named in a source note.
+type extractedFRCitation struct {
+	Volume      int
+	Page        int
+	PublishedOn *time.Time
+	Amendment   bool
+}
+
+var (
+	publicLawPattern = regexp.MustCompile(`Pub\.\s*L\.\s*(?:No\.\s*)?(\d+)\s*[-–]\s*(\d+)`)
+	frCitePattern    = regexp.MustCompile(`(\d+)\s+FR\s+(\d+)`)
+	frDatePattern    = regexp.MustCompile(`([A-Z][a-z]+)\.?\s+(\d{1,2}),\s+(\d{4})`)
+	// Source notes name the original document first; documents after these
+	// words changed the text later
+	amendmentPattern = regexp.MustCompile(`(?i)\b(?:amended|redesignated|revised)\b`)
+)
+
+// frMonths maps the month names and abbreviations used by the Federal
+// Register, "Jan.", "June", "Sept.", by their first three letters.
+var frMonths = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March,
+	"apr": time.April, "may": time.May, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+// extractStatutes finds the U.S. Code sections and public laws named in an
+// authority note, e.g. "29 U.S.C. 653, 655, 657; Pub. L. 101-552". Each
+// statute is returned once, U.S. Code sections first.
+func extractStatutes(note string) []extractedStatute {
+	var statutes []extractedStatute
+	seen := make(map[extractedStatute]bool)
+	add := func(statute extractedStatute) {
+		if !seen[statute] {
+			seen[statute] = true
+			statutes = append(statutes, statute)
+		}
+	}
+
+	// CFR citations in the note are not statutes
+	var usc []extractedStatute
+	for reference := range extractReferences(note, 0, "") {
+		if reference.TargetType == ReferenceTargetUSC {
+			usc = append(usc, extractedStatute{
+				Type:    StatuteTypeUSC,
+				Title:   reference.TargetTitle,
+				Section: reference.TargetIdentifier,
+			})
+		}
+	}
+	sort.Slice(usc, func(i, j int) bool {
+		if usc[i].Title != usc[j].Title {
+			return usc[i].Title < usc[j].Title
+		}
+		return usc[i].Section < usc[j].Section
+	})
+	for _, statute := range usc {
+		add(statute)
+	}
+
+	for _, match := range publicLawPattern.FindAllStringSubmatch(note, -1) {
+		congress, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		add(extractedStatute{Type: StatuteTypePublicLaw, Title: congress, Section: match[2]})
+	}
+	return statutes
+}
+
+// extractFederalRegisterCitations finds the Federal Register documents named
+// in a source note, e.g. "39 FR 23502, June 27, 1974, as amended at 61 FR
+// 9239, Mar. 7, 1996". The date of a document is the first date between it
+// and the next one. Each document is returned once.
+func extractFederalRegisterCitations(note string) []extractedFRCitation {
+	matches := frCitePattern.FindAllStringSubmatchIndex(note, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	amendedFrom := len(note)
+	if loc := amendmentPattern.FindStringIndex(note); loc != nil {
+		amendedFrom = loc[0]
+	}
+
+	var citations []extractedFRCitation
+	seen := make(map[[2]int]bool)
+	for i, match := range matches {
+		volume, err := strconv.Atoi(note[match[2]:match[3]])
+		if err != nil {
+			continue
+		}
+		page, err := strconv.Atoi(note[match[4]:match[5]])
+		if err != nil {
+			continue
+		}
+		key := [2]int{volume, page}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		end := len(note)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		citations = append(citations, extractedFRCitation{
+			Volume:      volume,
+			Page:        page,
+			PublishedOn: parseFRDate(note[match[1]:end]),
+			Amendment:   match[0] > amendedFrom,
+		})
+	}
+	return citations
+}
+
+// parseFRDate parses the first date like "Mar. 7, 1996" in the text.
+func parseFRDate(text string) *time.Time {
+	match := frDatePattern.FindStringSubmatch(text)
+	if match == nil || len(match[1]) < 3 {
+		return nil
+	}
+	month, ok := frMonths[strings.ToLower(match[1][:3])]
+	if !ok {
+		return nil
+	}
+	day, _ := strconv.Atoi(match[2])
+	year, _ := strconv.Atoi(match[3])
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day {
+		return nil
+	}
+	return &date
+}
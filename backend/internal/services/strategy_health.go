@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStrategyTimeout bounds a whole title download, body included.
+	DefaultStrategyTimeout = 10 * time.Minute
+	defaultStrategies      = "bulk,api"
+)
+
+// StrategyConfig enables one content strategy.
+type StrategyConfig struct {
+	Key     string
+	Timeout time.Duration
+}
+
+// ContentDownloaderConfig is the strategy chain of a ContentDownloader.
+// Strategies are tried in the listed order; strategies not listed are
+// disabled. A strategy that fails FailureThreshold times in a row is skipped
+// for Cooldown and then probed before it is tried again.
+type ContentDownloaderConfig struct {
+	Strategies       []StrategyConfig
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// DefaultContentDownloaderConfig reads the strategy chain from
+// CONTENT_STRATEGIES, a comma separated list of strategy keys (bulk, api),
+// each optionally followed by a timeout, e.g. "bulk:15m,api:5m". It defaults
+// to bulk first, then the API.
+func DefaultContentDownloaderConfig() ContentDownloaderConfig {
+	config := ContentDownloaderConfig{
+		FailureThreshold: 3,
+		Cooldown:         5 * time.Minute,
+	}
+
+	spec := os.Getenv("CONTENT_STRATEGIES")
+	if spec != "" {
+		strategies, err := ParseContentStrategies(spec)
+		if err == nil {
+			config.Strategies = strategies
+			return config
+		}
+		log.Printf("[CONTENT] Invalid CONTENT_STRATEGIES %q, using %q: %v", spec, defaultStrategies, err)
+	}
+	config.Strategies, _ = ParseContentStrategies(defaultStrategies)
+	return config
+}
+
+// ParseContentStrategies parses a strategy chain such as "bulk:15m,api".
+func ParseContentStrategies(spec string) ([]StrategyConfig, error) {
+	var strategies []StrategyConfig
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, timeoutStr, hasTimeout := strings.Cut(entry, ":")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key != StrategyBulk && key != StrategyAPI {
+			return nil, fmt.Errorf("unknown content strategy %q", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("content strategy %q listed twice", key)
+		}
+		seen[key] = true
+
+		timeout := DefaultStrategyTimeout
+		if hasTimeout {
+			parsed, err := time.ParseDuration(strings.TrimSpace(timeoutStr))
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("invalid timeout for content strategy %q: %q", key, timeoutStr)
+			}
+			timeout = parsed
+		}
+		strategies = append(strategies, StrategyConfig{Key: key, Timeout: timeout})
+	}
+	if len(strategies) == 0 {
+		return nil, errors.New("no content strategy enabled")
+	}
+	return strategies, nil
+}
+
+// Strategy health states reported in StrategyStats.Status
+const (
+	strategyHealthy     = "healthy"
+	strategyCoolingDown = "cooling_down"
+	strategyProbeDue    = "probe_due"
+)
+
+// errProbeInProgress is returned by a probe started while another download
+// is already probing the same strategy.
+var errProbeInProgress = errors.New("probe already in progress")
+
+// StrategyStats describes how a content strategy has performed since the
+// server started.
+type StrategyStats struct {
+	Key                 string     `json:"key"`
+	Name                string     `json:"name"`
+	Position            int        `json:"position"`
+	TimeoutSeconds      float64    `json:"timeoutSeconds"`
+	Status              string     `json:"status"`
+	Attempts            int64      `json:"attempts"`
+	Successes           int64      `json:"successes"`
+	NotModified         int64      `json:"notModified"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	SuccessRate         *float64   `json:"successRate,omitempty"`
+	AverageLatencyMs    *float64   `json:"averageLatencyMs,omitempty"`
+	BytesDownloaded     int64      `json:"bytesDownloaded"`
+	LastError           *string    `json:"lastError,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+	LastFailureAt       *time.Time `json:"lastFailureAt,omitempty"`
+	LastProbeAt         *time.Time `json:"lastProbeAt,omitempty"`
+	LastProbeError      *string    `json:"lastProbeError,omitempty"`
+	RetryAfter          *time.Time `json:"retryAfter,omitempty"`
+}
+
+// strategyState is a configured strategy with its running statistics.
+type strategyState struct {
+	key      string
+	timeout  time.Duration
+	strategy ContentDownloadStrategy
+
+	mutex               sync.Mutex
+	attempts            int64
+	successes           int64
+	notModified         int64
+	failures            int64
+	consecutiveFailures int
+	// downloadTime is the total time of successful downloads, body included
+	downloadTime   time.Duration
+	bytes          int64
+	lastError      string
+	lastSuccessAt  time.Time
+	lastFailureAt  time.Time
+	lastProbeAt    time.Time
+	lastProbeError string
+	retryAfter     time.Time
+	// probing is set while a probe runs, so concurrent downloads finding a
+	// probe due do not all run it
+	probing bool
+}
+
+func (s *strategyState) health(config ContentDownloaderConfig) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.healthLocked(config)
+}
+
+func (s *strategyState) healthLocked(config ContentDownloaderConfig) string {
+	if config.FailureThreshold <= 0 || s.consecutiveFailures < config.FailureThreshold {
+		return strategyHealthy
+	}
+	if time.Now().Before(s.retryAfter) {
+		return strategyCoolingDown
+	}
+	return strategyProbeDue
+}
+
+func (s *strategyState) recordSuccess(elapsed time.Duration, bytes int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attempts++
+	s.successes++
+	s.consecutiveFailures = 0
+	s.downloadTime += elapsed
+	s.bytes += bytes
+	s.lastSuccessAt = time.Now().UTC()
+}
+
+func (s *strategyState) recordNotModified() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attempts++
+	s.notModified++
+	s.consecutiveFailures = 0
+	s.lastSuccessAt = time.Now().UTC()
+}
+
+func (s *strategyState) recordFailure(err error, elapsed time.Duration, config ContentDownloaderConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attempts++
+	s.failures++
+	s.consecutiveFailures++
+	s.lastError = err.Error()
+	s.lastFailureAt = time.Now().UTC()
+
+	if config.FailureThreshold > 0 && s.consecutiveFailures >= config.FailureThreshold {
+		if s.consecutiveFailures == config.FailureThreshold {
+			log.Printf("[CONTENT] %s strategy failed %d times in a row, skipping it for %s",
+				s.strategy.GetStrategyName(), s.consecutiveFailures, config.Cooldown)
+		}
+		s.retryAfter = time.Now().Add(config.Cooldown)
+	}
+}
+
+// probe runs the strategy's health probe. A failed probe restarts the
+// cooldown of an unhealthy strategy; a successful one lets it be tried again,
+// and a single further failure makes it unhealthy again. Only one probe of a
+// strategy runs at a time; others return errProbeInProgress.
+func (s *strategyState) probe(ctx context.Context, config ContentDownloaderConfig) error {
+	s.mutex.Lock()
+	if s.probing {
+		s.mutex.Unlock()
+		return errProbeInProgress
+	}
+	s.probing = true
+	s.mutex.Unlock()
+
+	err := s.strategy.Probe(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.probing = false
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	s.lastProbeAt = time.Now().UTC()
+	s.lastProbeError = ""
+	unhealthy := s.healthLocked(config) != strategyHealthy
+	if err != nil {
+		s.lastProbeError = err.Error()
+		if unhealthy {
+			s.retryAfter = time.Now().Add(config.Cooldown)
+		}
+		return err
+	}
+	if unhealthy {
+		log.Printf("[CONTENT] %s strategy probe succeeded, trying it again", s.strategy.GetStrategyName())
+		s.consecutiveFailures = config.FailureThreshold - 1
+	}
+	return nil
+}
+
+func (s *strategyState) stats(position int, config ContentDownloaderConfig) StrategyStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := StrategyStats{
+		Key:                 s.key,
+		Name:                s.strategy.GetStrategyName(),
+		Position:            position,
+		TimeoutSeconds:      s.timeout.Seconds(),
+		Status:              s.healthLocked(config),
+		Attempts:            s.attempts,
+		Successes:           s.successes,
+		NotModified:         s.notModified,
+		Failures:            s.failures,
+		ConsecutiveFailures: s.consecutiveFailures,
+		BytesDownloaded:     s.bytes,
+		LastError:           optionalString(s.lastError),
+		LastSuccessAt:       optionalTime(s.lastSuccessAt),
+		LastFailureAt:       optionalTime(s.lastFailureAt),
+		LastProbeAt:         optionalTime(s.lastProbeAt),
+		LastProbeError:      optionalString(s.lastProbeError),
+	}
+	if s.attempts > 0 {
+		rate := float64(s.successes+s.notModified) / float64(s.attempts)
+		stats.SuccessRate = &rate
+	}
+	if s.successes > 0 {
+		latency := float64(s.downloadTime.Milliseconds()) / float64(s.successes)
+		stats.AverageLatencyMs = &latency
+	}
+	if stats.Status != strategyHealthy {
+		stats.RetryAfter = optionalTime(s.retryAfter)
+	}
+	return stats
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// trackedBody records the outcome of a download on its strategy once the
+// body is closed: a read error counts as a failure and a body read to the end
+// as a success. A body closed early says nothing about the strategy. Closing
+// also ends the download's timeout context.
+type trackedBody struct {
+	io.ReadCloser
+	state   *strategyState
+	started time.Time
+	cancel  context.CancelFunc
+	config  ContentDownloaderConfig
+	bytes   int64
+	readErr error
+	eof     bool
+	closed  bool
+}
+
+func newTrackedBody(body io.ReadCloser, state *strategyState, started time.Time, cancel context.CancelFunc, config ContentDownloaderConfig) *trackedBody {
+	return &trackedBody{
+		ReadCloser: body,
+		state:      state,
+		started:    started,
+		cancel:     cancel,
+		config:     config,
+	}
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil && b.readErr == nil {
+		b.readErr = err
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	elapsed := time.Since(b.started)
+	switch {
+	case b.readErr == nil:
+		if b.eof {
+			b.state.recordSuccess(elapsed, b.bytes)
+		}
+	case errors.Is(b.readErr, context.Canceled):
+		// The import was cancelled
+	default:
+		b.state.recordFailure(b.readErr, elapsed, b.config)
+	}
+	return err
+}
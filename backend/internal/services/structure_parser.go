@@ -0,0 +1,352 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Node types produced by the structure parser. They mirror the TYPE attribute
+// of the eCFR DIV1-DIV9 elements.
+const (
+	NodeTypeTitle        = "title"
+	NodeTypeSubtitle     = "subtitle"
+	NodeTypeChapter      = "chapter"
+	NodeTypeSubchapter   = "subchapter"
+	NodeTypePart         = "part"
+	NodeTypeSubpart      = "subpart"
+	NodeTypeSubjectGroup = "subject_group"
+	NodeTypeSection      = "section"
+	NodeTypeAppendix     = "appendix"
+)
+
+// divLevelTypes maps the DIV element number to its node type, used when the
+// TYPE attribute is missing or unknown.
+var divLevelTypes = map[int]string{
+	1: NodeTypeTitle,
+	2: NodeTypeSubtitle,
+	3: NodeTypeChapter,
+	4: NodeTypeSubchapter,
+	5: NodeTypePart,
+	6: NodeTypeSubpart,
+	7: NodeTypeSubjectGroup,
+	8: NodeTypeSection,
+	9: NodeTypeAppendix,
+}
+
+var divTypeAttributes = map[string]string{
+	"TITLE":    NodeTypeTitle,
+	"SUBTITLE": NodeTypeSubtitle,
+	"CHAPTER":  NodeTypeChapter,
+	"SUBCHAP":  NodeTypeSubchapter,
+	"PART":     NodeTypePart,
+	"SUBPART":  NodeTypeSubpart,
+	"SUBJGRP":  NodeTypeSubjectGroup,
+	"SECTION":  NodeTypeSection,
+	"APPENDIX": NodeTypeAppendix,
+}
+
+// ParsedNode is a single DIV element of the eCFR hierarchy as emitted by
+// ParseTitleStructure. Word count, restriction and readability counts and
+// checksum cover the node and all of its descendants; Text only holds the
+// text that belongs to the node itself. AuthorityNote and SourceNote hold the
+// text of the node's own AUTH element and of its SOURCE or CITA elements.
+type ParsedNode struct {
+	ID         uuid.UUID
+	ParentID   *uuid.UUID
+	Level      int
+	Type       string
+	Identifier string
+	Heading    string
+	Position   int
+	Chapter    string
+	Subchapter string
+	Part       string
+	Text       string
+	WordCount  int
+	Checksum   string
+
+	AuthorityNote string
+	SourceNote    string
+
+	Restrictions models.RestrictionCounts
+	Readability  models.ReadabilityCounts
+}
+
+type openNode struct {
+	node      *ParsedNode
+	text      strings.Builder
+	hasher    hash.Hash
+	depth     int // nesting of non-DIV elements inside this node
+	inHead    bool
+	headDepth int
+	head      strings.Builder
+
+	// The AUTH, SOURCE or CITA element being read, if any
+	note      string
+	noteDepth int
+	noteText  strings.Builder
+}
+
+// noteElements are the elements holding the authority and source notes
+var noteElements = map[string]bool{"AUTH": true, "SOURCE": true, "CITA": true}
+
+// ParseTitleStructure streams an eCFR title XML document and calls visit for
+// every DIV node once its closing tag has been read, so children are always
+// visited before their parent. Node IDs are assigned when the node opens,
+// which lets callers persist children before the parent is complete.
+func ParseTitleStructure(r io.Reader, visit func(node *ParsedNode) error) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var stack []*openNode
+	position := 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse title XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if level, ok := divLevel(t.Name.Local); ok {
+				position++
+				stack = append(stack, newOpenNode(t, level, position, stack))
+				continue
+			}
+			if len(stack) == 0 {
+				continue
+			}
+			current := stack[len(stack)-1]
+			if current.inHead {
+				current.headDepth++
+			} else if t.Name.Local == "HEAD" && current.depth == 0 && current.node.Heading == "" {
+				current.inHead = true
+			}
+			if current.note != "" {
+				current.noteDepth++
+			} else if noteElements[t.Name.Local] && current.depth == 0 {
+				current.note = t.Name.Local
+			}
+			current.depth++
+
+		case xml.EndElement:
+			if _, ok := divLevel(t.Name.Local); ok {
+				if len(stack) == 0 {
+					continue
+				}
+				closed := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				closed.finish()
+				// Readability is counted per node's own text and then rolled up
+				if len(stack) > 0 {
+					addReadability(&stack[len(stack)-1].node.Readability, closed.node.Readability)
+				}
+				if err := visit(closed.node); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(stack) == 0 {
+				continue
+			}
+			current := stack[len(stack)-1]
+			current.depth--
+			if current.inHead {
+				if current.headDepth == 0 {
+					current.inHead = false
+					current.node.Heading = normalizeWhitespace(current.head.String())
+				} else {
+					current.headDepth--
+				}
+			}
+			if current.note != "" {
+				if current.noteDepth == 0 {
+					current.finishNote()
+				} else {
+					current.noteDepth--
+				}
+			}
+
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			text := string(t)
+			words := countWords(text)
+			var restrictions models.RestrictionCounts
+			if words > 0 {
+				restrictions = countRestrictions(text)
+			}
+			for _, open := range stack {
+				open.node.WordCount += words
+				if words > 0 {
+					open.hasher.Write([]byte(text))
+					addRestrictions(&open.node.Restrictions, restrictions)
+				}
+			}
+			current := stack[len(stack)-1]
+			current.text.WriteString(text)
+			current.text.WriteByte(' ')
+			if current.inHead {
+				current.head.WriteString(text)
+				current.head.WriteByte(' ')
+			}
+			if current.note != "" {
+				current.noteText.WriteString(text)
+				current.noteText.WriteByte(' ')
+			}
+		}
+	}
+
+	if len(stack) > 0 {
+		return fmt.Errorf("failed to parse title XML: %d unclosed DIV elements", len(stack))
+	}
+	return nil
+}
+
+func newOpenNode(start xml.StartElement, level, position int, stack []*openNode) *openNode {
+	node := &ParsedNode{
+		ID:       uuid.New(),
+		Level:    level,
+		Type:     divLevelTypes[level],
+		Position: position,
+	}
+
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "N":
+			node.Identifier = normalizeIdentifier(attr.Value)
+		case "TYPE":
+			if nodeType, ok := divTypeAttributes[strings.ToUpper(attr.Value)]; ok {
+				node.Type = nodeType
+			}
+		}
+	}
+
+	if len(stack) > 0 {
+		parent := stack[len(stack)-1].node
+		node.ParentID = &parent.ID
+		node.Chapter = parent.Chapter
+		node.Subchapter = parent.Subchapter
+		node.Part = parent.Part
+	}
+
+	switch node.Type {
+	case NodeTypeChapter:
+		node.Chapter = node.Identifier
+	case NodeTypeSubchapter:
+		node.Subchapter = node.Identifier
+	case NodeTypePart:
+		node.Part = node.Identifier
+	}
+
+	return &openNode{node: node, hasher: sha256.New()}
+}
+
+func (o *openNode) finish() {
+	o.node.Text = normalizeWhitespace(o.text.String())
+	o.node.Checksum = fmt.Sprintf("%x", o.hasher.Sum(nil))
+	addReadability(&o.node.Readability, countReadability(o.node.Text))
+}
+
+// finishNote keeps the text of the note element that just closed.
+func (o *openNode) finishNote() {
+	text := normalizeWhitespace(o.noteText.String())
+	o.noteText.Reset()
+	note := &o.node.SourceNote
+	if o.note == "AUTH" {
+		note = &o.node.AuthorityNote
+	}
+	o.note = ""
+	if text == "" {
+		return
+	}
+	if *note != "" {
+		*note += " "
+	}
+	*note += text
+}
+
+// divLevel reports the DIV number for element names DIV1 through DIV9.
+func divLevel(name string) (int, bool) {
+	if len(name) != 4 || !strings.HasPrefix(name, "DIV") {
+		return 0, false
+	}
+	level, err := strconv.Atoi(name[3:])
+	if err != nil || level < 1 || level > 9 {
+		return 0, false
+	}
+	return level, true
+}
+
+// normalizeIdentifier strips the section sign and surrounding whitespace so
+// bulk ("§ 1.1") and versioner ("1.1") identifiers compare equal.
+func normalizeIdentifier(identifier string) string {
+	identifier = strings.ReplaceAll(identifier, "§", "")
+	return strings.TrimSpace(identifier)
+}
+
+func normalizeWhitespace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func countWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+// BuildTitleStructure parses title XML into the same tree shape the versioner
+// structure API returns. size gives the size of a node's own text; children's
+// sizes are added to their parent's.
+func BuildTitleStructure(r io.Reader, size func(node *ParsedNode) int) (*TitleStructure, error) {
+	nodes := make(map[uuid.UUID]*TitleStructure)
+	children := make(map[uuid.UUID][]uuid.UUID)
+	var root *TitleStructure
+
+	err := ParseTitleStructure(r, func(node *ParsedNode) error {
+		structure := &TitleStructure{
+			Identifier: node.Identifier,
+			Label:      node.Heading,
+			Size:       size(node),
+			Type:       node.Type,
+		}
+		for _, childID := range children[node.ID] {
+			child := nodes[childID]
+			structure.Size += child.Size
+			structure.Children = append(structure.Children, *child)
+			delete(nodes, childID)
+		}
+		delete(children, node.ID)
+		nodes[node.ID] = structure
+
+		if node.ParentID == nil {
+			root = structure
+		} else {
+			children[*node.ParentID] = append(children[*node.ParentID], node.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no title element found")
+	}
+	return root, nil
+}
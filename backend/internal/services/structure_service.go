@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const structureBatchSize = 500
+
+type StructureService struct{}
+
+func NewStructureService() *StructureService {
+	return &StructureService{}
+}
+
+// StoreStructure parses the XML of a stored title content version and replaces
+// its structure nodes, the references its sections cite and the statute and
+// Federal Register citations of its authority and source notes. The restriction
+// count of the version is set to that of its title node, so it always equals
+// the sum over its sections. It returns the number of nodes written.
+func (s *StructureService) StoreStructure(content *models.TitleContent, xml io.Reader) (int, error) {
+	total := 0
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("title_content_id = ?", content.ID).Delete(&models.StatuteCitation{}).Error; err != nil {
+			return fmt.Errorf("failed to clear statute citations: %w", err)
+		}
+		if err := tx.Where("title_content_id = ?", content.ID).Delete(&models.FederalRegisterCitation{}).Error; err != nil {
+			return fmt.Errorf("failed to clear Federal Register citations: %w", err)
+		}
+		if err := tx.Where("title_content_id = ?", content.ID).Delete(&models.SectionReference{}).Error; err != nil {
+			return fmt.Errorf("failed to clear section references: %w", err)
+		}
+		if err := tx.Where("title_content_id = ?", content.ID).Delete(&models.SectionText{}).Error; err != nil {
+			return fmt.Errorf("failed to clear section texts: %w", err)
+		}
+		if err := tx.Where("title_content_id = ?", content.ID).Delete(&models.StructureNode{}).Error; err != nil {
+			return fmt.Errorf("failed to clear structure nodes: %w", err)
+		}
+
+		// Citations without a title number are in the title of the section
+		var titleNumber int
+		if err := tx.Raw("SELECT number FROM titles WHERE id = ?", content.TitleID).Scan(&titleNumber).Error; err != nil {
+			return fmt.Errorf("failed to load title number: %w", err)
+		}
+
+		batch := make([]models.StructureNode, 0, structureBatchSize)
+		var texts []models.SectionText
+		var references []models.SectionReference
+		var statutes []models.StatuteCitation
+		var frCitations []models.FederalRegisterCitation
+		var restrictions *models.RestrictionCounts
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := tx.CreateInBatches(batch, structureBatchSize).Error; err != nil {
+				return fmt.Errorf("failed to store structure nodes: %w", err)
+			}
+			total += len(batch)
+			batch = batch[:0]
+			// Rows of nodes without text, like a part's notes, can be all a
+			// batch holds
+			if len(texts) > 0 {
+				if err := tx.CreateInBatches(texts, structureBatchSize).Error; err != nil {
+					return fmt.Errorf("failed to store section texts: %w", err)
+				}
+				texts = texts[:0]
+			}
+			if len(references) > 0 {
+				if err := tx.CreateInBatches(references, structureBatchSize).Error; err != nil {
+					return fmt.Errorf("failed to store section references: %w", err)
+				}
+				references = references[:0]
+			}
+			if len(statutes) > 0 {
+				if err := tx.CreateInBatches(statutes, structureBatchSize).Error; err != nil {
+					return fmt.Errorf("failed to store statute citations: %w", err)
+				}
+				statutes = statutes[:0]
+			}
+			if len(frCitations) > 0 {
+				if err := tx.CreateInBatches(frCitations, structureBatchSize).Error; err != nil {
+					return fmt.Errorf("failed to store Federal Register citations: %w", err)
+				}
+				frCitations = frCitations[:0]
+			}
+			return nil
+		}
+
+		err := ParseTitleStructure(xml, func(node *ParsedNode) error {
+			batch = append(batch, models.StructureNode{
+				ID:             node.ID,
+				TitleContentID: content.ID,
+				TitleID:        content.TitleID,
+				ParentID:       node.ParentID,
+				NodeType:       node.Type,
+				Level:          node.Level,
+				Identifier:     node.Identifier,
+				Heading:        node.Heading,
+				Position:       node.Position,
+				Chapter:        optionalString(node.Chapter),
+				Subchapter:     optionalString(node.Subchapter),
+				Part:           optionalString(node.Part),
+				WordCount:      node.WordCount,
+				Checksum:       node.Checksum,
+				Restrictions:   node.Restrictions,
+				Readability:    node.Readability,
+			})
+			if node.ParentID == nil {
+				restrictions = &node.Restrictions
+			}
+			// Sections and appendices hold the text; it is kept for search
+			if node.Type == NodeTypeSection || node.Type == NodeTypeAppendix {
+				texts = append(texts, models.SectionText{
+					StructureNodeID: node.ID,
+					TitleContentID:  content.ID,
+					TitleID:         content.TitleID,
+					Heading:         node.Heading,
+					Text:            node.Text,
+				})
+				for target, occurrences := range extractReferences(node.Text, titleNumber, node.Identifier) {
+					references = append(references, models.SectionReference{
+						TitleContentID:   content.ID,
+						SourceNodeID:     node.ID,
+						TargetType:       target.TargetType,
+						TargetTitle:      target.TargetTitle,
+						TargetIdentifier: target.TargetIdentifier,
+						Occurrences:      occurrences,
+					})
+				}
+			}
+			for _, statute := range extractStatutes(node.AuthorityNote) {
+				statutes = append(statutes, models.StatuteCitation{
+					TitleContentID:  content.ID,
+					StructureNodeID: node.ID,
+					TitleID:         content.TitleID,
+					Part:            optionalString(node.Part),
+					StatuteType:     statute.Type,
+					StatuteTitle:    statute.Title,
+					StatuteSection:  statute.Section,
+				})
+			}
+			for _, citation := range extractFederalRegisterCitations(node.SourceNote) {
+				frCitations = append(frCitations, models.FederalRegisterCitation{
+					TitleContentID:  content.ID,
+					StructureNodeID: node.ID,
+					TitleID:         content.TitleID,
+					Part:            optionalString(node.Part),
+					Volume:          citation.Volume,
+					Page:            citation.Page,
+					PublishedOn:     citation.PublishedOn,
+					Amendment:       citation.Amendment,
+				})
+			}
+			if len(batch) >= structureBatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+
+		extractedAt := time.Now()
+		updates := map[string]interface{}{
+			"references_extracted_at": extractedAt,
+			"notes_extracted_at":      extractedAt,
+		}
+		content.ReferencesExtractedAt = &extractedAt
+		content.NotesExtractedAt = &extractedAt
+		if restrictions != nil {
+			restrictionCount := RestrictionTotal(*restrictions)
+			content.RestrictionCount = &restrictionCount
+			updates["restriction_count"] = restrictionCount
+		}
+		return tx.Model(&models.TitleContent{}).Where("id = ?", content.ID).Updates(updates).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// StoreMissingStructures parses every stored title content version that has
+// no structure nodes yet, or whose sections were parsed before their text was
+// kept for search, their restriction terms and readability were counted or
+// their citations and authority and source notes were extracted.
+func (s *StructureService) StoreMissingStructures() error {
+	var contentIDs []string
+	err := database.DB.Raw(`
+		SELECT tc.id
+		FROM title_contents tc
+		WHERE NOT EXISTS (SELECT 1 FROM structure_nodes sn WHERE sn.title_content_id = tc.id)
+			OR tc.restriction_count IS NULL
+			OR tc.references_extracted_at IS NULL
+			OR tc.notes_extracted_at IS NULL
+			OR EXISTS (
+				SELECT 1 FROM structure_nodes sn
+				WHERE sn.title_content_id = tc.id AND sn.parent_id IS NULL
+					AND sn.word_count > 0 AND sn.readability_words = 0
+			)
+			OR (
+				EXISTS (
+					SELECT 1 FROM structure_nodes sn
+					WHERE sn.title_content_id = tc.id AND sn.node_type IN ('section', 'appendix')
+				)
+				AND NOT EXISTS (SELECT 1 FROM section_texts st WHERE st.title_content_id = tc.id)
+			)
+		ORDER BY tc.content_date DESC
+	`).Scan(&contentIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to find unparsed title contents: %w", err)
+	}
+
+	log.Printf("[STRUCTURE] Found %d title contents without structure", len(contentIDs))
+
+	failed := 0
+	for _, contentID := range contentIDs {
+		var content models.TitleContent
+		if err := database.DB.Where("id = ?", contentID).First(&content).Error; err != nil {
+			log.Printf("[STRUCTURE] Failed to load title content %s: %v", contentID, err)
+			failed++
+			continue
+		}
+
+		count, err := s.storeStoredStructure(&content)
+		if err != nil {
+			log.Printf("[STRUCTURE] Failed to parse title content %s: %v", contentID, err)
+			failed++
+			continue
+		}
+		log.Printf("[STRUCTURE] Stored %d structure nodes for title content %s", count, contentID)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d title contents failed to parse", failed)
+	}
+	return nil
+}
+
+// StartMissingStructures runs StoreMissingStructures in the background. It
+// holds the import lock while it runs, so it never replaces the structure of
+// content an import is still storing; ErrImportInProgress is returned when
+// another import holds it.
+func (s *StructureService) StartMissingStructures() error {
+	lock, err := database.TryAdvisoryLock(context.Background(), database.ImportLockKey)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return ErrImportInProgress
+	}
+
+	go func() {
+		defer func() {
+			if err := lock.Release(); err != nil {
+				log.Printf("[STRUCTURE] Failed to release import lock: %v", err)
+			}
+		}()
+
+		if err := s.StoreMissingStructures(); err != nil {
+			log.Printf("[STRUCTURE] Structure import failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// storeStoredStructure parses a content version from the blob store.
+func (s *StructureService) storeStoredStructure(content *models.TitleContent) (int, error) {
+	xml, err := OpenTitleXML(context.Background(), content)
+	if err != nil {
+		return 0, err
+	}
+	defer xml.Close()
+	return s.StoreStructure(content, xml)
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
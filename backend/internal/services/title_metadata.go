@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ecfr-analyzer/internal/database"
+	"ecfr-analyzer/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TitleAmendmentStats summarises the dates on which a title was amended,
+// according to its stored amendment events.
+type TitleAmendmentStats struct {
+	TitleID            uuid.UUID
+	AmendmentCount     int
+	AmendmentsLastYear int
+	FirstAmendedOn     *time.Time
+	LastAmendedOn      *time.Time
+	AverageDaysBetween *float64
+}
+
+// upsertTitleMetadata stores the published metadata of a title, creating the
+// title if it is new. Whenever a field changes, the new metadata is recorded
+// as a version in the title metadata history. The stored title is returned.
+func upsertTitleMetadata(runID *uuid.UUID, published models.Title) (*models.Title, error) {
+	var title models.Title
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var existing []models.Title
+		if err := tx.Where("number = ?", published.Number).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+
+		if len(existing) == 0 {
+			title = published
+			if err := tx.Create(&title).Error; err != nil {
+				return err
+			}
+			return recordTitleMetadata(tx, runID, &title, []string{"created"})
+		}
+
+		title = existing[0]
+		updates := make(map[string]interface{})
+		var changed []string
+		if title.Name != published.Name {
+			updates["name"] = published.Name
+			changed = append(changed, "name")
+		}
+		if title.Reserved != published.Reserved {
+			updates["reserved"] = published.Reserved
+			changed = append(changed, "reserved")
+		}
+		for _, field := range []struct {
+			column    string
+			stored    **time.Time
+			published *time.Time
+		}{
+			{"latest_amended_on", &title.LatestAmendedOn, published.LatestAmendedOn},
+			{"latest_issue_date", &title.LatestIssueDate, published.LatestIssueDate},
+			{"up_to_date_as_of", &title.UpToDateAsOf, published.UpToDateAsOf},
+		} {
+			if !sameDate(*field.stored, field.published) {
+				updates[field.column] = field.published
+				*field.stored = field.published
+				changed = append(changed, field.column)
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+
+		title.Name = published.Name
+		title.Reserved = published.Reserved
+		if err := tx.Model(&title).Updates(updates).Error; err != nil {
+			return err
+		}
+		return recordTitleMetadata(tx, runID, &title, changed)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store title %d: %w", published.Number, err)
+	}
+	return &title, nil
+}
+
+func recordTitleMetadata(tx *gorm.DB, runID *uuid.UUID, title *models.Title, changed []string) error {
+	version := &models.TitleMetadataVersion{
+		TitleID:         title.ID,
+		TitleNumber:     title.Number,
+		RunID:           runID,
+		Name:            title.Name,
+		Reserved:        title.Reserved,
+		LatestAmendedOn: title.LatestAmendedOn,
+		LatestIssueDate: title.LatestIssueDate,
+		UpToDateAsOf:    title.UpToDateAsOf,
+		ChangedFields:   strings.Join(changed, ","),
+	}
+	return tx.Create(version).Error
+}
+
+// TitleMetadataHistory returns the recorded metadata versions of a title,
+// newest first.
+func TitleMetadataHistory(titleID uuid.UUID, limit int) ([]models.TitleMetadataVersion, error) {
+	var versions []models.TitleMetadataVersion
+	err := database.DB.Where("title_id = ?", titleID).
+		Order("recorded_at DESC").
+		Limit(limit).
+		Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch title metadata history: %w", err)
+	}
+	return versions, nil
+}
+
+// TitleAmendmentStatsByTitle counts the distinct dates on which each title was
+// amended. Amendment events are synced from the versioner versions list, so
+// the dates are the title's full amendment history rather than only those a
+// title import happened to see.
+func TitleAmendmentStatsByTitle() (map[uuid.UUID]TitleAmendmentStats, error) {
+	var rows []TitleAmendmentStats
+	err := database.DB.Raw(`
+		SELECT
+			title_id,
+			COUNT(DISTINCT amendment_date) AS amendment_count,
+			COUNT(DISTINCT amendment_date) FILTER (
+				WHERE amendment_date >= CURRENT_DATE - INTERVAL '1 year'
+			) AS amendments_last_year,
+			MIN(amendment_date) AS first_amended_on,
+			MAX(amendment_date) AS last_amended_on
+		FROM amendment_events
+		GROUP BY title_id
+	`).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise title amendments: %w", err)
+	}
+
+	stats := make(map[uuid.UUID]TitleAmendmentStats, len(rows))
+	for _, row := range rows {
+		if row.AmendmentCount > 1 && row.FirstAmendedOn != nil && row.LastAmendedOn != nil {
+			days := row.LastAmendedOn.Sub(*row.FirstAmendedOn).Hours() / 24 / float64(row.AmendmentCount-1)
+			row.AverageDaysBetween = &days
+		}
+		stats[row.TitleID] = row
+	}
+	return stats, nil
+}
+
+func sameDate(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
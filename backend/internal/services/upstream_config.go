@@ -0,0 +1,43 @@
+package services
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// UpstreamConfig describes where eCFR data is fetched from. Pointing the base
+// URLs at a local stand-in server (see internal/ecfrfake) allows imports to run
+// without the live government sites.
+type UpstreamConfig struct {
+	ECFRBaseURL string
+	BulkBaseURL string
+	// Transport is used by every upstream HTTP client; nil means
+	// SharedUpstreamTransport(), which retries, rate limits and guards the
+	// live upstreams.
+	Transport http.RoundTripper
+}
+
+func (c UpstreamConfig) transport() http.RoundTripper {
+	if c.Transport == nil {
+		return SharedUpstreamTransport()
+	}
+	return c.Transport
+}
+
+// DefaultUpstreamConfig returns the live eCFR and govinfo endpoints unless they
+// are overridden with ECFR_BASE_URL and GOVINFO_BULK_BASE_URL.
+func DefaultUpstreamConfig() UpstreamConfig {
+	return UpstreamConfig{
+		ECFRBaseURL: envURL("ECFR_BASE_URL", BaseURL),
+		BulkBaseURL: envURL("GOVINFO_BULK_BASE_URL", BulkRepositoryBaseURL),
+	}
+}
+
+func envURL(name, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	return strings.TrimRight(value, "/")
+}
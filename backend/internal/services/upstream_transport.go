@@ -0,0 +1,478 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the upstream while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// UpstreamPolicy controls how ResilientTransport retries, throttles and
+// protects upstream hosts. Limits and breakers apply per host.
+type UpstreamPolicy struct {
+	// MaxAttempts includes the first attempt.
+	MaxAttempts int
+	// BaseDelay and MaxDelay bound the exponential backoff between attempts;
+	// the actual delay is chosen at random up to the bound.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest Retry-After that is waited out; longer
+	// ones end the retries.
+	MaxRetryAfter time.Duration
+	// AttemptTimeout is how long an attempt may wait for response headers,
+	// and ReadTimeout how long a response body may stall.
+	AttemptTimeout time.Duration
+	ReadTimeout    time.Duration
+	// RequestsPerSecond and Burst configure the token bucket.
+	RequestsPerSecond float64
+	Burst             int
+	// The breaker opens after FailureThreshold consecutive failures and lets
+	// a single probe through after OpenDuration.
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+// DefaultUpstreamPolicy returns the policy for the live upstreams. The rate
+// limit and attempts can be tuned with UPSTREAM_RATE_LIMIT (requests per
+// second), UPSTREAM_RATE_BURST and UPSTREAM_MAX_ATTEMPTS.
+func DefaultUpstreamPolicy() UpstreamPolicy {
+	policy := UpstreamPolicy{
+		MaxAttempts:       5,
+		BaseDelay:         500 * time.Millisecond,
+		MaxDelay:          30 * time.Second,
+		MaxRetryAfter:     2 * time.Minute,
+		AttemptTimeout:    Timeout,
+		ReadTimeout:       time.Minute,
+		RequestsPerSecond: 5,
+		Burst:             10,
+		FailureThreshold:  5,
+		OpenDuration:      time.Minute,
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("UPSTREAM_RATE_LIMIT"), 64); err == nil && value > 0 {
+		policy.RequestsPerSecond = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("UPSTREAM_RATE_BURST")); err == nil && value > 0 {
+		policy.Burst = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("UPSTREAM_MAX_ATTEMPTS")); err == nil && value > 0 {
+		policy.MaxAttempts = value
+	}
+	return policy
+}
+
+var (
+	sharedTransportOnce sync.Once
+	sharedTransport     *ResilientTransport
+)
+
+// SharedUpstreamTransport returns the process-wide transport for the live
+// upstreams, so every client and worker draws from the same rate limits and
+// circuit breakers.
+func SharedUpstreamTransport() *ResilientTransport {
+	sharedTransportOnce.Do(func() {
+		sharedTransport = NewResilientTransport(http.DefaultTransport, DefaultUpstreamPolicy())
+	})
+	return sharedTransport
+}
+
+// ResilientTransport is an http.RoundTripper that rate limits requests,
+// retries transient failures (network errors, timeouts, 429 and 5xx) with
+// exponential backoff and jitter, honours Retry-After, and stops calling a
+// host whose circuit breaker has opened. Only requests without a body are
+// retried.
+type ResilientTransport struct {
+	base   http.RoundTripper
+	policy UpstreamPolicy
+
+	mutex sync.Mutex
+	hosts map[string]*upstreamHost
+}
+
+func NewResilientTransport(base http.RoundTripper, policy UpstreamPolicy) *ResilientTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &ResilientTransport{
+		base:   base,
+		policy: policy,
+		hosts:  make(map[string]*upstreamHost),
+	}
+}
+
+type upstreamHost struct {
+	limiter *tokenBucket
+	breaker *circuitBreaker
+}
+
+func (t *ResilientTransport) host(name string) *upstreamHost {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host, ok := t.hosts[name]
+	if !ok {
+		host = &upstreamHost{
+			limiter: newTokenBucket(t.policy.RequestsPerSecond, t.policy.Burst),
+			breaker: &circuitBreaker{
+				name:      name,
+				threshold: t.policy.FailureThreshold,
+				cooldown:  t.policy.OpenDuration,
+			},
+		}
+		t.hosts[name] = host
+	}
+	return host
+}
+
+func (t *ResilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := t.host(req.URL.Host)
+	attempts := t.policy.MaxAttempts
+	if req.Body != nil && req.Body != http.NoBody {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := host.breaker.allow(); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Host, err)
+		}
+		if err := host.limiter.wait(ctx); err != nil {
+			host.breaker.release()
+			return nil, err
+		}
+
+		resp, err := t.attempt(req)
+		retryable, outcome := classifyAttempt(ctx, resp, err)
+		if outcome == attemptNeutral {
+			host.breaker.release()
+		} else {
+			host.breaker.record(outcome == attemptFailed)
+		}
+		// Once the breaker opens, the last failure is more useful than
+		// ErrCircuitOpen on the next attempt
+		if !retryable || attempt >= attempts || host.breaker.isOpen() {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > t.policy.MaxRetryAfter {
+					return resp, nil
+				}
+				delay = max(delay, retryAfter)
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		log.Printf("[UPSTREAM] %s %s attempt %d/%d failed (%s), retrying in %s",
+			req.Method, req.URL.Redacted(), attempt, attempts, reason, delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt makes one request, failing it if no response headers arrive within
+// AttemptTimeout. Reading the body fails once it stalls for ReadTimeout.
+func (t *ResilientTransport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	var timer *time.Timer
+	if t.policy.AttemptTimeout > 0 {
+		timer = time.AfterFunc(t.policy.AttemptTimeout, cancel)
+	}
+	resp, err := t.base.RoundTrip(req.Clone(ctx))
+	timedOut := timer != nil && !timer.Stop()
+	if err != nil {
+		cancel()
+		if timedOut && req.Context().Err() == nil {
+			return nil, fmt.Errorf("no response within %s: %w", t.policy.AttemptTimeout, ErrUpstreamTimeout)
+		}
+		return nil, err
+	}
+	resp.Body = newWatchedBody(resp.Body, cancel, t.policy.ReadTimeout)
+	return resp, nil
+}
+
+// ErrUpstreamTimeout is returned when an upstream stops responding.
+var ErrUpstreamTimeout = errors.New("upstream timed out")
+
+// watchedBody cancels its request when reading stalls for longer than
+// timeout, and releases the request's context once closed.
+type watchedBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+
+	mutex   sync.Mutex
+	stalled bool
+}
+
+func newWatchedBody(body io.ReadCloser, cancel context.CancelFunc, timeout time.Duration) *watchedBody {
+	w := &watchedBody{ReadCloser: body, cancel: cancel, timeout: timeout}
+	if timeout > 0 {
+		w.timer = time.AfterFunc(timeout, func() {
+			w.mutex.Lock()
+			w.stalled = true
+			w.mutex.Unlock()
+			cancel()
+		})
+	}
+	return w
+}
+
+func (w *watchedBody) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if w.timer == nil {
+		return n, err
+	}
+	w.mutex.Lock()
+	stalled := w.stalled
+	w.mutex.Unlock()
+	if stalled && err != nil && err != io.EOF {
+		return n, fmt.Errorf("no data for %s: %w", w.timeout, ErrUpstreamTimeout)
+	}
+	w.timer.Reset(w.timeout)
+	return n, err
+}
+
+func (w *watchedBody) Close() error {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	err := w.ReadCloser.Close()
+	w.cancel()
+	return err
+}
+
+// Outcomes of an attempt for the circuit breaker. Neutral attempts, cancelled
+// by the caller or answered with a 4xx, say nothing about the upstream's
+// health: they neither trip the breaker nor reset its failure streak.
+const (
+	attemptSucceeded = iota
+	attemptFailed
+	attemptNeutral
+)
+
+// classifyAttempt reports whether an attempt is worth retrying and what it
+// tells the circuit breaker about the upstream.
+func classifyAttempt(ctx context.Context, resp *http.Response, err error) (retryable bool, outcome int) {
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, attemptNeutral
+		}
+		return true, attemptFailed
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true, attemptNeutral
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, attemptFailed
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		return false, attemptFailed
+	case resp.StatusCode >= 400:
+		return false, attemptNeutral
+	}
+	return false, attemptSucceeded
+}
+
+// backoff returns a random delay up to BaseDelay * 2^(attempt-1), capped at
+// MaxDelay ("full jitter").
+func (t *ResilientTransport) backoff(attempt int) time.Duration {
+	bound := float64(t.policy.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if bound > float64(t.policy.MaxDelay) {
+		bound = float64(t.policy.MaxDelay)
+	}
+	if bound < 1 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(bound)))
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay seconds and an
+// HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// tokenBucket allows rate requests per second on average with bursts of up
+// to burst requests. Waiting callers reserve tokens in turn.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b.rate <= 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mutex.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// Hand the reserved token back
+		b.mutex.Lock()
+		b.tokens++
+		b.mutex.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// circuitBreaker opens after threshold consecutive failures, rejects requests
+// for cooldown, then lets one probe through: its success closes the breaker
+// and its failure opens it again.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *circuitBreaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = circuitHalfOpen
+		log.Printf("[UPSTREAM] Circuit for %s half-open, sending a probe", b.name)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state == circuitOpen
+}
+
+// release gives up a slot taken by allow without recording a result, leaving
+// the state and failure streak as they are.
+func (b *circuitBreaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) record(failure bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if !failure {
+		if b.state == circuitHalfOpen {
+			log.Printf("[UPSTREAM] Circuit for %s closed", b.name)
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		if b.state != circuitOpen {
+			log.Printf("[UPSTREAM] Circuit for %s opened after %d consecutive failures", b.name, b.failures)
+		}
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// wordCounter counts the words of an XML document written to it in any number
+// of chunks, treating every tag as whitespace. Words are runs of non-space
+// runes as in strings.Fields.
+//
+// A '<' that is never closed by a '>' is not a tag, so while inside a tag the
+// counter also tracks what the count would be if the tag turned out to be
+// text, and uses that if the document ends first.
+type wordCounter struct {
+	words  int
+	inWord bool
+	inTag  bool
+
+	// State as if the open tag were text
+	textWords  int
+	textInWord bool
+
+	// Bytes of a rune split across writes
+	pending []byte
+}
+
+func newWordCounter() *wordCounter {
+	return &wordCounter{}
+}
+
+func (c *wordCounter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(c.pending) > 0 && len(p) > 0 {
+		c.pending = append(c.pending, p[0])
+		p = p[1:]
+		if utf8.FullRune(c.pending) {
+			r, size := utf8.DecodeRune(c.pending)
+			rest := append([]byte(nil), c.pending[size:]...)
+			c.pending = c.pending[:0]
+			c.rune(r)
+			c.decode(rest)
+		}
+	}
+	c.decode(p)
+	return n, nil
+}
+
+// decode counts the complete runes of p and keeps an incomplete last rune for
+// the next write.
+func (c *wordCounter) decode(p []byte) {
+	for len(p) > 0 {
+		if p[0] < utf8.RuneSelf {
+			c.rune(rune(p[0]))
+			p = p[1:]
+			continue
+		}
+		if !utf8.FullRune(p) {
+			c.pending = append(c.pending, p...)
+			return
+		}
+		r, size := utf8.DecodeRune(p)
+		c.rune(r)
+		p = p[size:]
+	}
+}
+
+func (c *wordCounter) rune(r rune) {
+	if c.inTag {
+		countRune(&c.textWords, &c.textInWord, r)
+		if r == '>' {
+			c.inTag = false
+			c.inWord = false
+		}
+		return
+	}
+	if r == '<' {
+		c.inTag = true
+		c.textWords, c.textInWord = c.words, c.inWord
+		countRune(&c.textWords, &c.textInWord, r)
+		return
+	}
+	countRune(&c.words, &c.inWord, r)
+}
+
+func countRune(words *int, inWord *bool, r rune) {
+	if unicode.IsSpace(r) {
+		*inWord = false
+		return
+	}
+	if !*inWord {
+		*words++
+		*inWord = true
+	}
+}
+
+// Count returns the number of words written so far, treating an unclosed
+// '<' at the end as text.
+func (c *wordCounter) Count() int {
+	words, inWord := c.words, c.inWord
+	textWords, textInWord := c.textWords, c.textInWord
+	if len(c.pending) > 0 {
+		// An incomplete rune decodes as RuneError, which is not a space
+		if c.inTag {
+			countRune(&textWords, &textInWord, utf8.RuneError)
+		} else {
+			countRune(&words, &inWord, utf8.RuneError)
+		}
+	}
+	if c.inTag {
+		return textWords
+	}
+	return words
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"strings"
+)
+
+// Diff operations reported in a DiffChunk.
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// maxDiffTokens bounds the word diff, whose running time grows with the
+// number of words times the edit distance. Larger changed spans are reported
+// as a single delete followed by a single insert.
+const maxDiffTokens = 4000
+
+type DiffChunk struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffWords returns a word-level diff between two texts. Runs of the same
+// operation are merged into one chunk.
+func DiffWords(before, after string) []DiffChunk {
+	a := strings.Fields(before)
+	b := strings.Fields(after)
+
+	// Trim the common prefix and suffix before running the diff
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	builder := &chunkBuilder{}
+	builder.add(DiffEqual, a[:prefix])
+
+	middleA := a[prefix : len(a)-suffix]
+	middleB := b[prefix : len(b)-suffix]
+	if len(middleA)+len(middleB) > maxDiffTokens {
+		builder.add(DiffDelete, middleA)
+		builder.add(DiffInsert, middleB)
+	} else {
+		for _, edit := range myersDiff(middleA, middleB) {
+			builder.add(edit.op, edit.words)
+		}
+	}
+
+	builder.add(DiffEqual, a[len(a)-suffix:])
+	builder.flush()
+	return builder.chunks
+}
+
+type wordEdit struct {
+	op    string
+	words []string
+}
+
+// myersDiff implements the linear-space variant of the O((N+M)D) algorithm
+// from Myers' "An O(ND) Difference Algorithm and Its Variations": instead of
+// keeping a trace of every step to backtrack through, it finds a point on a
+// shortest edit script by searching from both ends, and diffs the halves on
+// either side of it the same way.
+func myersDiff(a, b []string) []wordEdit {
+	return appendMyersDiff(nil, a, b)
+}
+
+func appendMyersDiff(edits []wordEdit, a, b []string) []wordEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	if prefix > 0 {
+		edits = append(edits, wordEdit{op: DiffEqual, words: a[:prefix]})
+	}
+
+	middleA := a[prefix : len(a)-suffix]
+	middleB := b[prefix : len(b)-suffix]
+	switch {
+	case len(middleA) == 0 && len(middleB) == 0:
+	case len(middleA) == 0:
+		edits = append(edits, wordEdit{op: DiffInsert, words: middleB})
+	case len(middleB) == 0:
+		edits = append(edits, wordEdit{op: DiffDelete, words: middleA})
+	default:
+		x, y, ok := middleSnake(middleA, middleB)
+		if !ok {
+			edits = append(edits, wordEdit{op: DiffDelete, words: middleA}, wordEdit{op: DiffInsert, words: middleB})
+			break
+		}
+		edits = appendMyersDiff(edits, middleA[:x], middleB[:y])
+		edits = appendMyersDiff(edits, middleA[x:], middleB[y:])
+	}
+
+	if suffix > 0 {
+		edits = append(edits, wordEdit{op: DiffEqual, words: a[len(a)-suffix:]})
+	}
+	return edits
+}
+
+// middleSnake walks a shortest edit script forward from the start and
+// backward from the end of a and b at the same time, and returns the point
+// where the two walks meet. ok is false when a and b have no word in common.
+// Only the furthest point reached on each diagonal is kept, so memory grows
+// with N+M rather than with the square of the edit distance.
+func middleSnake(a, b []string) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	// forward[offset+k] is the furthest x reached on diagonal k = x-y from the
+	// start; backward[offset+k] the same from the end, in reversed coordinates
+	forward := make([]int, 2*maxD+3)
+	backward := make([]int, 2*maxD+3)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// With an odd delta the walks meet during a forward step, otherwise
+	// during a backward one
+	odd := delta%2 != 0
+	// Diagonals that ran off the edge of the grid are not walked again
+	forwardStart, forwardEnd, backwardStart, backwardEnd := 0, 0, 0, 0
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > m:
+				forwardStart += 2
+			case odd:
+				reverseK := offset + delta - k
+				if reverseK >= 0 && reverseK < len(backward) && backward[reverseK] != -1 && x >= n-backward[reverseK] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > m:
+				backwardStart += 2
+			case !odd:
+				forwardK := offset + delta - k
+				if forwardK >= 0 && forwardK < len(forward) && forward[forwardK] != -1 && forward[forwardK] >= n-x {
+					forwardX := forward[forwardK]
+					return forwardX, forwardX - (forwardK - offset), true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+type chunkBuilder struct {
+	chunks    []DiffChunk
+	pendingOp string
+	pending   []string
+}
+
+func (c *chunkBuilder) add(op string, words []string) {
+	if len(words) == 0 {
+		return
+	}
+	if op != c.pendingOp {
+		c.flush()
+		c.pendingOp = op
+	}
+	c.pending = append(c.pending, words...)
+}
+
+func (c *chunkBuilder) flush() {
+	if len(c.pending) > 0 {
+		c.chunks = append(c.chunks, DiffChunk{Op: c.pendingOp, Text: strings.Join(c.pending, " ")})
+	}
+	c.pending = nil
+}